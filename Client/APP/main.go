@@ -3,9 +3,11 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -21,6 +23,11 @@ func main() {
 	var ioTimeoutAfterMigrate time.Duration
 	var dialTimeout time.Duration
 	var dialBackoff time.Duration
+	var probeInterval time.Duration
+	var probeQuiet time.Duration
+	var dualPathWindow time.Duration
+	var followLocalAddr bool
+	var preferIfaces string
 	var quiet bool
 	var stayConnected bool
 
@@ -31,6 +38,11 @@ func main() {
 	flag.DurationVar(&ioTimeoutAfterMigrate, "io-timeout-after-migrate", 250*time.Millisecond, "per-ping io timeout after migrate")
 	flag.DurationVar(&dialTimeout, "dial-timeout", 900*time.Millisecond, "per-dial timeout")
 	flag.DurationVar(&dialBackoff, "dial-backoff", 50*time.Millisecond, "dial retry backoff")
+	flag.DurationVar(&probeInterval, "probe-interval", 20*time.Millisecond, "probe interval towards the armed peer after migrate (<0 disables)")
+	flag.DurationVar(&probeQuiet, "probe-quiet", 50*time.Millisecond, "old peer silence required before cutover on probe reply")
+	flag.DurationVar(&dualPathWindow, "dual-path-window", 200*time.Millisecond, "accept datagrams from both old and new peer around cutover (<0 disables)")
+	flag.BoolVar(&followLocalAddr, "follow-local-addr", false, "rebind the local UDP socket when network interfaces/addresses change (netlink)")
+	flag.StringVar(&preferIfaces, "prefer-ifaces", "", "comma-separated interface name prefixes in order of preference, e.g. wlan,wwan")
 	flag.BoolVar(&quiet, "quiet", false, "reduce logs")
 	flag.BoolVar(&stayConnected, "stay-connected", false, "do not end session on io errors; reopen stream and keep trying")
 	flag.Parse()
@@ -43,14 +55,25 @@ func main() {
 		stayConnected = true
 	}
 
-	m := &wrapper.Manager{Target: target, Quiet: quiet, ClientID: "car", DialTimeout: dialTimeout, DialBackoff: dialBackoff}
+	m := &wrapper.Manager{Target: target, Quiet: quiet, ClientID: "car", DialTimeout: dialTimeout, DialBackoff: dialBackoff, ProbeInterval: probeInterval, ProbeQuietAfter: probeQuiet, DualPathWindow: dualPathWindow, FollowLocalAddr: followLocalAddr}
+	if preferIfaces != "" {
+		m.LocalAddrPolicy = wrapper.PreferInterfaces(strings.Split(preferIfaces, ",")...)
+	}
 
 	var lastEchoBeforeOutage time.Time
 	var awaitingFirstAfter bool
 
 	_ = m.Run(context.Background(), func(ctx context.Context, s *wrapper.Session) error {
+		// 迁移过程的各阶段事件只打 trace；channel 在 session 结束后关闭，goroutine 随之退出。
+		go func() {
+			for ev := range s.Events() {
+				wrapper.Tracef("app event type=%s id=%s peer=%v reason=%s err=%v", ev.Type, ev.MigrationID, ev.Peer, ev.Reason, ev.Err)
+			}
+		}()
+
+		// 业务数据走 BufferedStream：迁移中断期间的写入先缓存，cutover 且新路径可达后按序回放。
 		openData := func() (io.ReadWriteCloser, *bufio.Reader, *bufio.Writer, any, error) {
-			st, err := s.Conn.OpenStreamSync(ctx)
+			st, err := s.OpenBufferedStream(ctx)
 			if err != nil {
 				return nil, nil, nil, nil, err
 			}
@@ -73,20 +96,33 @@ func main() {
 		pingID := 0
 		curIOTimeout := ioTimeout
 		curInterval := interval
-		migrated := false
+		// 同一 session 内可能连续迁移多次（A→B→C…）：每次迁移开始时收紧 IO deadline/间隔，结束后恢复。
+		migBegun := s.MigrationBegun()
+		var mig wrapper.Migration
+		migrating := false
 		cutoverDone := false
 		for {
-			if !migrated {
-				select {
-				case <-s.MigrateSeen:
-					migrated = true
-					wrapper.Tracef("app migrateSeen")
+			select {
+			case <-migBegun:
+				migBegun = s.MigrationBegun()
+				if cur, ok := s.CurrentMigration(); ok {
+					mig, migrating, cutoverDone = cur, true, false
+					wrapper.Tracef("app migration begun id=%s target=%s", mig.ID, mig.Target)
 					if ioTimeoutAfterMigrate > 0 && ioTimeoutAfterMigrate < curIOTimeout {
 						curIOTimeout = ioTimeoutAfterMigrate
 					}
 					if intervalAfterMigrate > 0 && intervalAfterMigrate < curInterval {
 						curInterval = intervalAfterMigrate
 					}
+				}
+			default:
+			}
+			if migrating {
+				select {
+				case <-mig.Done:
+					migrating = false
+					curIOTimeout, curInterval = ioTimeout, interval
+					wrapper.Tracef("app migration done id=%s", mig.ID)
 				default:
 				}
 			}
@@ -118,7 +154,7 @@ func main() {
 				_ = ds.SetWriteDeadline(start.Add(curIOTimeout))
 			}
 			if _, err := w.WriteString(payload + "\n"); err != nil {
-				if migrated && !cutoverDone {
+				if migrating && !cutoverDone {
 					if s.CutoverToArmedPeer() {
 						cutoverDone = true
 						wrapper.Tracef("app cutover to armed peer")
@@ -137,7 +173,7 @@ func main() {
 				continue
 			}
 			if err := w.Flush(); err != nil {
-				if migrated && !cutoverDone {
+				if migrating && !cutoverDone {
 					if s.CutoverToArmedPeer() {
 						cutoverDone = true
 						wrapper.Tracef("app cutover to armed peer")
@@ -161,7 +197,7 @@ func main() {
 			}
 			echoLine, err := r.ReadString('\n')
 			if err != nil {
-				if migrated && !cutoverDone {
+				if migrating && !cutoverDone {
 					if s.CutoverToArmedPeer() {
 						cutoverDone = true
 						wrapper.Tracef("app cutover to armed peer")
@@ -171,6 +207,10 @@ func main() {
 					awaitingFirstAfter = true
 					wrapper.Tracef("app read err; awaitingFirstAfter=true err=%v", err)
 				}
+				// 中断期间的 ping 在缓存里，恢复后才回放：读超时不重开 stream（重开会丢掉缓存），继续等回显。
+				if migrating && isTimeout(err) {
+					continue
+				}
 				if !stayConnected {
 					return nil
 				}
@@ -187,6 +227,7 @@ func main() {
 				dt := now.Sub(lastEchoBeforeOutage)
 				fmt.Printf("[客户端] 汇总：服务中断 %dms\n", dt.Milliseconds())
 				wrapper.Tracef("app recovered; downtime=%dms", dt.Milliseconds())
+				wrapper.Tracef("app recovered; paths=%+v", s.PathStats())
 				awaitingFirstAfter = false
 			}
 			lastEchoBeforeOutage = now
@@ -200,6 +241,11 @@ func main() {
 	})
 }
 
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
 func envOr(k, def string) string {
 	v := strings.TrimSpace(os.Getenv(k))
 	if v == "" {
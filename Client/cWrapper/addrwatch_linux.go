@@ -0,0 +1,129 @@
+//go:build linux
+
+package wrapper
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rtnetlink 多播组（linux/rtnetlink.h），syscall 包未导出。
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// NetlinkAddrSource 通过 rtnetlink 订阅本机接口（RTM_NEWLINK/DELLINK）与地址（RTM_NEWADDR/DELADDR）变化。
+// 可用 dummy/veth 接口验证：ip link add d0 type dummy && ip addr add 10.9.0.2/24 dev d0 && ip link set d0 up。
+type NetlinkAddrSource struct{}
+
+func (NetlinkAddrSource) Changes(ctx context.Context) (<-chan AddrChange, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	// 非阻塞 + os.File：读操作走 runtime poller，ctx 结束时 Close 能让阻塞的 Read 立即返回。
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "netlink-route")
+
+	out := make(chan AddrChange, 16)
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+	go func() {
+		defer close(out)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					tracef("netlink watcher stopped err=%v", err)
+				}
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				ch, ok := parseNetlinkChange(m)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- ch:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+func parseNetlinkChange(m syscall.NetlinkMessage) (AddrChange, bool) {
+	now := time.Now()
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(m.Data) < syscall.SizeofIfInfomsg {
+			return AddrChange{}, false
+		}
+		info := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		return AddrChange{Time: now, Iface: ifaceName(int(info.Index)), Added: m.Header.Type == syscall.RTM_NEWLINK}, true
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return AddrChange{}, false
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return AddrChange{}, false
+		}
+		var ip net.IP
+		for _, a := range attrs {
+			// IPv4 点对点接口上 IFA_LOCAL 才是本端地址；其他情况 IFA_ADDRESS 即本端地址。
+			switch a.Attr.Type {
+			case syscall.IFA_LOCAL:
+				ip = net.IP(append([]byte(nil), a.Value...))
+			case syscall.IFA_ADDRESS:
+				if ip == nil {
+					ip = net.IP(append([]byte(nil), a.Value...))
+				}
+			}
+		}
+		if ip == nil {
+			return AddrChange{}, false
+		}
+		return AddrChange{Time: now, Iface: ifaceName(int(ifa.Index)), Addr: ip, Added: m.Header.Type == syscall.RTM_NEWADDR}, true
+	}
+	return AddrChange{}, false
+}
+
+// ifaceName 把接口序号转成名字；接口已被删除时退回序号。
+func ifaceName(index int) string {
+	if ifc, err := net.InterfaceByIndex(index); err == nil {
+		return ifc.Name
+	}
+	return "if" + strconv.Itoa(index)
+}
+
+func defaultAddrSource() AddrSource { return NetlinkAddrSource{} }
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package wrapper
+
+import (
+	"context"
+	"errors"
+)
+
+// NetlinkAddrSource 只在 Linux 上可用；其他平台请通过 Manager.LocalAddrSource 注入地址变化来源。
+type NetlinkAddrSource struct{}
+
+func (NetlinkAddrSource) Changes(ctx context.Context) (<-chan AddrChange, error) {
+	return nil, errors.New("netlink address watcher is only supported on linux")
+}
+
+func defaultAddrSource() AddrSource { return NetlinkAddrSource{} }
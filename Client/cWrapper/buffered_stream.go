@@ -0,0 +1,290 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ErrOutageBufferFull 表示迁移中断期间缓存的字节数已达到上限，本次写入被拒绝。
+var ErrOutageBufferFull = errors.New("outage buffer full")
+
+// BufferedStream 是一个在迁移中断期间“先缓存、后回放”的业务 stream。
+//
+// 语义：
+//   - 正常时：Write 直接写入底层 QUIC stream。
+//   - MigrateSeen 之后、cutover 成功且新路径确认可达之前（中断窗口）：
+//     Write 只把数据放入内存缓存并立即返回，业务不会看到写错误。
+//   - 中断结束后：后台按写入顺序把缓存回放到同一条 stream；回放完成前的新写入也会排队，保证顺序。
+//
+// 限制：
+//   - 缓存总字节数超过 maxBytes 时，Write 返回 ErrOutageBufferFull。
+//   - 驻留超过 maxAge 的消息在回放前被丢弃（计入 Dropped）。
+//
+// 注意：透明迁移下 QUIC session 不重建，因此回放的目标仍是这条 stream；
+// QUIC 自身负责可靠传输，这里只解决“中断期间业务写入报错/被丢弃”的问题。
+type BufferedStream struct {
+	st   quic.Stream
+	gate *outageGate
+	// done 在 session 结束或 Close 时关闭，用于结束回放 goroutine。
+	done    <-chan struct{}
+	closeCh chan struct{}
+
+	maxBytes int
+	maxAge   time.Duration
+
+	mu           sync.Mutex
+	pending      []bufferedWrite
+	pendingBytes int
+	draining     bool
+	dropped      int
+	err          error
+	closed       bool
+	// writing 表示有写者（直接写入的 Write 或回放）正在写底层 stream，idle 在它写完时关闭。
+	// 写底层 stream 时不持有 mu（写入可能因流控/中断阻塞）；同一时刻只有一个写者，保证顺序。
+	writing bool
+	idle    chan struct{}
+}
+
+type bufferedWrite struct {
+	b  []byte
+	at time.Time
+}
+
+// OpenBufferedStream 打开一条新的业务 stream，并用中断缓存包装它。
+func (s *Session) OpenBufferedStream(ctx context.Context) (*BufferedStream, error) {
+	st, err := s.Conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newBufferedStream(st, s.outage, s.Conn.Context().Done(), s.bufBytes, s.bufAge), nil
+}
+
+func newBufferedStream(st quic.Stream, gate *outageGate, done <-chan struct{}, maxBytes int, maxAge time.Duration) *BufferedStream {
+	return &BufferedStream{
+		st:       st,
+		gate:     gate,
+		done:     done,
+		closeCh:  make(chan struct{}),
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+}
+
+func (b *BufferedStream) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	for {
+		if b.closed {
+			b.mu.Unlock()
+			return 0, errors.New("buffered stream closed")
+		}
+		if err := b.err; err != nil {
+			b.mu.Unlock()
+			return 0, err
+		}
+		active, recovered := b.gate.state()
+		if active || len(b.pending) > 0 {
+			err := b.enqueueLocked(p, recovered, false)
+			b.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+		if !b.writing {
+			break
+		}
+		b.waitIdleLocked()
+	}
+	b.beginWriteLocked()
+	b.mu.Unlock()
+
+	n, err := b.st.Write(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endWriteLocked()
+	if err == nil || b.closed {
+		return n, err
+	}
+	// 写入过程中进入了中断态（例如 deadline 在迁移窗口内到期）：剩余部分转入缓存。
+	// 写入期间进入缓存的数据都排在它之后，所以放在最前面。
+	if active, recovered := b.gate.state(); active {
+		if qerr := b.enqueueLocked(p[n:], recovered, true); qerr != nil {
+			return n, err
+		}
+		tracef("buffered stream: write err during outage, buffered %dB err=%v", len(p)-n, err)
+		return len(p), nil
+	}
+	return n, err
+}
+
+// beginWriteLocked/endWriteLocked 标记写者的开始与结束；waitIdleLocked 等当前写者写完（期间释放 mu）。
+func (b *BufferedStream) beginWriteLocked() {
+	b.writing = true
+	b.idle = make(chan struct{})
+}
+
+func (b *BufferedStream) endWriteLocked() {
+	b.writing = false
+	close(b.idle)
+}
+
+func (b *BufferedStream) waitIdleLocked() {
+	for b.writing {
+		idle := b.idle
+		b.mu.Unlock()
+		<-idle
+		b.mu.Lock()
+	}
+}
+
+func (b *BufferedStream) enqueueLocked(p []byte, recovered <-chan struct{}, front bool) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if b.maxBytes > 0 && b.pendingBytes+len(p) > b.maxBytes {
+		return ErrOutageBufferFull
+	}
+	cp := make([]byte, len(p))
+	copy(cp, p)
+	w := bufferedWrite{b: cp, at: time.Now()}
+	if front {
+		b.pending = append([]bufferedWrite{w}, b.pending...)
+	} else {
+		b.pending = append(b.pending, w)
+	}
+	b.pendingBytes += len(cp)
+	if !b.draining {
+		b.draining = true
+		go b.drainAfter(recovered)
+	}
+	return nil
+}
+
+// drainAfter 等待中断结束后按序回放缓存。
+// 回放途中若再次进入中断态，则保留剩余数据并等待下一次恢复。
+func (b *BufferedStream) drainAfter(recovered <-chan struct{}) {
+	for {
+		select {
+		case <-recovered:
+		case <-b.done:
+		case <-b.closeCh:
+		}
+
+		b.mu.Lock()
+		// 直接写入的 Write 可能还没写完（它剩下的部分会放回缓存最前面）。
+		b.waitIdleLocked()
+		if b.closed || isClosed(b.done) {
+			b.draining = false
+			b.mu.Unlock()
+			return
+		}
+		// 中断期间 APP 设置的 write deadline 多半已过期，回放前清除，避免回放立刻失败。
+		_ = b.st.SetWriteDeadline(time.Time{})
+		b.beginWriteLocked()
+		var err error
+		for len(b.pending) > 0 {
+			if active, next := b.gate.state(); active {
+				recovered = next
+				break
+			}
+			w := b.pending[0]
+			if b.maxAge > 0 && time.Since(w.at) > b.maxAge {
+				b.dropped++
+				b.popLocked()
+				continue
+			}
+			// 写入时不持有 mu：中断期间 APP 的 Write 仍可进入缓存（排在队尾）。
+			b.mu.Unlock()
+			var n int
+			n, err = b.st.Write(w.b)
+			b.mu.Lock()
+			if b.closed {
+				// Close 已清空缓存。
+				err = nil
+				break
+			}
+			if err != nil {
+				if active, next := b.gate.state(); active {
+					b.pending[0].b = w.b[n:]
+					b.pendingBytes -= n
+					recovered = next
+					err = nil
+				}
+				break
+			}
+			b.popLocked()
+		}
+		b.endWriteLocked()
+		if err != nil {
+			b.err = err
+			b.pending = nil
+			b.pendingBytes = 0
+			b.draining = false
+			b.mu.Unlock()
+			tracef("buffered stream: replay failed err=%v", err)
+			return
+		}
+		if b.closed || len(b.pending) == 0 {
+			b.draining = false
+			dropped := b.dropped
+			b.mu.Unlock()
+			tracef("buffered stream: replay done dropped=%d", dropped)
+			return
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *BufferedStream) popLocked() {
+	b.pendingBytes -= len(b.pending[0].b)
+	b.pending[0] = bufferedWrite{}
+	b.pending = b.pending[1:]
+}
+
+func (b *BufferedStream) Read(p []byte) (int, error) { return b.st.Read(p) }
+
+// Close 关闭底层 stream 的写方向；尚未回放的缓存会被丢弃。
+func (b *BufferedStream) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.closeCh)
+	}
+	b.pending = nil
+	b.pendingBytes = 0
+	// quic-go 的 stream 不允许 Close 与 Write 并发：等正在进行的写入结束。
+	b.waitIdleLocked()
+	b.mu.Unlock()
+	return b.st.Close()
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *BufferedStream) SetReadDeadline(t time.Time) error  { return b.st.SetReadDeadline(t) }
+func (b *BufferedStream) SetWriteDeadline(t time.Time) error { return b.st.SetWriteDeadline(t) }
+
+// Buffered 返回当前尚未回放的消息数与字节数。
+func (b *BufferedStream) Buffered() (msgs, bytes int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending), b.pendingBytes
+}
+
+// Dropped 返回因超过 maxAge 而在回放前被丢弃的消息数。
+func (b *BufferedStream) Dropped() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
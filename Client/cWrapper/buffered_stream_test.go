@@ -0,0 +1,183 @@
+package wrapper
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// fakeStream 记录写入的字节；hold 非空时，下一次 Write 先通知 entered，再阻塞到 hold 给出结果（写入前 n 字节）。
+type fakeStream struct {
+	quic.Stream
+
+	mu  sync.Mutex
+	out bytes.Buffer
+
+	entered chan struct{}
+	hold    chan fakeWrite
+}
+
+type fakeWrite struct {
+	n   int
+	err error
+}
+
+func (f *fakeStream) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	hold := f.hold
+	f.hold = nil
+	f.mu.Unlock()
+	if hold != nil {
+		close(f.entered)
+		r := <-hold
+		f.mu.Lock()
+		f.out.Write(p[:r.n])
+		f.mu.Unlock()
+		return r.n, r.err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.out.Write(p)
+}
+
+func (f *fakeStream) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.out.String()
+}
+
+func (f *fakeStream) SetWriteDeadline(time.Time) error { return nil }
+func (f *fakeStream) Close() error                     { return nil }
+
+func waitReplayed(t *testing.T, b *BufferedStream) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if msgs, _ := b.Buffered(); msgs == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("buffer not replayed")
+		}
+	}
+}
+
+// 迁移中断期间的写入进入缓存；CutoverToArmedPeer 且新对端回包后按写入顺序回放，
+// 超过字节上限的写入被拒绝，超过驻留时间的消息丢弃并计数。
+func TestBufferedStreamReplaysAfterCutover(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	pc, err := NewSwappableUDPConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, peer, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetProbing(-1, 0)
+
+	const maxAge = 100 * time.Millisecond
+	st := &fakeStream{}
+	gate, done := newOutageGate(), make(chan struct{})
+	defer close(done)
+	b := newBufferedStream(st, gate, done, 10, maxAge)
+
+	if _, err := b.Write([]byte("a")); err != nil || st.String() != "a" {
+		t.Fatalf("direct write: %v %q", err, st.String())
+	}
+
+	// 与控制流收到 migrate 时相同：ArmPeer，进入中断态，等 cutover 且新路径可达后结束。
+	armed := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10}
+	pc.ArmPeer(armed)
+	round := gate.begin()
+	go gate.awaitRecovery(pc, pc.armedCutover(), round, done)
+
+	if _, err := b.Write([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(maxAge + 20*time.Millisecond)
+	for _, p := range []string{"b1", "b2"} {
+		if n, err := b.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatalf("buffered write %q: n=%d err=%v", p, n, err)
+		}
+	}
+	if _, err := b.Write([]byte("full!")); !errors.Is(err, ErrOutageBufferFull) {
+		t.Fatalf("write over the byte limit: %v", err)
+	}
+	if msgs, n := b.Buffered(); msgs != 3 || n != 7 {
+		t.Fatalf("buffered %d msgs / %dB, want 3 / 7", msgs, n)
+	}
+	if st.String() != "a" {
+		t.Fatalf("wrote %q during the outage", st.String())
+	}
+
+	if !pc.CutoverToArmedPeer() {
+		t.Fatal("cutover did not happen")
+	}
+	// cutover 之后、新对端回包之前仍在中断中。
+	time.Sleep(20 * time.Millisecond)
+	if st.String() != "a" {
+		t.Fatalf("replayed %q before the new peer answered", st.String())
+	}
+	if !pc.accept([]byte{0x40, 1, 2, 3}, armed) {
+		t.Fatal("datagram from the new peer dropped")
+	}
+	waitReplayed(t, b)
+
+	if got := st.String(); got != "ab1b2" {
+		t.Fatalf("stream %q, want %q", got, "ab1b2")
+	}
+	if d := b.Dropped(); d != 1 {
+		t.Fatalf("dropped %d, want 1", d)
+	}
+	if _, err := b.Write([]byte("c")); err != nil || st.String() != "ab1b2c" {
+		t.Fatalf("write after recovery: %v %q", err, st.String())
+	}
+}
+
+// 写底层 stream 时不持有锁：中断开始时阻塞中的写入不妨碍其他写入进入缓存，
+// 它没写完的部分回放时排在这些写入之前。
+func TestBufferedStreamWriteBlockedAtOutage(t *testing.T) {
+	hold := make(chan fakeWrite)
+	st := &fakeStream{entered: make(chan struct{}), hold: hold}
+	gate, done := newOutageGate(), make(chan struct{})
+	defer close(done)
+	b := newBufferedStream(st, gate, done, 0, 0)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := b.Write([]byte("first"))
+		first <- err
+	}()
+	<-st.entered
+	gate.begin()
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := b.Write([]byte("second"))
+		second <- err
+	}()
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write blocked behind the in-flight write")
+	}
+	if msgs, _ := b.Buffered(); msgs != 1 {
+		t.Fatalf("buffered %d msgs, want 1", msgs)
+	}
+
+	// 阻塞的写入在中断中失败（例如 deadline 到期），只写出了前 2 个字节。
+	hold <- fakeWrite{n: 2, err: errors.New("deadline exceeded")}
+	if err := <-first; err != nil {
+		t.Fatalf("write failed during outage: %v", err)
+	}
+	gate.end()
+	waitReplayed(t, b)
+	if got := st.String(); got != "firstsecond" {
+		t.Fatalf("stream %q, want %q", got, "firstsecond")
+	}
+}
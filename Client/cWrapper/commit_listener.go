@@ -9,7 +9,10 @@ import (
 	"time"
 )
 
-// commitListener 监听一个“带外(Out-of-band)”的 commit 信号。
+// commitListener 监听一个“带外(Out-of-band)”的 commit 信号（旧方案，默认不启用）。
+//
+// 现在 B 在 rebind 后经 QUIC 控制流发送 in-band commit，client 也会主动探测候选对端（probe.go），
+// 不再需要 Control 与 client 同机；这里仅为调试/对比保留，需显式配置监听地址。
 //
 // 背景：
 // - 我们现在的迁移是两阶段：
@@ -20,14 +23,15 @@ import (
 //
 // 注意：
 // - 该监听器是“可选加速路径”。如果 commit 没收到，APP 仍可按原有策略：在 IO error 时 cutover。
-// - 为简化实现，这里使用本机 UDP（默认 127.0.0.1:7360）。
+// - 为简化实现，这里使用本机 UDP（例如 127.0.0.1:7360），不做认证。
 func commitListener(ctx context.Context, listenAddr string, cutover func() bool) error {
 	addr := strings.TrimSpace(listenAddr)
 	if addr == "" {
 		addr = strings.TrimSpace(os.Getenv("COMMIT_LISTEN_ADDR"))
 	}
 	if addr == "" {
-		addr = "127.0.0.1:7360"
+		// 未显式配置时不启用：commit 已经经控制流 in-band 下发。
+		return nil
 	}
 	pc, err := net.ListenPacket("udp", addr)
 	if err != nil {
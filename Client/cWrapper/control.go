@@ -10,10 +10,12 @@ import (
 type MessageType string
 
 const (
-	TypeHello   MessageType = "hello"
-	TypeMigrate MessageType = "migrate"
-	TypeCommit  MessageType = "commit"
-	TypeAck     MessageType = "ack"
+	TypeHello    MessageType = "hello"
+	TypeMigrate  MessageType = "migrate"
+	TypeCommit   MessageType = "commit"
+	TypeAbort    MessageType = "abort"
+	TypeRetarget MessageType = "retarget"
+	TypeAck      MessageType = "ack"
 )
 
 type Message struct {
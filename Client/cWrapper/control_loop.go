@@ -3,7 +3,6 @@ package wrapper
 import (
 	"fmt"
 	"net"
-	"sync"
 
 	"github.com/quic-go/quic-go"
 )
@@ -11,43 +10,120 @@ import (
 // controlLoop 在专用控制流 stream 上运行。
 //
 // 契约：
-//   - 收到 migrate 消息后：(1) 只关闭一次 migrateSeen；(2) 发送 ACK。
+//   - 收到 migrate 消息后：(1) 按 ID 开始一次迁移（上一次未结束则被取代）；(2) 进入中断窗口（outage）；(3) 发送 ACK。
 //   - 透明模式下，这里不做 target 切换/重连。
 //     我们只“预置”新对端（SwappableUDPConn.ArmPeer），让业务在真正断联时再切换。
+//   - 收到 abort（ID 与进行中的迁移相同，或为空）后：DisarmPeer 放弃该目标
+//     （已 cutover 则切回原对端），中断窗口在原路径可达后结束，然后发送 ACK。
+//   - armed 期间 SwappableUDPConn 自行探测候选对端（probe.go），候选对端回复且旧对端静默即 cutover（相当于 commit）；
+//     之后经新路径收到 in-band commit（restore + rebind 后由 B 发出）时，迁移视为完成。
+//   - 收到 retarget（同样按 ID 匹配）后：RetargetPeer 把进行中的迁移改投新目标，然后发送 ACK。
+//   - 不匹配的 abort/retarget 只 ACK、不改状态：client 不会切到已被放弃的目标。
 //
-// 参数：
+// Session 上的状态：
+//   - migrations：按 ID 记录每次迁移的生命周期（migration.go），A→B→C… 每次都会通知 APP（MigrationBegun）。
 //   - migrateOnce：保证即使多次收到 migrate，也只 close migrateSeen 一次。
-//   - migrateSeen：作为“一次性信号”通知 APP 进入迁移态。
-func (m *Manager) controlLoop(ctrl quic.Stream, pc *SwappableUDPConn, migrateOnce *sync.Once, migrateSeen chan<- struct{}) {
+//   - migrateSeen：作为“一次性信号”通知 APP 第一次进入迁移态（兼容旧 APP）。
+//   - outage：cutover 成功且新对端回包后结束，BufferedStream 据此回放缓存。
+//   - events：各阶段送出类型化事件（MigratePrepared/PeerArmed/CommitReceived/...，见 events.go）。
+func (m *Manager) controlLoop(ctrl quic.Stream, s *Session) {
+	pc := s.pc
 	lr := NewLineReader(ctrl)
 	for {
 		msg, ok, err := lr.Next()
 		if err != nil || !ok {
 			return
 		}
-		if msg.Type != TypeMigrate {
-			continue
-		}
-		newTarget := fmt.Sprintf("%s:%d", msg.NewAddr, msg.NewPort)
-		fmt.Printf("[MIGRATION] migrate: id=%s new=%s\n", msg.ID, newTarget)
-		tracef("migrate received id=%s new=%s", msg.ID, newTarget)
+		switch msg.Type {
+		case TypeMigrate:
+			newTarget := fmt.Sprintf("%s:%d", msg.NewAddr, msg.NewPort)
+			fmt.Printf("[MIGRATION] migrate: id=%s new=%s\n", msg.ID, newTarget)
+			tracef("migrate received id=%s new=%s", msg.ID, newTarget)
 
-		// 核心：不重建 QUIC，而是切换底层 UDP 的真实对端。
-		if pc != nil {
-			if na, rerr := net.ResolveUDPAddr("udp", newTarget); rerr == nil {
-				pc.ArmPeer(na)
-				tracef("udp peer armed to=%s", na.String())
-			} else {
-				tracef("udp peer switch failed target=%s err=%v", newTarget, rerr)
+			// 核心：不重建 QUIC，而是切换底层 UDP 的真实对端。
+			if pc != nil {
+				if na, rerr := net.ResolveUDPAddr("udp", newTarget); rerr == nil {
+					s.emit(EventMigratePrepared, msg.ID, na, "")
+					// 先登记再 ArmPeer：很快到来的探测 cutover 需要记到这次迁移上。
+					if prev, ok := s.migrations.begin(msg.ID, na); ok {
+						tracef("migration superseded id=%s by id=%s", prev.ID, msg.ID)
+						s.emit(EventMigrateAborted, prev.ID, pc.getPeer(), "superseded")
+					}
+					pc.ArmPeer(na)
+					s.emit(EventPeerArmed, msg.ID, na, "")
+					tracef("udp peer armed to=%s", na.String())
+					round := s.outage.begin()
+					cutover, done := pc.armedCutover(), s.Conn.Context().Done()
+					go s.outage.awaitRecovery(pc, cutover, round, done)
+				} else {
+					tracef("udp peer switch failed target=%s err=%v", newTarget, rerr)
+				}
 			}
-		}
-		if migrateOnce != nil {
-			migrateOnce.Do(func() {
-				close(migrateSeen)
+			s.migrateOnce.Do(func() {
+				close(s.migrateSeen)
 			})
+			// 立即发送 ACK，便于 server/control 继续推进 CRIU dump/restore。
+			// 注意：ACK 不代表“客户端业务已恢复”，只代表客户端在控制流上观测到了 migrate 事件。
+			_ = WriteLine(ctrl, Message{Type: TypeAck, AckID: msg.ID})
+
+		case TypeCommit:
+			// commit 由完成 restore + rebind 的实例经控制流发出，只有 cutover 之后才可能经新路径送达。
+			// 双路径窗口内 B 的 commit 常先于探测 cutover 送达：
+			//   - 候选对端已回复探测：据此立即 cutover；
+			//   - 否则不能据此切换（发出者也可能是当前对端，例如回滚时从 dump 恢复的 A），
+			//     只记下来，等探测 cutover 后再视为完成；回滚时 abort 会清掉它。
+			if !s.migrations.matches(msg.ID) {
+				continue
+			}
+			if pc != nil && pc.ArmedPeer() != nil {
+				if !pc.armedAnswered() {
+					s.migrations.markEarlyCommit()
+					tracef("commit id=%s arrived before cutover; deferred", msg.ID)
+					// 与探测 cutover 并发：cutover 已在 mark 之前发生时由这里完成。
+					if pc.ArmedPeer() == nil {
+						s.completeEarlyCommit()
+					}
+					continue
+				}
+				pc.cutover(CutoverByCommit)
+			}
+			// 迁移完成：之后的 abort/retarget 不再作用于它。
+			s.migrations.finish(msg.ID, MigrationCommitted, "")
+			s.emit(EventCommitReceived, msg.ID, pc.getPeer(), "")
+			tracef("commit received id=%s; migration complete", msg.ID)
+
+		case TypeAbort:
+			if !s.migrations.matches(msg.ID) {
+				tracef("abort ignored id=%s (not pending)", msg.ID)
+				_ = WriteLine(ctrl, Message{Type: TypeAck, AckID: msg.ID})
+				continue
+			}
+			fmt.Printf("[MIGRATION] abort: id=%s\n", msg.ID)
+			if s.disarm("abort") {
+				tracef("udp peer disarmed; peer=%s", pc.getPeer())
+			}
+			_ = WriteLine(ctrl, Message{Type: TypeAck, AckID: msg.ID})
+
+		case TypeRetarget:
+			newTarget := fmt.Sprintf("%s:%d", msg.NewAddr, msg.NewPort)
+			if !s.migrations.matches(msg.ID) {
+				tracef("retarget ignored id=%s new=%s (not pending)", msg.ID, newTarget)
+				_ = WriteLine(ctrl, Message{Type: TypeAck, AckID: msg.ID})
+				continue
+			}
+			fmt.Printf("[MIGRATION] retarget: id=%s new=%s\n", msg.ID, newTarget)
+			if pc != nil {
+				if na, rerr := net.ResolveUDPAddr("udp", newTarget); rerr == nil {
+					if pc.RetargetPeer(na) {
+						s.migrations.retarget(msg.ID, na)
+						s.emit(EventMigrateRetargeted, msg.ID, na, "")
+						tracef("udp peer retargeted to=%s", na.String())
+					}
+				} else {
+					tracef("udp peer retarget failed target=%s err=%v", newTarget, rerr)
+				}
+			}
+			_ = WriteLine(ctrl, Message{Type: TypeAck, AckID: msg.ID})
 		}
-		// 立即发送 ACK，便于 server/control 继续推进 CRIU dump/restore。
-		// 注意：ACK 不代表“客户端业务已恢复”，只代表客户端在控制流上观测到了 migrate 事件。
-		_ = WriteLine(ctrl, Message{Type: TypeAck, AckID: msg.ID})
 	}
 }
@@ -16,8 +16,21 @@
 //   - dial 初始 Target 并建立 QUIC 连接。
 //   - 打开第一条双向 stream 作为控制流（newline JSON）。
 //   - 监听 "migrate" 消息：
-//       - 触发 MigrateSeen（供 APP 收紧 IO deadline/统计 downtime）
+//       - 触发 MigrateSeen（仅首次）与 MigrationBegun（每次；供 APP 收紧 IO deadline/统计 downtime）
 //       - 切换底层 UDP 真实对端（SwappableUDPConn.SetPeer）
+//   - armed 期间在 QUIC 之外探测候选对端（probe.go）：候选对端回复且旧对端静默后自动 cutover，
+//     不必等业务层 IO 超时（Manager.ProbeInterval/ProbeQuietAfter 可调）。
+//   - cutover 前后的双路径接收窗口（Manager.DualPathWindow）：新旧对端的包都交给 quic-go，
+//     使 cutover 不丢包；Session.PathStats 给出按来源的计数。
+//   - 可选的本地地址迁移（Manager.FollowLocalAddr，local_addr.go）：接口/地址变化时按策略 RebindLocal，
+//     fakePeer 与 QUIC session 保持不变；结果经 Session.LocalRebind 通知 APP。
+//   - 监听 "abort"/"retarget"：放弃或改投进行中的迁移（Session.ArmedPeer/Disarm 为对应的本地 API）。
+//   - 同一 session 内的连续迁移（migration.go）：按迁移 ID 记录 armed/cutover/committed/aborted/superseded，
+//     每次迁移都通知 APP（Session.MigrationBegun/CurrentMigration，Migration.Done）。
+//   - 类型化事件流（Session.Events，events.go）：migrate/armed/probe/cutover/commit/abort 等节点带迁移 ID 与时间，
+//     APP 或车队 agent 无需解析 trace 日志即可测量、响应。
+//   - 可选的中断缓存（Session.OpenBufferedStream）：迁移中断窗口内的业务写入先缓存，
+//     cutover 成功且新对端回包后按序回放。
 //   - 保持 API 极简：业务 stream 与 IO 由 APP 自己掌控。
 //
 // quic-go API 使用说明（本项目只解释“我们怎么用”，不依赖库内部实现细节）：
@@ -0,0 +1,146 @@
+package wrapper
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Session 事件流：把迁移过程中的关键节点以类型化事件交给 APP / 车队 agent，
+// 不必再解析 tracef 日志来测量或做出反应。
+//
+// 用法：
+//
+//	for ev := range s.Events() { ... }
+//
+// channel 带缓冲；APP 不读或读得慢时新事件被丢弃（Dropped 计数），不会阻塞控制流与 UDP 收发。
+// session 结束时先送出 SessionClosed，然后关闭 channel。
+
+type EventType string
+
+const (
+	// EventMigratePrepared：控制流收到 migrate（Peer 为新目标）。
+	EventMigratePrepared EventType = "migrate_prepared"
+	// EventPeerArmed：候选对端已设置（Peer），之后开始探测。
+	EventPeerArmed EventType = "peer_armed"
+	// EventPathProbeOK：候选对端回复了探测包（Peer）。
+	EventPathProbeOK EventType = "path_probe_ok"
+	// EventCutoverDone：真实对端已切到候选对端（Peer），Reason 见 CutoverBy*。
+	EventCutoverDone EventType = "cutover_done"
+	// EventCommitReceived：经新路径收到 in-band commit，迁移完成。
+	EventCommitReceived EventType = "commit_received"
+	// EventMigrateAborted：迁移被 abort 或本地 Disarm 放弃。
+	EventMigrateAborted EventType = "migrate_aborted"
+	// EventMigrateRetargeted：进行中的迁移改投新目标（Peer）。
+	EventMigrateRetargeted EventType = "migrate_retargeted"
+	// EventLocalRebind：本地地址迁移完成或失败（Peer 为新的本地地址，Err 非空表示失败）。
+	EventLocalRebind EventType = "local_rebind"
+	// EventSessionClosed：session 结束（Reason 为原因），之后 channel 关闭。
+	EventSessionClosed EventType = "session_closed"
+)
+
+// cutover 的触发原因（Event.Reason）。
+const (
+	CutoverByProbe          = "probe"
+	CutoverByApp            = "app"
+	CutoverByCommitListener = "commit-listener"
+	CutoverByCommit         = "commit"
+)
+
+// Event 是 Session 上的一个类型化事件。
+type Event struct {
+	Type EventType
+	Time time.Time
+	// MigrationID 是事件所属迁移的 ID（与 migrate/commit/abort 消息里的 id 相同）；与迁移无关时为空。
+	MigrationID string
+	Peer        net.Addr
+	Reason      string
+	Err         error
+}
+
+// eventBufferSize 是 Events() channel 的缓冲大小。
+const eventBufferSize = 64
+
+type eventStream struct {
+	mu      sync.Mutex
+	ch      chan Event
+	closed  bool
+	dropped uint64
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{ch: make(chan Event, eventBufferSize)}
+}
+
+func (e *eventStream) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.ch <- ev:
+	default:
+		e.dropped++
+	}
+}
+
+func (e *eventStream) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+}
+
+// Events 返回本 session 的事件流（见 events.go）。
+func (s *Session) Events() <-chan Event {
+	return s.events.ch
+}
+
+// DroppedEvents 返回因 APP 未及时读取而丢弃的事件数。
+func (s *Session) DroppedEvents() uint64 {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	return s.events.dropped
+}
+
+func (s *Session) emit(t EventType, id string, peer net.Addr, reason string) {
+	s.events.emit(Event{Type: t, MigrationID: id, Peer: peer, Reason: reason})
+}
+
+// closeEvents 在 session 结束时调用：仍未完成的迁移记为 aborted，送出 SessionClosed 后关闭事件流。
+func (s *Session) closeEvents(reason string) {
+	if mig, ok := s.migrations.finish("", MigrationAborted, "session closed"); ok {
+		s.emit(EventMigrateAborted, mig.ID, s.pc.getPeer(), "session closed")
+	}
+	s.emit(EventSessionClosed, "", nil, reason)
+	s.events.close()
+}
+
+// completeEarlyCommit 在 cutover 之后完成先到的 commit（见 migrationTable.takeEarlyCommit）并送出 CommitReceived。
+func (s *Session) completeEarlyCommit() {
+	if mig, ok := s.migrations.takeEarlyCommit(); ok {
+		s.emit(EventCommitReceived, mig.ID, s.pc.getPeer(), "")
+		tracef("commit received id=%s; migration complete", mig.ID)
+	}
+}
+
+// observePeer 把 SwappableUDPConn 内部的对端事件转成 Session 事件。
+func (s *Session) observePeer(ev peerEvent, peer *net.UDPAddr, reason string) {
+	var addr net.Addr
+	if peer != nil {
+		addr = peer
+	}
+	switch ev {
+	case peerProbeOK:
+		s.emit(EventPathProbeOK, s.migrations.currentID(), addr, "")
+	case peerCutover:
+		s.emit(EventCutoverDone, s.migrations.cutover(reason), addr, reason)
+		s.completeEarlyCommit()
+	}
+}
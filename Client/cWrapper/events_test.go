@@ -0,0 +1,74 @@
+package wrapper
+
+import (
+	"net"
+	"testing"
+)
+
+// 一个 session 内两次迁移（第二次改投后被第三次取代）与 session 结束：
+// 事件按发生顺序送出，MigrationID/Peer/Reason 与对应的迁移一致，SessionClosed 之后 channel 关闭。
+func TestSessionEventOrder(t *testing.T) {
+	s := newTestSession(t)
+	b, c, d := loopback(10), loopback(11), loopback(12)
+
+	s.send(migrateMsg("m1", b.Port))
+	s.pc.peerMu.RLock()
+	nonce := s.pc.probeNonce
+	s.pc.peerMu.RUnlock()
+	reply := newProbe(nonce)
+	copy(reply, probeReplyMagic)
+	s.pc.accept(reply, b)
+	s.CutoverToArmedPeer()
+	s.send(Message{Type: TypeCommit, ID: "m1"})
+
+	s.send(migrateMsg("m2", c.Port))
+	s.send(Message{Type: TypeRetarget, ID: "m2", NewAddr: "127.0.0.1", NewPort: d.Port})
+	s.send(migrateMsg("m3", c.Port))
+	s.closeEvents("test done")
+
+	want := []struct {
+		typ    EventType
+		id     string
+		peer   *net.UDPAddr
+		reason string
+	}{
+		{EventMigratePrepared, "m1", b, ""},
+		{EventPeerArmed, "m1", b, ""},
+		{EventPathProbeOK, "m1", b, ""},
+		{EventCutoverDone, "m1", b, CutoverByApp},
+		{EventCommitReceived, "m1", b, ""},
+		{EventMigratePrepared, "m2", c, ""},
+		{EventPeerArmed, "m2", c, ""},
+		{EventMigrateRetargeted, "m2", d, ""},
+		{EventMigratePrepared, "m3", c, ""},
+		{EventMigrateAborted, "m2", b, "superseded"},
+		{EventPeerArmed, "m3", c, ""},
+		{EventMigrateAborted, "m3", b, "session closed"},
+		{EventSessionClosed, "", nil, "test done"},
+	}
+	var got []Event
+	for ev := range s.Events() {
+		got = append(got, ev)
+	}
+	if len(got) != len(want) {
+		for _, ev := range got {
+			t.Logf("%s %s %v %q", ev.Type, ev.MigrationID, ev.Peer, ev.Reason)
+		}
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		ev := got[i]
+		peer, _ := ev.Peer.(*net.UDPAddr)
+		if ev.Type != w.typ || ev.MigrationID != w.id || ev.Reason != w.reason ||
+			(w.peer == nil) != (ev.Peer == nil) || (w.peer != nil && !udpAddrEqual(peer, w.peer)) {
+			t.Errorf("event %d = %s %s %v %q, want %s %s %v %q",
+				i, ev.Type, ev.MigrationID, ev.Peer, ev.Reason, w.typ, w.id, w.peer, w.reason)
+		}
+		if ev.Time.IsZero() || (i > 0 && ev.Time.Before(got[i-1].Time)) {
+			t.Errorf("event %d (%s) time %v out of order", i, ev.Type, ev.Time)
+		}
+	}
+	if n := s.DroppedEvents(); n != 0 {
+		t.Fatalf("dropped %d events", n)
+	}
+}
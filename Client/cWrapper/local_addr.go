@@ -0,0 +1,204 @@
+package wrapper
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// 客户端本地地址迁移（车辆在蜂窝网络与路侧 Wi-Fi 之间切换）。
+//
+// 流程：
+//   - AddrSource 产生本地接口/地址变化通知（Linux 默认用 netlink，见 addrwatch_linux.go；测试可注入 ChanAddrSource）。
+//   - 每次变化后枚举本机可用地址（加上通知里新增的地址、去掉被删除的地址），交给 LocalAddrPolicy 选出新的本地地址。
+//   - 与当前绑定地址不同则调用 SwappableUDPConn.RebindLocal：quic-go 看到的 PacketConn 与 fakePeer 都不变，
+//     只是底层 socket 换成绑定在新地址上的那个。
+//   - 结果经 Session.LocalRebind 通知 APP。
+
+// AddrChange 描述一次本地网络变化。
+type AddrChange struct {
+	Time  time.Time
+	Iface string
+	// Addr 为空表示接口级变化（up/down），只触发重新评估。
+	Addr net.IP
+	// Added：地址新增为 true，删除为 false。
+	Added bool
+}
+
+// AddrSource 产生本地地址变化通知；返回的 channel 在 ctx 结束时关闭。
+type AddrSource interface {
+	Changes(ctx context.Context) (<-chan AddrChange, error)
+}
+
+// ChanAddrSource 把一个 channel 当作地址变化来源，用于测试或由 APP 自行探测网络时注入。
+type ChanAddrSource chan AddrChange
+
+func (c ChanAddrSource) Changes(ctx context.Context) (<-chan AddrChange, error) {
+	out := make(chan AddrChange)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ch, ok := <-c:
+				if !ok {
+					return
+				}
+				select {
+				case out <- ch:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// LocalCandidate 是一个可供绑定的本地地址。
+type LocalCandidate struct {
+	Iface string
+	IP    net.IP
+}
+
+// LocalAddrPolicy 根据当前绑定地址（nil 表示通配）、对端与候选地址选出新的本地地址。
+// 返回 nil 表示保持不变。
+type LocalAddrPolicy func(current, peer *net.UDPAddr, candidates []LocalCandidate) *net.UDPAddr
+
+// PreferInterfaces 返回按接口名前缀排序偏好的策略（越靠前越优先，不匹配任何前缀的排在最后），
+// 例如 PreferInterfaces("wlan", "wwan") 表示有路侧 Wi-Fi 时优先用 Wi-Fi，否则用蜂窝。
+//
+// 只考虑与对端同地址族、且回环属性相同的地址；当前地址仍可用且不比最优候选差时保持不变。
+// 通配绑定（current 为 nil）只在出现偏好接口的地址时才固定到该地址。
+func PreferInterfaces(prefixes ...string) LocalAddrPolicy {
+	rank := func(iface string) int {
+		for i, p := range prefixes {
+			if strings.HasPrefix(iface, p) {
+				return i
+			}
+		}
+		return len(prefixes)
+	}
+	return func(current, peer *net.UDPAddr, candidates []LocalCandidate) *net.UDPAddr {
+		best, bestRank, curRank := -1, 0, -1
+		for i, c := range candidates {
+			if !sameScope(c.IP, peer) {
+				continue
+			}
+			r := rank(c.Iface)
+			if current != nil && c.IP.Equal(current.IP) {
+				curRank = r
+			}
+			if best < 0 || r < bestRank {
+				best, bestRank = i, r
+			}
+		}
+		switch {
+		case best < 0:
+			// 没有可用地址：当前地址已消失时退回通配绑定，由内核按路由选源地址。
+			if current != nil && curRank < 0 {
+				return &net.UDPAddr{}
+			}
+			return nil
+		case current == nil && bestRank == len(prefixes):
+			// 通配绑定且没有偏好的接口：保持由内核选路。
+			return nil
+		case curRank >= 0 && curRank <= bestRank:
+			return nil
+		}
+		return &net.UDPAddr{IP: candidates[best].IP}
+	}
+}
+
+// DefaultLocalAddrPolicy 不偏好任何接口：通配绑定保持不变；固定绑定的地址消失时换到第一个可用地址。
+var DefaultLocalAddrPolicy = PreferInterfaces()
+
+func sameScope(ip net.IP, peer *net.UDPAddr) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	if peer == nil {
+		return !ip.IsLoopback()
+	}
+	return (ip.To4() != nil) == (peer.IP.To4() != nil) && ip.IsLoopback() == peer.IP.IsLoopback()
+}
+
+// localCandidates 枚举本机处于 up 状态的接口地址，并按 change 增删。
+func localCandidates(change AddrChange) []LocalCandidate {
+	var out []LocalCandidate
+	ifs, _ := net.Interfaces()
+	for _, ifc := range ifs {
+		if ifc.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if !change.Added && change.Addr != nil && ipn.IP.Equal(change.Addr) {
+				continue
+			}
+			out = append(out, LocalCandidate{Iface: ifc.Name, IP: ipn.IP})
+		}
+	}
+	if change.Added && change.Addr != nil {
+		found := false
+		for _, c := range out {
+			if c.IP.Equal(change.Addr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, LocalCandidate{Iface: change.Iface, IP: change.Addr})
+		}
+	}
+	return out
+}
+
+// LocalRebindEvent 是一次本地地址迁移的结果。
+type LocalRebindEvent struct {
+	Time  time.Time
+	Iface string
+	From  net.Addr
+	To    net.Addr
+	// Err 非空表示 RebindLocal 失败，底层仍是 From。
+	Err error
+}
+
+// watchLocalAddr 跟随本地地址变化执行 RebindLocal，直到 ctx 结束。
+func watchLocalAddr(ctx context.Context, src AddrSource, policy LocalAddrPolicy, s *Session) error {
+	if policy == nil {
+		policy = DefaultLocalAddrPolicy
+	}
+	changes, err := src.Changes(ctx)
+	if err != nil {
+		return err
+	}
+	pc := s.pc
+	for ch := range changes {
+		cur := pc.boundAddr()
+		next := policy(cur, pc.getPeer(), localCandidates(ch))
+		if next == nil || (cur == nil && next.IP == nil) || (cur != nil && next.IP.Equal(cur.IP)) {
+			continue
+		}
+		from := pc.LocalAddr()
+		ev := LocalRebindEvent{Time: time.Now(), Iface: ch.Iface, From: from}
+		if err := pc.RebindLocal(next); err != nil {
+			ev.Err = err
+			tracef("local rebind to=%s failed err=%v", next, err)
+		} else {
+			ev.To = pc.LocalAddr()
+			tracef("local rebind iface=%s from=%s to=%s", ch.Iface, from, ev.To)
+		}
+		s.emitLocalRebind(ev)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,128 @@
+package wrapper
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 本地地址迁移：用 ChanAddrSource 注入地址变化，不依赖 netlink 或真实网卡变化。
+
+func newTestLocalSession(t *testing.T) (*Session, *net.UDPConn) {
+	t.Helper()
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	pc, err := NewSwappableUDPConn("udp4", &net.UDPAddr{}, peerAddr, peerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	localRebind := make(chan LocalRebindEvent, 8)
+	return &Session{pc: pc, LocalRebind: localRebind, localRebind: localRebind, events: newEventStream()}, peer
+}
+
+func waitLocalRebind(t *testing.T, s *Session) LocalRebindEvent {
+	t.Helper()
+	select {
+	case ev := <-s.LocalRebind:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no local rebind")
+		return LocalRebindEvent{}
+	}
+}
+
+// roundTrip 经 pc 发一个数据报到 peer，返回 peer 看到的来源地址。
+func roundTrip(t *testing.T, pc *SwappableUDPConn, peer *net.UDPConn) *net.UDPAddr {
+	t.Helper()
+	if _, err := pc.WriteTo([]byte("ping"), nil); err != nil {
+		t.Fatal(err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	_, from, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return from
+}
+
+func TestWatchLocalAddrRebinds(t *testing.T) {
+	s, peer := newTestLocalSession(t)
+	src := make(ChanAddrSource)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchLocalAddr(ctx, src, PreferInterfaces("lo"), s) }()
+
+	// 通配绑定 + 出现偏好接口（lo）上的地址：固定到该地址。
+	lo := net.IPv4(127, 0, 0, 1)
+	src <- AddrChange{Time: time.Now(), Iface: "lo", Addr: lo, Added: true}
+	ev := waitLocalRebind(t, s)
+	if ev.Err != nil {
+		t.Fatalf("rebind: %v", ev.Err)
+	}
+	to, ok := ev.To.(*net.UDPAddr)
+	if !ok || !to.IP.Equal(lo) {
+		t.Fatalf("rebind to %v, want %v", ev.To, lo)
+	}
+	if got := s.pc.boundAddr(); got == nil || !got.IP.Equal(lo) {
+		t.Fatalf("bound %v, want %v", got, lo)
+	}
+	if from := roundTrip(t, s.pc, peer); from.Port != to.Port {
+		t.Fatalf("peer saw %v, want port %d", from, to.Port)
+	}
+	select {
+	case e := <-s.Events():
+		if e.Type != EventLocalRebind || e.Peer != ev.To {
+			t.Fatalf("event %+v", e)
+		}
+	default:
+		t.Fatal("no local_rebind event")
+	}
+
+	// 当前地址被删除且没有其他同范围的地址：退回通配绑定。
+	src <- AddrChange{Time: time.Now(), Iface: "lo", Addr: lo}
+	if ev := waitLocalRebind(t, s); ev.Err != nil || s.pc.boundAddr() != nil {
+		t.Fatalf("rebind back to wildcard: err=%v bound=%v", ev.Err, s.pc.boundAddr())
+	}
+	roundTrip(t, s.pc, peer)
+
+	cancel()
+	close(src)
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("watcher: %v", err)
+	}
+}
+
+// TestRebindLocalConcurrent 与 -race 一起检查 RebindLocal/Close 并发时对 laddr 与 socket 的访问。
+func TestRebindLocalConcurrent(t *testing.T) {
+	s, _ := newTestLocalSession(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var laddr *net.UDPAddr
+				if (i+j)%2 == 0 {
+					laddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+				}
+				_ = s.pc.RebindLocal(laddr)
+				_ = s.pc.boundAddr()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := s.pc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pc.RebindLocal(nil); err == nil {
+		t.Fatal("rebind after close succeeded")
+	}
+}
@@ -3,6 +3,7 @@ package wrapper
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"sync"
 	"time"
@@ -29,12 +30,36 @@ type Manager struct {
 	// DialTimeout 限制一次 dial 尝试的最长时间（包含握手）。
 	DialTimeout time.Duration
 
-	// CommitListenAddr 是方案2(prepare/commit)的可选带外 commit 通道监听地址。
-	// - Control 会在 B restore + rebind 后向该地址发送 "commit"。
-	// - 为空时会读取环境变量 COMMIT_LISTEN_ADDR；仍为空则默认 127.0.0.1:7360。
+	// CommitListenAddr 是旧版带外 commit 通道（本机 UDP）的监听地址，仅用于调试/对比。
+	// - commit 现在经控制流 in-band 下发，并由探测回复触发 cutover，不需要该通道。
+	// - 该通道不做认证，且要求 Control 与 client 同机；为空时读取环境变量 COMMIT_LISTEN_ADDR，仍为空则不启用。
 	//
-	// 注意：即使不启用 commit 通道，仍保留原有策略：业务 IO error 时由 APP 触发 CutoverToArmedPeer()。
+	// 注意：探测关闭（ProbeInterval<0）且未启用该通道时仍保留原有策略：业务 IO error 时由 APP 触发 CutoverToArmedPeer()。
 	CommitListenAddr string
+
+	// ProbeInterval 是收到 migrate 后向候选对端发送探测包的间隔；0 表示默认 20ms，<0 表示不探测。
+	ProbeInterval time.Duration
+	// ProbeQuietAfter：候选对端回复探测后，旧对端需静默多久才自动 cutover；0 表示默认 50ms，<0 表示不等待。
+	ProbeQuietAfter time.Duration
+
+	// DualPathWindow 是 cutover 前后同时接收新旧对端数据报的窗口（见 SwappableUDPConn.SetDualPathWindow），
+	// 使在途包不被丢弃、QUIC 无需重传；0 表示默认 200ms，<0 表示关闭。
+	DualPathWindow time.Duration
+
+	// FollowLocalAddr 开启客户端本地地址迁移：本机接口/地址变化时按 LocalAddrPolicy 选出新的本地地址并 RebindLocal，
+	// QUIC session 不重建（见 local_addr.go）。
+	FollowLocalAddr bool
+	// LocalAddrSource 是地址变化来源；nil 时用 netlink（仅 Linux）。测试可注入 ChanAddrSource。
+	LocalAddrSource AddrSource
+	// LocalAddrPolicy 选择新的本地地址；nil 时用 DefaultLocalAddrPolicy。
+	LocalAddrPolicy LocalAddrPolicy
+
+	// OutageBufferBytes 限制 BufferedStream 在迁移中断期间最多缓存的字节数。
+	// <=0 时默认 1MiB。
+	OutageBufferBytes int
+	// OutageBufferAge 限制缓存消息的最长驻留时间；超过的消息在回放前被丢弃。
+	// <=0 时默认 10s。
+	OutageBufferAge time.Duration
 }
 
 type Session struct {
@@ -45,9 +70,30 @@ type Session struct {
 
 	pc *SwappableUDPConn
 
-	// MigrateSeen：当控制流观测到 migrate 消息后会 close 一次。
+	// MigrateSeen：当控制流观测到 migrate 消息后会 close 一次（只对应 session 内的第一次迁移）。
 	// APP 可以用它在迁移期收紧 IO deadline，从而更快进入“故障判定/恢复”逻辑。
+	// 同一 session 内的后续迁移请用 MigrationBegun/CurrentMigration（migration.go）；
+	// 需要完整的迁移过程（armed/cutover/commit/abort 等）时请用 Events()。
 	MigrateSeen <-chan struct{}
+
+	migrateOnce sync.Once
+	migrateSeen chan struct{}
+
+	// LocalRebind：每次本地地址迁移（Manager.FollowLocalAddr）完成或失败后送出一个事件。
+	// 缓冲有限，APP 不读时新事件被丢弃，不会阻塞迁移。
+	LocalRebind <-chan LocalRebindEvent
+	localRebind chan LocalRebindEvent
+
+	// events 是 Events() 背后的类型化事件流（见 events.go）。
+	events *eventStream
+
+	// migrations 按 ID 记录本 session 内的迁移；abort/retarget/commit 只对进行中的那一次生效。
+	migrations *migrationTable
+
+	// outage 跟踪迁移中断窗口，供 BufferedStream 判断“缓存还是直写”。
+	outage   *outageGate
+	bufBytes int
+	bufAge   time.Duration
 }
 
 // CutoverToArmedPeer 尝试把底层 UDP 的真实对端切换到“候选对端”（如果存在）。
@@ -62,6 +108,49 @@ func (s *Session) CutoverToArmedPeer() bool {
 	return s.pc.CutoverToArmedPeer()
 }
 
+// PathStats 返回底层 UDP 按来源（当前/候选/旧对端/丢弃）统计的接收计数。
+func (s *Session) PathStats() PathStats {
+	if s == nil || s.pc == nil {
+		return PathStats{}
+	}
+	return s.pc.PathStats()
+}
+
+// ArmedPeer 返回 migrate 预置、尚未 cutover 的候选对端；没有时返回 nil。
+func (s *Session) ArmedPeer() *net.UDPAddr {
+	if s == nil || s.pc == nil {
+		return nil
+	}
+	return s.pc.ArmedPeer()
+}
+
+// Disarm 在本地放弃进行中的迁移（效果同收到 abort）：清除候选对端，
+// 若已 cutover 则切回原对端；中断窗口在当前路径回包后结束。返回是否撤销了任何状态。
+func (s *Session) Disarm() bool {
+	return s.disarm("local")
+}
+
+// disarm 放弃进行中的迁移并送出 MigrateAborted 事件（reason：local 为 APP 调用，abort 为控制流消息）。
+func (s *Session) disarm(reason string) bool {
+	if s == nil || s.pc == nil {
+		return false
+	}
+	mig, ok := s.migrations.finish("", MigrationAborted, reason)
+	changed := s.pc.DisarmPeer()
+	if changed || ok {
+		s.emit(EventMigrateAborted, mig.ID, s.pc.getPeer(), reason)
+	}
+	return changed
+}
+
+func (s *Session) emitLocalRebind(ev LocalRebindEvent) {
+	select {
+	case s.localRebind <- ev:
+	default:
+	}
+	s.events.emit(Event{Type: EventLocalRebind, Time: ev.Time, Peer: ev.To, Reason: ev.Iface, Err: ev.Err})
+}
+
 // Run 是客户端 wrapper 的主循环。
 //
 // 结构：
@@ -87,6 +176,21 @@ func (m *Manager) Run(ctx context.Context, run func(ctx context.Context, s *Sess
 	if m.DialTimeout <= 0 {
 		m.DialTimeout = 900 * time.Millisecond
 	}
+	if m.ProbeInterval == 0 {
+		m.ProbeInterval = defaultProbeInterval
+	}
+	if m.ProbeQuietAfter == 0 {
+		m.ProbeQuietAfter = defaultProbeQuietAfter
+	}
+	if m.DualPathWindow == 0 {
+		m.DualPathWindow = defaultDualPathWindow
+	}
+	if m.OutageBufferBytes <= 0 {
+		m.OutageBufferBytes = 1024 * 1024
+	}
+	if m.OutageBufferAge <= 0 {
+		m.OutageBufferAge = 10 * time.Second
+	}
 
 	for {
 		if ctx.Err() != nil {
@@ -102,23 +206,41 @@ func (m *Manager) Run(ctx context.Context, run func(ctx context.Context, s *Sess
 			continue
 		}
 
+		pc.SetProbing(m.ProbeInterval, m.ProbeQuietAfter)
+		pc.SetDualPathWindow(m.DualPathWindow)
+
 		fmt.Printf("✅ [Client] Connected %s\n", m.Target)
 		tracef("session connected target=%s", m.Target)
 
 		migrateSeen := make(chan struct{})
-		var migrateOnce sync.Once
+		localRebind := make(chan LocalRebindEvent, 8)
+		s := &Session{
+			Conn:        sess,
+			Target:      m.Target,
+			pc:          pc,
+			MigrateSeen: migrateSeen,
+			migrateSeen: migrateSeen,
+			LocalRebind: localRebind,
+			localRebind: localRebind,
+			events:      newEventStream(),
+			migrations:  newMigrationTable(),
+			outage:      newOutageGate(),
+			bufBytes:    m.OutageBufferBytes,
+			bufAge:      m.OutageBufferAge,
+		}
+		pc.setObserver(s.observePeer)
 		ctrlDone := make(chan struct{})
 		go func() {
 			defer close(ctrlDone)
-			m.controlLoop(ctrl, pc, &migrateOnce, migrateSeen)
+			m.controlLoop(ctrl, s)
 		}()
 
-		// 方案2：带外 commit 信号（可选）。
+		// 方案2：带外 commit 信号（可选，默认不启用）。
 		commitCtx, commitCancel := context.WithCancel(ctx)
 		commitDone := make(chan struct{})
 		go func() {
 			defer close(commitDone)
-			if err := commitListener(commitCtx, m.CommitListenAddr, pc.CutoverToArmedPeer); err != nil {
+			if err := commitListener(commitCtx, m.CommitListenAddr, func() bool { return pc.cutover(CutoverByCommitListener) }); err != nil {
 				// 正常退出：ctx cancel。
 				if commitCtx.Err() == nil {
 					tracef("commit listener stopped err=%v", err)
@@ -126,14 +248,41 @@ func (m *Manager) Run(ctx context.Context, run func(ctx context.Context, s *Sess
 			}
 		}()
 
-		_ = run(ctx, &Session{Conn: sess, Target: m.Target, pc: pc, MigrateSeen: migrateSeen})
+		// 本地地址迁移（可选，默认不启用）。
+		watchCtx, watchCancel := context.WithCancel(ctx)
+		watchDone := make(chan struct{})
+		go func() {
+			defer close(watchDone)
+			if !m.FollowLocalAddr {
+				return
+			}
+			src := m.LocalAddrSource
+			if src == nil {
+				src = defaultAddrSource()
+			}
+			if err := watchLocalAddr(watchCtx, src, m.LocalAddrPolicy, s); err != nil && watchCtx.Err() == nil {
+				tracef("local addr watcher stopped err=%v", err)
+			}
+		}()
+
+		runErr := run(ctx, s)
 		tracef("session run ended target=%s", m.Target)
-		tracef("session closing target=%s", m.Target)
+		tracef("session closing target=%s paths=%+v", m.Target, pc.PathStats())
 		commitCancel()
 		<-commitDone
+		watchCancel()
+		<-watchDone
 		_ = sess.CloseWithError(0, "session end")
 		<-ctrlDone
 		tracef("session ctrl loop done target=%s", m.Target)
+		reason := "run returned"
+		switch {
+		case ctx.Err() != nil:
+			reason = ctx.Err().Error()
+		case runErr != nil:
+			reason = runErr.Error()
+		}
+		s.closeEvents(reason)
 
 		// 透明迁移模式下：这里不会切 target，也不会重建 QUIC。
 		// 若连接最终结束，则从初始 Target 重新 dial。
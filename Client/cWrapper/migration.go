@@ -0,0 +1,220 @@
+package wrapper
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// 同一 QUIC session 内的多次迁移（A→B→C→…）。
+//
+// 车辆沿路行驶时会在一个 session 内连续迁移多次。每个 migrate 按 ID 建一条记录，
+// 生命周期为 armed → cutover → committed，或中途 aborted / superseded：
+//   - 新的 migrate 到达时上一次尚未结束，上一次记为 superseded，之后它的 abort/retarget/commit 都不再生效；
+//   - 同一 ID 的 migrate 重发只更新目标，不算新迁移。
+//
+// APP 用 MigrationBegun() 得知新迁移开始、CurrentMigration() 取得它，再用 Migration.Done 等待其结束；
+// MigrateSeen 只对应 session 内的第一次迁移。
+
+type MigrationState string
+
+const (
+	// MigrationArmed：已收到 migrate，候选对端已设置，尚未 cutover。
+	MigrationArmed MigrationState = "armed"
+	// MigrationCutover：真实对端已切到新目标，等待 commit。
+	MigrationCutover MigrationState = "cutover"
+	// MigrationCommitted：收到新实例的 commit，迁移完成。
+	MigrationCommitted MigrationState = "committed"
+	// MigrationAborted：被 abort、本地 Disarm 放弃，或 session 结束时仍未完成。
+	MigrationAborted MigrationState = "aborted"
+	// MigrationSuperseded：未完成时被下一次 migrate 取代。
+	MigrationSuperseded MigrationState = "superseded"
+)
+
+// maxMigrationHistory 是 Migrations() 保留的最近迁移条数。
+const maxMigrationHistory = 32
+
+// Migration 是一次迁移的快照。
+type Migration struct {
+	ID     string
+	Target *net.UDPAddr
+	State  MigrationState
+	// Reason：cutover 的触发方（CutoverBy*），或结束的原因（local/abort/superseded/session closed）。
+	Reason    string
+	Started   time.Time
+	CutoverAt time.Time
+	Ended     time.Time
+	// Done 在迁移结束（committed/aborted/superseded）时关闭。
+	Done <-chan struct{}
+}
+
+// Finished 返回迁移是否已结束。
+func (m Migration) Finished() bool {
+	return !m.Ended.IsZero()
+}
+
+type migrationEntry struct {
+	Migration
+	done chan struct{}
+	// earlyCommit 表示 commit 已在 cutover 之前送达（见 control_loop.go），cutover 时即视为完成。
+	earlyCommit bool
+}
+
+type migrationTable struct {
+	mu      sync.Mutex
+	cur     *migrationEntry
+	history []*migrationEntry
+	// begun 在下一次迁移开始时关闭并重建。
+	begun chan struct{}
+}
+
+func newMigrationTable() *migrationTable {
+	return &migrationTable{begun: make(chan struct{})}
+}
+
+// begin 开始迁移 id。上一次迁移尚未结束时把它记为 superseded 并返回（ok=true）。
+func (t *migrationTable) begin(id string, target *net.UDPAddr) (prev Migration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.cur != nil && t.cur.ID == id {
+		t.cur.Target = target
+		return Migration{}, false
+	}
+	if t.cur != nil {
+		prev, ok = t.finishLocked(MigrationSuperseded, "superseded", now), true
+	}
+	done := make(chan struct{})
+	t.cur = &migrationEntry{
+		Migration: Migration{ID: id, Target: target, State: MigrationArmed, Started: now, Done: done},
+		done:      done,
+	}
+	t.history = append(t.history, t.cur)
+	if n := len(t.history); n > maxMigrationHistory {
+		t.history = append([]*migrationEntry(nil), t.history[n-maxMigrationHistory:]...)
+	}
+	close(t.begun)
+	t.begun = make(chan struct{})
+	return prev, ok
+}
+
+func (t *migrationTable) finishLocked(state MigrationState, reason string, now time.Time) Migration {
+	e := t.cur
+	t.cur = nil
+	e.State = state
+	e.Ended = now
+	e.earlyCommit = false
+	if reason != "" {
+		e.Reason = reason
+	}
+	close(e.done)
+	return e.Migration
+}
+
+// finish 结束进行中的迁移（id 为空匹配任意进行中的迁移）。
+func (t *migrationTable) finish(id string, state MigrationState, reason string) (Migration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil || (id != "" && id != t.cur.ID) {
+		return Migration{}, false
+	}
+	return t.finishLocked(state, reason, time.Now()), true
+}
+
+// cutover 记录进行中的迁移已 cutover，返回其 ID（没有进行中的迁移时为空）。
+func (t *migrationTable) cutover(reason string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil {
+		return ""
+	}
+	t.cur.State = MigrationCutover
+	t.cur.CutoverAt = time.Now()
+	t.cur.Reason = reason
+	return t.cur.ID
+}
+
+func (t *migrationTable) retarget(id string, target *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur != nil && t.cur.ID == id {
+		t.cur.Target = target
+	}
+}
+
+// matches 判断 id 是否指向进行中的迁移（空 id 匹配任意进行中的迁移）。
+func (t *migrationTable) matches(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur != nil && (id == "" || id == t.cur.ID)
+}
+
+func (t *migrationTable) currentID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil {
+		return ""
+	}
+	return t.cur.ID
+}
+
+func (t *migrationTable) current() (Migration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil {
+		return Migration{}, false
+	}
+	return t.cur.Migration, true
+}
+
+func (t *migrationTable) snapshot() []Migration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Migration, 0, len(t.history))
+	for _, e := range t.history {
+		out = append(out, e.Migration)
+	}
+	return out
+}
+
+func (t *migrationTable) nextBegun() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.begun
+}
+
+// markEarlyCommit 记录进行中迁移的 commit 已在 cutover 之前送达。
+func (t *migrationTable) markEarlyCommit() {
+	t.mu.Lock()
+	if t.cur != nil {
+		t.cur.earlyCommit = true
+	}
+	t.mu.Unlock()
+}
+
+// takeEarlyCommit 在 cutover 之后完成先到的 commit。
+// 可能被控制流与探测 goroutine 同时调用，只有一方返回 ok。
+func (t *migrationTable) takeEarlyCommit() (Migration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil || !t.cur.earlyCommit {
+		return Migration{}, false
+	}
+	return t.finishLocked(MigrationCommitted, "", time.Now()), true
+}
+
+// CurrentMigration 返回进行中的迁移；没有时 ok 为 false。
+func (s *Session) CurrentMigration() (Migration, bool) {
+	return s.migrations.current()
+}
+
+// Migrations 返回本 session 最近的迁移（按开始时间排序，最多 32 条），包括已结束的。
+func (s *Session) Migrations() []Migration {
+	return s.migrations.snapshot()
+}
+
+// MigrationBegun 返回在下一次迁移开始时关闭的 channel。
+// 每次触发后需重新调用以等待再下一次；其间开始的迁移可用 CurrentMigration 取得。
+func (s *Session) MigrationBegun() <-chan struct{} {
+	return s.migrations.nextBegun()
+}
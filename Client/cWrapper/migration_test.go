@@ -0,0 +1,178 @@
+package wrapper
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// testConn 只提供 controlLoop 用到的 Context。
+type testConn struct {
+	quic.Connection
+	ctx context.Context
+}
+
+func (c testConn) Context() context.Context { return c.ctx }
+
+// testCtrl 是控制流：server 的消息经 pipe 送给 controlLoop，client 写回的 ACK 送到 acks。
+type testCtrl struct {
+	quic.Stream
+	r    *io.PipeReader
+	acks chan string
+}
+
+func (c *testCtrl) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *testCtrl) Write(p []byte) (int, error) {
+	msg, ok, err := NewLineReader(bytes.NewReader(p)).Next()
+	if err == nil && ok && msg.Type == TypeAck {
+		c.acks <- msg.AckID
+	}
+	return len(p), nil
+}
+
+// testSession 是一个不经 QUIC 的 Session：pc 是真实的 SwappableUDPConn（探测关闭），控制流由 send 驱动。
+type testSession struct {
+	*Session
+	t    *testing.T
+	w    *io.PipeWriter
+	acks chan string
+}
+
+func newTestSession(t *testing.T) *testSession {
+	t.Helper()
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	pc, err := NewSwappableUDPConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, peer, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.SetProbing(-1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+	ctrl := &testCtrl{r: r, acks: make(chan string, 16)}
+	migrateSeen := make(chan struct{})
+	s := &Session{
+		Conn:        testConn{ctx: ctx},
+		pc:          pc,
+		MigrateSeen: migrateSeen,
+		migrateSeen: migrateSeen,
+		events:      newEventStream(),
+		migrations:  newMigrationTable(),
+		outage:      newOutageGate(),
+	}
+	pc.setObserver(s.observePeer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&Manager{}).controlLoop(ctrl, s)
+	}()
+	t.Cleanup(func() {
+		_ = w.Close()
+		<-done
+		cancel()
+		_ = pc.Close()
+	})
+	return &testSession{Session: s, t: t, w: w, acks: ctrl.acks}
+}
+
+// send 把一条控制消息交给 controlLoop；消息会被 ACK 时等到 ACK，
+// commit 没有 ACK，之后用一条不匹配的 abort 作为屏障，确认它已处理完。
+func (ts *testSession) send(msg Message) {
+	ts.t.Helper()
+	if err := WriteLine(ts.w, msg); err != nil {
+		ts.t.Fatal(err)
+	}
+	if msg.Type == TypeCommit {
+		msg = Message{Type: TypeAbort, ID: "barrier"}
+		if err := WriteLine(ts.w, msg); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	select {
+	case id := <-ts.acks:
+		if id != msg.ID {
+			ts.t.Fatalf("ack %q, want %q", id, msg.ID)
+		}
+	case <-time.After(2 * time.Second):
+		ts.t.Fatalf("no ack for %s %s", msg.Type, msg.ID)
+	}
+}
+
+func migrateMsg(id string, port int) Message {
+	return Message{Type: TypeMigrate, ID: id, NewAddr: "127.0.0.1", NewPort: port}
+}
+
+// 同一 session 内的两次迁移：第一次 cutover 后 commit，第二次进行中时，
+// 指向第一次的 commit/abort/retarget 一律忽略；第二次被取代或 abort 后不再有进行中的迁移。
+func TestControlLoopSequentialMigrations(t *testing.T) {
+	s := newTestSession(t)
+	b := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10}
+	c := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11}
+
+	s.send(migrateMsg("m1", b.Port))
+	if cur, ok := s.CurrentMigration(); !ok || cur.ID != "m1" || cur.State != MigrationArmed {
+		t.Fatalf("after migrate m1: %+v %v", cur, ok)
+	}
+	if !s.CutoverToArmedPeer() {
+		t.Fatal("cutover to m1 target failed")
+	}
+	s.send(Message{Type: TypeCommit, ID: "m1"})
+	if _, ok := s.CurrentMigration(); ok {
+		t.Fatal("m1 still in progress after commit")
+	}
+
+	s.send(migrateMsg("m2", c.Port))
+	m2, ok := s.CurrentMigration()
+	if !ok || m2.ID != "m2" || !udpAddrEqual(s.ArmedPeer(), c) {
+		t.Fatalf("after migrate m2: %+v armed=%v", m2, s.ArmedPeer())
+	}
+
+	// 指向已完成的 m1 的消息：只 ACK，不改变 m2。
+	s.send(Message{Type: TypeCommit, ID: "m1"})
+	s.send(Message{Type: TypeAbort, ID: "m1"})
+	s.send(Message{Type: TypeRetarget, ID: "m1", NewAddr: "127.0.0.1", NewPort: 12})
+	if cur, ok := s.CurrentMigration(); !ok || cur.ID != "m2" || cur.State != MigrationArmed {
+		t.Fatalf("stale messages changed m2: %+v %v", cur, ok)
+	}
+	if !udpAddrEqual(s.ArmedPeer(), c) || !udpAddrEqual(s.pc.getPeer(), b) {
+		t.Fatalf("stale messages moved the peer: armed=%v real=%v", s.ArmedPeer(), s.pc.getPeer())
+	}
+
+	// m3 取代未完成的 m2；之后 m2 的 abort 被忽略，m3 的 abort 生效。
+	s.send(migrateMsg("m3", c.Port))
+	s.send(Message{Type: TypeAbort, ID: "m2"})
+	if cur, ok := s.CurrentMigration(); !ok || cur.ID != "m3" {
+		t.Fatalf("abort of superseded m2 ended %+v %v", cur, ok)
+	}
+	s.send(Message{Type: TypeAbort, ID: "m3"})
+	if cur, ok := s.CurrentMigration(); ok {
+		t.Fatalf("m3 still in progress after abort: %+v", cur)
+	}
+	if s.ArmedPeer() != nil || !udpAddrEqual(s.pc.getPeer(), b) {
+		t.Fatalf("after abort: armed=%v real=%v", s.ArmedPeer(), s.pc.getPeer())
+	}
+
+	want := []struct {
+		id    string
+		state MigrationState
+	}{{"m1", MigrationCommitted}, {"m2", MigrationSuperseded}, {"m3", MigrationAborted}}
+	got := s.Migrations()
+	if len(got) != len(want) {
+		t.Fatalf("history %+v", got)
+	}
+	for i, w := range want {
+		if got[i].ID != w.id || got[i].State != w.state || !got[i].Finished() {
+			t.Errorf("history[%d] = %s %s, want %s %s", i, got[i].ID, got[i].State, w.id, w.state)
+		}
+		select {
+		case <-got[i].Done:
+		default:
+			t.Errorf("%s: Done not closed", got[i].ID)
+		}
+	}
+}
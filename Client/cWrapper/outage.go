@@ -0,0 +1,76 @@
+package wrapper
+
+import "sync"
+
+// outageGate 记录“迁移中断窗口”：
+//   - begin：控制流收到 migrate（MigrateSeen）时进入中断态。
+//   - end：CutoverToArmedPeer 成功，且新 realPeer 已回包（路径确认可达）时退出；
+//     迁移被 abort（DisarmPeer）时，等当前 realPeer 可达后同样退出。
+//
+// BufferedStream 在中断态下只缓存写入，等 recovered 关闭后再按序回放。
+type outageGate struct {
+	mu        sync.Mutex
+	active    bool
+	recovered chan struct{}
+}
+
+func newOutageGate() *outageGate {
+	ch := make(chan struct{})
+	close(ch)
+	return &outageGate{recovered: ch}
+}
+
+// begin 进入中断态，返回本轮结束时关闭的 channel（已处于中断态时返回当前轮的）。
+func (g *outageGate) begin() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.active {
+		g.active = true
+		g.recovered = make(chan struct{})
+	}
+	return g.recovered
+}
+
+func (g *outageGate) end() {
+	g.endRound(nil)
+}
+
+// endRound 只结束 round 对应的那一轮中断（round 为 nil 表示当前轮）。
+// 迁移被 abort 后很快又开始新迁移时，旧一轮的 awaitRecovery 不会误结束新一轮。
+func (g *outageGate) endRound(round <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.active {
+		return
+	}
+	if round != nil && round != (<-chan struct{})(g.recovered) {
+		return
+	}
+	g.active = false
+	close(g.recovered)
+}
+
+// state 返回当前是否处于中断态，以及本轮中断结束时会关闭的 channel。
+func (g *outageGate) state() (active bool, recovered <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active, g.recovered
+}
+
+// awaitRecovery 等待本轮 armed peer 完成 cutover（或被 DisarmPeer 放弃）且当前路径确认可达，然后结束 round 这一轮中断。
+// cutover 需在 ArmPeer 之后立即取得（pc.armedCutover()），避免错过很快到来的 cutover。
+// done 关闭（session 结束）时直接返回，中断态保持不变。
+func (g *outageGate) awaitRecovery(pc *SwappableUDPConn, cutover, round <-chan struct{}, done <-chan struct{}) {
+	select {
+	case <-cutover:
+	case <-done:
+		return
+	}
+	select {
+	case <-pc.PeerAlive():
+	case <-done:
+		return
+	}
+	g.endRound(round)
+	tracef("outage ended; peer=%s alive", pc.getPeer())
+}
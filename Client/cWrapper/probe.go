@@ -0,0 +1,106 @@
+package wrapper
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"time"
+)
+
+// 路径探测（与 sWrapper 的 probe.go 对应）。
+//
+// 背景：B restore + rebind 后，B 上的 QUIC 只有在收到 client 的包之后才会回包（端口映射/NAT 也要求先有出向流量），
+// 而 client 在 cutover 之前只向 A 发送。因此 client 在 armed 周期内主动向候选对端发送探测包，
+// B 的 MigratableUDP 在 QUIC 之外直接回复；收到回复（且 A 已静默）即视为 commit，立刻 cutover，
+// 不必等业务层 IO 超时。
+//
+// 反过来，client 换了本地地址后，服务端会向新地址发送同样格式的探测包做路径验证，client 原样回显。
+//
+// 格式：4 字节 magic + 8 字节 nonce。magic 首字节为 0，不会与 QUIC 包（fixed bit=1）混淆。
+// 一轮 armed 周期（换目标时重新生成）的探测包使用同一个 nonce，B 原样回显；nonce 不符的回复（伪造或上一轮的在途包）忽略。
+var (
+	probeRequestMagic = []byte{0x00, 'w', 'p', '?'}
+	probeReplyMagic   = []byte{0x00, 'w', 'p', '!'}
+)
+
+const (
+	probeLen      = 12
+	probeNonceLen = probeLen - 4
+
+	// 默认探测参数，可经 Manager.ProbeInterval/ProbeQuietAfter 或 SetProbing 修改。
+	defaultProbeInterval   = 20 * time.Millisecond
+	defaultProbeQuietAfter = 50 * time.Millisecond
+)
+
+func newProbeNonce() (n [probeNonceLen]byte) {
+	_, _ = rand.Read(n[:])
+	return n
+}
+
+func newProbe(nonce [probeNonceLen]byte) []byte {
+	b := make([]byte, probeLen)
+	copy(b, probeRequestMagic)
+	copy(b[len(probeRequestMagic):], nonce[:])
+	return b
+}
+
+func isProbeRequest(b []byte) bool {
+	return len(b) == probeLen && bytes.HasPrefix(b, probeRequestMagic)
+}
+
+// answerProbe 把服务端的探测请求改写为回复，从当前 socket 回送给来源。
+func (s *SwappableUDPConn) answerProbe(req []byte, from *net.UDPAddr) {
+	c, _, _ := s.current()
+	if c == nil || from == nil {
+		return
+	}
+	reply := make([]byte, probeLen)
+	copy(reply, probeReplyMagic)
+	copy(reply[len(probeReplyMagic):], req[len(probeRequestMagic):])
+	_, _ = c.WriteToUDP(reply, from)
+}
+
+func isProbeReply(b []byte) bool {
+	return len(b) == probeLen && bytes.HasPrefix(b, probeReplyMagic)
+}
+
+// probeReplyNonce 返回探测回复回显的 nonce（b 须已通过 isProbeReply）。
+func probeReplyNonce(b []byte) []byte {
+	return b[len(probeReplyMagic):]
+}
+
+// probeLoop 在一轮 armed 周期内每 every 探测一次候选对端。
+// 候选对端已回复、且旧对端已静默 quietAfter 时 cutover：回复说明 B 已 restore + rebind，
+// 静默说明 A 已停止服务，避免 A 仍在回包时（例如回滚中恢复出来的 A）提前切走。
+// cutover（本轮已切换或被 DisarmPeer 放弃）、stop（新一轮 ArmPeer）或连接关闭时退出。
+func (s *SwappableUDPConn) probeLoop(every, quietAfter time.Duration, cutover, stop <-chan struct{}) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	waiting := false
+	for {
+		if s.armedAnswered() {
+			if s.realQuietFor(quietAfter) {
+				if s.cutover(CutoverByProbe) {
+					tracef("probe answered; cutover to=%s", s.getPeer())
+				}
+				return
+			}
+			if !waiting {
+				waiting = true
+				tracef("probe answered by %s; waiting for old peer to go quiet", s.ArmedPeer())
+			}
+		} else {
+			waiting = false
+			s.sendProbe()
+		}
+		select {
+		case <-cutover:
+			return
+		case <-stop:
+			return
+		case <-s.closed:
+			return
+		case <-t.C:
+		}
+	}
+}
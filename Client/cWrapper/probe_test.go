@@ -0,0 +1,90 @@
+package wrapper
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// 探测回复只认当前 armedPeer 回显了本轮 nonce 的回复。
+func TestProbeReplyNonce(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	pc, err := NewSwappableUDPConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, peer, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetProbing(-1, 0)
+	armed := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10}
+	pc.ArmPeer(armed)
+
+	reply := func(nonce [probeNonceLen]byte) []byte {
+		b := newProbe(nonce)
+		copy(b, probeReplyMagic)
+		return b
+	}
+	pc.peerMu.RLock()
+	nonce := pc.probeNonce
+	pc.peerMu.RUnlock()
+	stale := nonce
+	stale[0] ^= 0xff
+
+	if pc.accept(reply(stale), armed) || pc.armedAnswered() {
+		t.Fatal("accepted a reply with a foreign nonce")
+	}
+	if pc.accept(reply(nonce), peer) || pc.armedAnswered() {
+		t.Fatal("accepted a reply from the old peer")
+	}
+	if pc.accept(reply(nonce), armed) || !pc.armedAnswered() {
+		t.Fatal("ignored the matching reply")
+	}
+
+	// 换目标后上一轮的 nonce 作废。
+	next := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11}
+	pc.RetargetPeer(next)
+	if pc.accept(reply(nonce), next) || pc.armedAnswered() {
+		t.Fatal("accepted the previous round's nonce after retarget")
+	}
+}
+
+// 服务端对新地址的路径验证：只回显当前对端的探测请求。
+func TestAnswerServerProbe(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	peer := server.LocalAddr().(*net.UDPAddr)
+	pc, err := NewSwappableUDPConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, peer, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	nonce := newProbeNonce()
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: peer.Port + 1}
+	if pc.accept(newProbe(nonce), other) {
+		t.Fatal("probe request handed to quic-go")
+	}
+	if pc.accept(newProbe(nonce), peer) {
+		t.Fatal("probe request handed to quic-go")
+	}
+
+	buf := make([]byte, 64)
+	_ = server.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := server.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isProbeReply(buf[:n]) || string(probeReplyNonce(buf[:n])) != string(nonce[:]) {
+		t.Fatalf("reply %x", buf[:n])
+	}
+	if from.Port != pc.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatalf("reply from %v, want %v", from, pc.LocalAddr())
+	}
+	// 只应有一个回复（other 的请求被忽略）。
+	_ = server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _, err := server.ReadFromUDP(buf); err == nil {
+		t.Fatalf("unexpected second datagram %x", buf[:n])
+	}
+}
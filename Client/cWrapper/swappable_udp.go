@@ -1,10 +1,12 @@
 package wrapper
 
 import (
+	"bytes"
 	"errors"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,30 +26,76 @@ import (
 // 并发：
 //   - quic-go 会并发调用 ReadFrom/WriteTo。
 //   - SetPeer 也可能并发发生。
-//   - 若未来需要本地 IP 变化（客户端迁移到新网卡/IP），可用 RebindLocal()。
+//   - 本地 IP 变化（客户端迁移到新网卡/IP）时用 RebindLocal()，由 local_addr.go 按接口变化自动触发。
 //
 // 安全/语义：
 //   - 我们只做地址层面的“转发/伪装”，不修改 QUIC 数据内容。
 //   - 这不会绕过 QUIC 的加密/认证（握手/密钥仍由 quic-go 管理）。
 //   - 但它会隐藏路径迁移信息，因此更像“强制固定路径视图”。
 //
-// 该实现仅依赖 net.PacketConn 的基础接口，不使用 quic-go 的 OOBCapablePacketConn。
-// 如果后续需要 ECN/OOB，可再扩展。
+// armed 周期内它还会在 QUIC 之外向候选对端发送探测包（probe.go），据回复自动 cutover。
+//
+// 除 net.PacketConn 外，它还实现了 quic-go 的 OOBCapablePacketConn 与批量读（见 udp_oob.go），
+// 使 ECN/GSO/packet-info 可用；这些能力在 RebindLocal 之后同样生效。
 //
 // 参考：Server 侧的 MigratableUDP 解决的是“本地 UDP socket rebind”；
 // 这里额外解决的是“对端地址变更但 QUIC 不感知”。
 
+// defaultDualPathWindow 是 cutover 后继续接收旧对端在途包的默认窗口。
+const defaultDualPathWindow = 200 * time.Millisecond
+
 type SwappableUDPConn struct {
 	mu      sync.Mutex
 	network string
 	laddr   *net.UDPAddr
 	conn    *net.UDPConn
+	batch   batchReader
 	gen     uint64
 
+	// setup 记录 quic-go 对 socket 做过的设置，RebindLocal 时在新 socket 上重放。
+	setup socketSetup
+
 	peerMu    sync.RWMutex
 	realPeer  *net.UDPAddr
 	armedPeer *net.UDPAddr
 	fakePeer  net.Addr
+
+	// prevPeer 是最近一次 cutover 之前的 realPeer；迁移被放弃（DisarmPeer）时据此切回。
+	// 新 realPeer 首次回包或下一次 ArmPeer 时清空。
+	prevPeer *net.UDPAddr
+
+	// cutoverCh 在 ArmPeer 时创建，CutoverToArmedPeer 时关闭。
+	// aliveCh 在 cutover 后重建，收到新 realPeer 的第一个数据报时关闭（路径确认可达）。
+	cutoverCh chan struct{}
+	aliveCh   chan struct{}
+	alive     bool
+
+	// answered 表示本轮 armedPeer 已回复探测（见 probe.go）；ArmPeer/RetargetPeer 换目标时清零。
+	// probeNonce 是本轮探测包携带的 nonce，与 answered 同时重新生成；只认回显了它的回复。
+	// probeStop 在 ArmPeer 时重建，关闭即结束上一轮的探测 goroutine。
+	answered   bool
+	probeNonce [probeNonceLen]byte
+	probeStop  chan struct{}
+
+	// 探测参数（SetProbing）：probeEvery<=0 表示不探测。
+	probeEvery time.Duration
+	quietAfter time.Duration
+
+	// 双路径接收窗口（SetDualPathWindow）：dualWindow<=0 表示只接收当前 realPeer。
+	//   - cutover 之前：同时接收 armedPeer 的早到包（例如 B restore 后 QUIC 的重传）。
+	//   - cutover 之后 dualWindow 内：同时接收旧对端（oldPeer）仍在途的包。
+	dualWindow time.Duration
+	oldPeer    *net.UDPAddr
+	oldUntil   time.Time
+	stats      pathCounters
+
+	// lastRealRx 是最近一次收到 realPeer 数据报的时间（UnixNano），用于判断旧对端是否已静默。
+	lastRealRx atomic.Int64
+
+	// observe 接收探测成功/cutover 等事件（setObserver）。
+	observe func(ev peerEvent, peer *net.UDPAddr, reason string)
+
+	closed chan struct{}
 }
 
 func NewSwappableUDPConn(network string, laddr *net.UDPAddr, realPeer *net.UDPAddr, fakePeer net.Addr) (*SwappableUDPConn, error) {
@@ -55,7 +103,25 @@ func NewSwappableUDPConn(network string, laddr *net.UDPAddr, realPeer *net.UDPAd
 	if err != nil {
 		return nil, err
 	}
-	return &SwappableUDPConn{network: network, laddr: laddr, conn: c, gen: 1, realPeer: realPeer, fakePeer: fakePeer}, nil
+	aliveCh := make(chan struct{})
+	close(aliveCh)
+	return &SwappableUDPConn{
+		network: network, laddr: laddr, conn: c, batch: newBatchReader(c), gen: 1,
+		realPeer: realPeer, fakePeer: fakePeer, aliveCh: aliveCh, alive: true,
+		probeEvery: defaultProbeInterval, quietAfter: defaultProbeQuietAfter,
+		dualWindow: defaultDualPathWindow,
+		closed:     make(chan struct{}),
+	}, nil
+}
+
+// SetProbing 设置 armed 周期内的主动探测参数（对之后的 ArmPeer 生效）：
+//   - every：向候选对端发送探测包的间隔；<=0 表示不探测（只能靠 APP/带外 commit 触发 cutover）。
+//   - quietAfter：候选对端回复后，旧对端需静默多久才自动 cutover；<=0 表示回复后立即 cutover。
+func (s *SwappableUDPConn) SetProbing(every, quietAfter time.Duration) {
+	s.peerMu.Lock()
+	s.probeEvery = every
+	s.quietAfter = quietAfter
+	s.peerMu.Unlock()
 }
 
 // SetPeer 切换真实对端地址（线程安全）。
@@ -68,21 +134,62 @@ func (s *SwappableUDPConn) SetPeer(peer *net.UDPAddr) {
 	s.peerMu.Unlock()
 }
 
-// ArmPeer 设置“候选对端”。不会立刻影响 UDP 收发。
+// SetDualPathWindow 设置 cutover 前后同时接收新旧两条路径的窗口；<=0 表示关闭（只接收当前 realPeer）。
+func (s *SwappableUDPConn) SetDualPathWindow(d time.Duration) {
+	s.peerMu.Lock()
+	s.dualWindow = d
+	s.peerMu.Unlock()
+}
+
+// PathStats 返回按来源统计的接收计数（见 PathStats 类型）。
+func (s *SwappableUDPConn) PathStats() PathStats {
+	return s.stats.snapshot()
+}
+
+// ArmPeer 设置“候选对端”。不会立刻影响 QUIC 的 UDP 收发。
 //
 // 典型用法：控制流收到 migrate(new) 时 ArmPeer(new)。
-// 然后当旧对端真的不可用（例如业务 IO 超时）时，再 CutoverToArmedPeer()。
+// 之后按 SetProbing 的间隔向候选对端发送探测包；候选对端回复且旧对端已静默时自动 cutover。
+// 探测关闭时，仍由 APP 在旧对端真的不可用（例如业务 IO 超时）时调用 CutoverToArmedPeer()。
 func (s *SwappableUDPConn) ArmPeer(peer *net.UDPAddr) {
 	s.peerMu.Lock()
 	s.armedPeer = peer
+	s.prevPeer = nil
+	if s.cutoverCh == nil {
+		s.cutoverCh = make(chan struct{})
+	}
+	s.answered = false
+	s.probeNonce = newProbeNonce()
+	if s.probeStop != nil {
+		close(s.probeStop)
+		s.probeStop = nil
+	}
+	if s.probeEvery > 0 {
+		s.probeStop = make(chan struct{})
+		go s.probeLoop(s.probeEvery, s.quietAfter, s.cutoverCh, s.probeStop)
+	}
 	s.peerMu.Unlock()
 }
 
 // CutoverToArmedPeer 将真实对端切换到 armedPeer（若存在）。
 // 返回值表示是否发生了切换。
 func (s *SwappableUDPConn) CutoverToArmedPeer() bool {
+	return s.cutover(CutoverByApp)
+}
+
+// cutover 与 CutoverToArmedPeer 相同，reason 记录触发方（见 CutoverBy*），随 cutover 事件交给 observer。
+func (s *SwappableUDPConn) cutover(reason string) bool {
 	s.peerMu.Lock()
-	defer s.peerMu.Unlock()
+	ok := s.cutoverLocked()
+	peer, observe := s.realPeer, s.observe
+	s.peerMu.Unlock()
+	if ok && observe != nil {
+		observe(peerCutover, peer, reason)
+	}
+	return ok
+}
+
+func (s *SwappableUDPConn) cutoverLocked() bool {
 	if s.armedPeer == nil {
 		return false
 	}
@@ -90,13 +197,168 @@ func (s *SwappableUDPConn) CutoverToArmedPeer() bool {
 	if s.realPeer != nil && udpAddrEqual(s.realPeer, s.armedPeer) {
 		// Still clear armedPeer to avoid repeated commit signals keeping stale state.
 		s.armedPeer = nil
+		s.signalCutoverLocked()
 		return false
 	}
+	s.prevPeer = s.realPeer
+	if s.dualWindow > 0 {
+		s.oldPeer = s.realPeer
+		s.oldUntil = time.Now().Add(s.dualWindow)
+	}
 	s.realPeer = s.armedPeer
 	s.armedPeer = nil
+	s.alive = false
+	s.aliveCh = make(chan struct{})
+	s.signalCutoverLocked()
 	return true
 }
 
+// ArmedPeer 返回当前候选对端；未 arm（或已 cutover/disarm）时返回 nil。
+func (s *SwappableUDPConn) ArmedPeer() *net.UDPAddr {
+	s.peerMu.RLock()
+	p := s.armedPeer
+	s.peerMu.RUnlock()
+	return p
+}
+
+// RetargetPeer 替换当前 armed 周期的目标：
+//   - 尚未 cutover：直接替换 armedPeer；
+//   - 本周期已 cutover：realPeer 改为新目标（prevPeer 保留，仍可 DisarmPeer 切回），并重新等待其回包。
+//
+// 没有进行中的 armed 周期时返回 false。
+func (s *SwappableUDPConn) RetargetPeer(peer *net.UDPAddr) bool {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	switch {
+	case s.armedPeer != nil:
+		if !udpAddrEqual(s.armedPeer, peer) {
+			s.armedPeer = peer
+			s.answered = false
+			s.probeNonce = newProbeNonce()
+		}
+		return true
+	case s.prevPeer != nil:
+		if udpAddrEqual(s.realPeer, peer) {
+			return true
+		}
+		s.realPeer = peer
+		s.alive = false
+		s.aliveCh = make(chan struct{})
+		return true
+	default:
+		return false
+	}
+}
+
+// DisarmPeer 放弃当前 armed 周期（迁移被回滚）：清除 armedPeer；
+// 若本周期已经 cutover，则切回 cutover 前的对端，并重新等待其回包。
+// 返回值表示是否撤销了任何状态。
+func (s *SwappableUDPConn) DisarmPeer() bool {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	changed := false
+	if s.armedPeer != nil {
+		s.armedPeer = nil
+		changed = true
+	}
+	if s.prevPeer != nil {
+		// 被放弃的目标不再接收：旧路径窗口一并关闭。
+		s.oldPeer = nil
+		s.realPeer = s.prevPeer
+		s.prevPeer = nil
+		s.alive = false
+		s.aliveCh = make(chan struct{})
+		changed = true
+	}
+	// 结束本轮 armed 周期：等待 cutover 的一方随后只需等当前 realPeer 可达。
+	s.signalCutoverLocked()
+	return changed
+}
+
+func (s *SwappableUDPConn) signalCutoverLocked() {
+	if s.cutoverCh != nil {
+		close(s.cutoverCh)
+		s.cutoverCh = nil
+	}
+}
+
+// armedCutover 返回当前 armed 周期的 cutover 信号；未 arm 时返回 nil（永远阻塞）。
+func (s *SwappableUDPConn) armedCutover() <-chan struct{} {
+	s.peerMu.RLock()
+	ch := s.cutoverCh
+	s.peerMu.RUnlock()
+	return ch
+}
+
+// armedAnswered 返回当前 armedPeer 是否已回复探测。
+func (s *SwappableUDPConn) armedAnswered() bool {
+	s.peerMu.RLock()
+	ok := s.armedPeer != nil && s.answered
+	s.peerMu.RUnlock()
+	return ok
+}
+
+// realQuietFor 返回旧对端（当前 realPeer）是否已有 d 未回包。
+func (s *SwappableUDPConn) realQuietFor(d time.Duration) bool {
+	last := s.lastRealRx.Load()
+	return last == 0 || time.Since(time.Unix(0, last)) >= d
+}
+
+// sendProbe 向 armedPeer 发送一个探测包（绕过 QUIC）；未 arm 时不发送。
+func (s *SwappableUDPConn) sendProbe() {
+	s.peerMu.RLock()
+	peer, nonce := s.armedPeer, s.probeNonce
+	s.peerMu.RUnlock()
+	if peer == nil {
+		return
+	}
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c != nil {
+		_, _ = c.WriteToUDP(newProbe(nonce), peer)
+	}
+}
+
+// onProbeReply 处理探测回复：只认当前 armedPeer 回显了本轮 nonce 的回复。
+func (s *SwappableUDPConn) onProbeReply(from *net.UDPAddr, nonce []byte) {
+	s.peerMu.Lock()
+	if s.armedPeer == nil || s.answered || !udpAddrEqual(s.armedPeer, from) || !bytes.Equal(nonce, s.probeNonce[:]) {
+		s.peerMu.Unlock()
+		return
+	}
+	s.answered = true
+	observe := s.observe
+	s.peerMu.Unlock()
+	if observe != nil {
+		observe(peerProbeOK, from, "")
+	}
+}
+
+// peerEvent 是 SwappableUDPConn 交给 observer 的内部事件，由 Session 转成 Event。
+type peerEvent int
+
+const (
+	peerProbeOK peerEvent = iota
+	peerCutover
+)
+
+// setObserver 设置对端事件回调（在锁外调用，不得阻塞）。
+func (s *SwappableUDPConn) setObserver(f func(ev peerEvent, peer *net.UDPAddr, reason string)) {
+	s.peerMu.Lock()
+	s.observe = f
+	s.peerMu.Unlock()
+}
+
+// PeerAlive 返回一个 channel：最近一次 cutover 之后，首次收到新 realPeer 的数据报时关闭。
+// 从未 cutover 时该 channel 已关闭。
+func (s *SwappableUDPConn) PeerAlive() <-chan struct{} {
+	s.peerMu.RLock()
+	ch := s.aliveCh
+	s.peerMu.RUnlock()
+	return ch
+}
+
 func (s *SwappableUDPConn) getPeer() *net.UDPAddr {
 	s.peerMu.RLock()
 	p := s.realPeer
@@ -104,38 +366,109 @@ func (s *SwappableUDPConn) getPeer() *net.UDPAddr {
 	return p
 }
 
+// classify 判断数据报来源属于哪条路径，并返回当前 realPeer 是否已确认可达。
+func (s *SwappableUDPConn) classify(from *net.UDPAddr) (pathSource, bool) {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	switch {
+	case s.realPeer == nil || from == nil:
+		return srcUnfiltered, s.alive
+	case udpAddrEqual(s.realPeer, from):
+		return srcCurrent, s.alive
+	case s.dualWindow <= 0:
+		return srcOther, s.alive
+	case s.armedPeer != nil && udpAddrEqual(s.armedPeer, from):
+		return srcArmed, s.alive
+	case s.oldPeer != nil && udpAddrEqual(s.oldPeer, from) && time.Now().Before(s.oldUntil):
+		return srcOld, s.alive
+	default:
+		return srcOther, s.alive
+	}
+}
+
+func (s *SwappableUDPConn) markPeerAlive(from *net.UDPAddr) {
+	s.peerMu.Lock()
+	if !s.alive && udpAddrEqual(s.realPeer, from) {
+		s.alive = true
+		close(s.aliveCh)
+		// 新对端已回包：本轮迁移视为完成，之后的 DisarmPeer/RetargetPeer 不再切回旧对端。
+		s.prevPeer = nil
+	}
+	s.peerMu.Unlock()
+}
+
+// accept 对收到的数据报做 QUIC 之外的处理：拦截探测回复与服务端的路径验证、按来源过滤并计数。
+// 返回 false 表示该数据报不交给 quic-go。
+func (s *SwappableUDPConn) accept(b []byte, from *net.UDPAddr) bool {
+	if isProbeReply(b) {
+		s.onProbeReply(from, probeReplyNonce(b))
+		return false
+	}
+	if isProbeRequest(b) {
+		// 只回应当前（及双路径窗口内的候选/旧）对端，不替任意来源回显。
+		if src, _ := s.classify(from); src != srcOther {
+			s.answerProbe(b, from)
+		}
+		return false
+	}
+	// 只接收当前 realPeer 的包，避免误收其他来源（例如端口复用/噪音）；
+	// 双路径窗口内，armedPeer 的早到包与旧对端的在途包同样交给 quic-go，省去一轮重传。
+	src, alive := s.classify(from)
+	s.stats.count(src)
+	switch src {
+	case srcOther:
+		return false
+	case srcCurrent:
+		s.lastRealRx.Store(time.Now().UnixNano())
+		if !alive {
+			s.markPeerAlive(from)
+		}
+	}
+	return true
+}
+
+// presentAddr 返回交给 quic-go 的来源地址：设置了 fakePeer 时一律伪装成 fakePeer。
+func (s *SwappableUDPConn) presentAddr(from *net.UDPAddr) net.Addr {
+	if s.fakePeer != nil {
+		return s.fakePeer
+	}
+	return from
+}
+
+// current 返回当前代的 socket（及其批量读视图）与 generation。
+func (s *SwappableUDPConn) current() (*net.UDPConn, batchReader, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn, s.batch, s.gen
+}
+
+// swapped 判断 err 是否因为 RebindLocal 换掉了 c（此时调用方应在新 socket 上重试）。
+func (s *SwappableUDPConn) swapped(c *net.UDPConn, g uint64, err error) bool {
+	if !isNetClosing(err) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != c || s.gen != g
+}
+
 func (s *SwappableUDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
 	for {
-		s.mu.Lock()
-		c := s.conn
-		g := s.gen
-		s.mu.Unlock()
+		c, _, g := s.current()
 		if c == nil {
 			return 0, nil, errors.New("udp conn is nil")
 		}
 
 		n, from, err := c.ReadFromUDP(p)
 		if err == nil {
-			peer := s.getPeer()
-			// 只接收当前 realPeer 的包，避免误收其他来源（例如端口复用/噪音）。
-			if peer != nil && from != nil {
-				if !udpAddrEqual(peer, from) {
-					continue
-				}
-			}
-			if s.fakePeer != nil {
-				return n, s.fakePeer, nil
+			if !s.accept(p[:n], from) {
+				continue
 			}
-			return n, from, nil
+			return n, s.presentAddr(from), nil
 		}
 
-		if isNetClosing(err) {
-			s.mu.Lock()
-			same := s.conn == c && s.gen == g
-			s.mu.Unlock()
-			if !same {
-				continue
-			}
+		if s.swapped(c, g, err) {
+			continue
 		}
 		return 0, nil, err
 	}
@@ -147,10 +480,7 @@ func (s *SwappableUDPConn) WriteTo(p []byte, _ net.Addr) (int, error) {
 		return 0, errors.New("real peer is nil")
 	}
 	for {
-		s.mu.Lock()
-		c := s.conn
-		g := s.gen
-		s.mu.Unlock()
+		c, _, g := s.current()
 		if c == nil {
 			return 0, errors.New("udp conn is nil")
 		}
@@ -158,28 +488,38 @@ func (s *SwappableUDPConn) WriteTo(p []byte, _ net.Addr) (int, error) {
 		if err == nil {
 			return n, nil
 		}
-		if isNetClosing(err) {
-			s.mu.Lock()
-			same := s.conn == c && s.gen == g
-			s.mu.Unlock()
-			if !same {
-				continue
-			}
+		if s.swapped(c, g, err) {
+			continue
 		}
 		return n, err
 	}
 }
 
-// RebindLocal 用于客户端本地地址变化时重建 UDP socket（可选能力）。
+// boundAddr 返回当前显式绑定的本地地址；通配绑定时返回 nil。
+func (s *SwappableUDPConn) boundAddr() *net.UDPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.laddr == nil || s.laddr.IP == nil || s.laddr.IP.IsUnspecified() {
+		return nil
+	}
+	return s.laddr
+}
+
+// RebindLocal 用于客户端本地地址变化时重建 UDP socket（见 local_addr.go）。
 // laddr 为空表示沿用创建时的 laddr。
 func (s *SwappableUDPConn) RebindLocal(laddr *net.UDPAddr) error {
 	if laddr == nil {
+		// laddr 会被并发的 RebindLocal（地址监视）改写，同样在 mu 下读取。
+		s.mu.Lock()
 		laddr = s.laddr
+		s.mu.Unlock()
 	}
 	newConn, err := net.ListenUDP(s.network, laddr)
 	if err != nil {
 		return err
 	}
+	// quic-go 对旧 socket 做过的设置（ECN/packet-info/缓冲区）在新 socket 上重放。
+	s.setup.apply(newConn)
 
 	s.mu.Lock()
 	old := s.conn
@@ -189,6 +529,7 @@ func (s *SwappableUDPConn) RebindLocal(laddr *net.UDPAddr) error {
 		return errors.New("udp conn is nil")
 	}
 	s.conn = newConn
+	s.batch = newBatchReader(newConn)
 	s.laddr = laddr
 	s.gen++
 	s.mu.Unlock()
@@ -205,7 +546,9 @@ func (s *SwappableUDPConn) Close() error {
 	}
 	err := s.conn.Close()
 	s.conn = nil
+	s.batch = nil
 	s.gen++
+	close(s.closed)
 	return err
 }
 
@@ -240,6 +583,50 @@ func (s *SwappableUDPConn) SetWriteDeadline(t time.Time) error {
 	return c.SetWriteDeadline(t)
 }
 
+// pathSource 是 ReadFrom 对数据报来源的分类。
+type pathSource int
+
+const (
+	srcUnfiltered pathSource = iota // 未设置 realPeer 或来源未知：不过滤
+	srcCurrent                      // 当前 realPeer
+	srcArmed                        // cutover 之前的候选对端
+	srcOld                          // cutover 之后窗口内的旧对端
+	srcOther                        // 其他来源：丢弃
+)
+
+// PathStats 是 SwappableUDPConn 按来源统计的接收计数（不含探测包）。
+type PathStats struct {
+	// Current：来自当前 realPeer（含未过滤的包）。
+	Current uint64
+	// Armed：cutover 之前来自候选对端、在双路径窗口下被接收的包。
+	Armed uint64
+	// Old：cutover 之后来自旧对端、在双路径窗口内被接收的包。
+	Old uint64
+	// Dropped：其他来源（或窗口外的旧对端）被丢弃的包。
+	Dropped uint64
+}
+
+type pathCounters struct {
+	current, armed, old, dropped atomic.Uint64
+}
+
+func (c *pathCounters) count(src pathSource) {
+	switch src {
+	case srcArmed:
+		c.armed.Add(1)
+	case srcOld:
+		c.old.Add(1)
+	case srcOther:
+		c.dropped.Add(1)
+	default:
+		c.current.Add(1)
+	}
+}
+
+func (c *pathCounters) snapshot() PathStats {
+	return PathStats{Current: c.current.Load(), Armed: c.armed.Load(), Old: c.old.Load(), Dropped: c.dropped.Load()}
+}
+
 func udpAddrEqual(a, b *net.UDPAddr) bool {
 	if a == nil || b == nil {
 		return false
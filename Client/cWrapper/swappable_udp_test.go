@@ -0,0 +1,212 @@
+package wrapper
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T, peer *net.UDPAddr) *SwappableUDPConn {
+	t.Helper()
+	pc, err := NewSwappableUDPConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, peer, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	pc.SetProbing(-1, 0)
+	return pc
+}
+
+func loopback(port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// cutover 之前：RetargetPeer 只替换候选对端；DisarmPeer 清除它并结束本轮 armed 周期，真实对端不变。
+func TestDisarmRetargetBeforeCutover(t *testing.T) {
+	a, b, c := loopback(9), loopback(10), loopback(11)
+	pc := newTestConn(t, a)
+
+	pc.ArmPeer(b)
+	cut := pc.armedCutover()
+	if !pc.RetargetPeer(c) || !udpAddrEqual(pc.ArmedPeer(), c) {
+		t.Fatalf("retarget: armed=%v", pc.ArmedPeer())
+	}
+	if closed(cut) || !udpAddrEqual(pc.getPeer(), a) {
+		t.Fatalf("retarget ended the armed round or moved the peer to %v", pc.getPeer())
+	}
+
+	if !pc.DisarmPeer() || pc.ArmedPeer() != nil || !udpAddrEqual(pc.getPeer(), a) {
+		t.Fatalf("disarm: armed=%v real=%v", pc.ArmedPeer(), pc.getPeer())
+	}
+	if !closed(cut) {
+		t.Fatal("disarm did not end the armed round")
+	}
+	if pc.CutoverToArmedPeer() || !udpAddrEqual(pc.getPeer(), a) {
+		t.Fatalf("cutover after disarm moved the peer to %v", pc.getPeer())
+	}
+	if pc.RetargetPeer(b) || pc.ArmedPeer() != nil {
+		t.Fatal("retarget without an armed round")
+	}
+	if pc.DisarmPeer() {
+		t.Fatal("second disarm reported a change")
+	}
+}
+
+// cutover 之后、新对端回包之前：RetargetPeer 改投真实对端，DisarmPeer 切回 cutover 前的对端；
+// 新对端回包后本轮迁移已完成，二者都不再生效。
+func TestDisarmRetargetAfterCutover(t *testing.T) {
+	a, b, c := loopback(9), loopback(10), loopback(11)
+	pc := newTestConn(t, a)
+
+	pc.ArmPeer(b)
+	if !pc.CutoverToArmedPeer() || !udpAddrEqual(pc.getPeer(), b) {
+		t.Fatalf("cutover: real=%v", pc.getPeer())
+	}
+	if !pc.RetargetPeer(c) || !udpAddrEqual(pc.getPeer(), c) || pc.ArmedPeer() != nil {
+		t.Fatalf("retarget after cutover: real=%v armed=%v", pc.getPeer(), pc.ArmedPeer())
+	}
+	if closed(pc.PeerAlive()) {
+		t.Fatal("retargeted peer counted as alive")
+	}
+	if !pc.DisarmPeer() || !udpAddrEqual(pc.getPeer(), a) {
+		t.Fatalf("disarm after cutover: real=%v", pc.getPeer())
+	}
+	if pc.accept([]byte{0x40, 1, 2, 3}, c) {
+		t.Fatal("accepted a datagram from the abandoned target")
+	}
+
+	pc.ArmPeer(b)
+	pc.CutoverToArmedPeer()
+	if !pc.accept([]byte{0x40, 1, 2, 3}, b) || !closed(pc.PeerAlive()) {
+		t.Fatal("new peer not marked alive")
+	}
+	if pc.RetargetPeer(c) || pc.DisarmPeer() || !udpAddrEqual(pc.getPeer(), b) {
+		t.Fatalf("completed migration undone: real=%v", pc.getPeer())
+	}
+}
+
+// 控制流：retarget 把进行中的迁移改投新目标，abort 放弃它，二者都记入迁移表。
+func TestControlLoopRetargetAbort(t *testing.T) {
+	s := newTestSession(t)
+	a, c := s.pc.getPeer(), loopback(11)
+
+	s.send(migrateMsg("m1", 10))
+	s.send(Message{Type: TypeRetarget, ID: "m1", NewAddr: "127.0.0.1", NewPort: c.Port})
+	cur, ok := s.CurrentMigration()
+	if !ok || !udpAddrEqual(cur.Target, c) || !udpAddrEqual(s.ArmedPeer(), c) {
+		t.Fatalf("after retarget: %+v armed=%v", cur, s.ArmedPeer())
+	}
+
+	s.send(Message{Type: TypeAbort, ID: "m1"})
+	if _, ok := s.CurrentMigration(); ok || s.ArmedPeer() != nil || !udpAddrEqual(s.pc.getPeer(), a) {
+		t.Fatalf("after abort: armed=%v real=%v", s.ArmedPeer(), s.pc.getPeer())
+	}
+	if h := s.Migrations(); len(h) != 1 || h[0].State != MigrationAborted || h[0].Reason != "abort" {
+		t.Fatalf("history %+v", h)
+	}
+	if s.Disarm() {
+		t.Fatal("local disarm after abort reported a change")
+	}
+}
+
+// 双路径窗口：cutover 之前同时接收候选对端的早到包，cutover 之后窗口内同时接收旧对端的在途包，
+// 都以 fakePeer 交给 quic-go 并分别计数；窗口结束后旧对端的包被丢弃。
+func TestDualPathWindow(t *testing.T) {
+	listen := func() *net.UDPConn {
+		c, err := net.ListenUDP("udp4", loopback(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	a, b, other := listen(), listen(), listen()
+	fake := loopback(7)
+	pc, err := NewSwappableUDPConn("udp4", loopback(0), a.LocalAddr().(*net.UDPAddr), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetProbing(-1, 0)
+	const window = 100 * time.Millisecond
+	pc.SetDualPathWindow(window)
+	to := pc.LocalAddr().(*net.UDPAddr)
+
+	// expect 从 src 发出 payload，检查 pc 是否把它交给 quic-go。
+	expect := func(src *net.UDPConn, payload string, delivered bool) {
+		t.Helper()
+		pkt := append([]byte{0x40}, payload...)
+		if _, err := src.WriteToUDP(pkt, to); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		_ = pc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, from, err := pc.ReadFrom(buf)
+		var ne net.Error
+		switch {
+		case !delivered && errors.As(err, &ne) && ne.Timeout():
+		case !delivered:
+			t.Fatalf("%s: delivered %q err=%v", payload, buf[:n], err)
+		case err != nil:
+			t.Fatalf("%s: %v", payload, err)
+		case string(buf[1:n]) != payload || from != fake:
+			t.Fatalf("got %q from %v, want %q from %v", buf[1:n], from, payload, fake)
+		}
+	}
+
+	pc.ArmPeer(b.LocalAddr().(*net.UDPAddr))
+	expect(a, "a-before", true)
+	expect(b, "b-early", true)
+	expect(other, "noise", false)
+
+	if !pc.CutoverToArmedPeer() {
+		t.Fatal("cutover did not happen")
+	}
+	expect(a, "a-inflight", true)
+	expect(b, "b-after", true)
+	if !closed(pc.PeerAlive()) {
+		t.Fatal("new peer not marked alive")
+	}
+
+	time.Sleep(window)
+	expect(a, "a-late", false)
+	expect(b, "b-late", true)
+
+	want := PathStats{Current: 3, Armed: 1, Old: 1, Dropped: 2}
+	if got := pc.PathStats(); got != want {
+		t.Fatalf("stats %+v, want %+v", got, want)
+	}
+}
+
+// 窗口关闭时只接收当前 realPeer：cutover 前候选对端、cutover 后旧对端的包都被丢弃。
+func TestDualPathWindowDisabled(t *testing.T) {
+	a, b := loopback(9), loopback(10)
+	pc := newTestConn(t, a)
+	pc.SetDualPathWindow(0)
+	pkt := []byte{0x40, 1, 2, 3}
+
+	pc.ArmPeer(b)
+	if pc.accept(pkt, b) {
+		t.Fatal("accepted an early datagram from the armed peer")
+	}
+	pc.CutoverToArmedPeer()
+	if pc.accept(pkt, a) {
+		t.Fatal("accepted an in-flight datagram from the old peer")
+	}
+	if !pc.accept(pkt, b) {
+		t.Fatal("dropped a datagram from the current peer")
+	}
+	if got := pc.PathStats(); got != (PathStats{Current: 1, Dropped: 2}) {
+		t.Fatalf("stats %+v", got)
+	}
+}
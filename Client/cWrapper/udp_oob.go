@@ -0,0 +1,256 @@
+package wrapper
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// SwappableUDPConn 的 OOB 能力（quic-go 的 OOBCapablePacketConn + batchConn）。
+//
+// 只实现 net.PacketConn 时，quic-go 会退回非 OOB 路径：没有 ECN、GSO、packet-info，
+// 也无法调大 socket 缓冲区（启动时打印 buffer-size 警告）。这里补齐：
+//   - SyscallConn / SetReadBuffer / SetWriteBuffer：作用于当前代 socket，并被记录下来，
+//     RebindLocal 时在新 socket 上重放（socketSetup）。
+//   - ReadMsgUDP / ReadBatch：与 ReadFrom 相同的探测拦截、来源过滤与 fakePeer 伪装。
+//   - WriteMsgUDP：与 WriteTo 相同，忽略 quic-go 给的地址，总是发往 realPeer。
+//   - 以上读写都带“generation 变化即重试”语义。
+//
+// 注意：必须自己实现 ReadBatch。否则 quic-go 会用 ipv4/ipv6.NewPacketConn 直接读底层 fd，
+// 绕过来源过滤与地址伪装，QUIC 会直接看到真实对端地址。
+
+var _ interface {
+	net.PacketConn
+	SyscallConn() (syscall.RawConn, error)
+	SetReadBuffer(int) error
+	ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+	WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+} = (*SwappableUDPConn)(nil)
+
+// batchReader 是 socket 的批量读视图（ipv4/ipv6.PacketConn；两者的 Message 是同一类型）。
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// newBatchReader 按 socket 的地址族选择 ipv4 或 ipv6 的 PacketConn（与 quic-go 自己的选择一致）：
+// IPv6 socket（包括双栈的 [::] 通配绑定）要用 ipv6.NewPacketConn。
+func newBatchReader(c *net.UDPConn) batchReader {
+	if a, ok := c.LocalAddr().(*net.UDPAddr); ok && a.IP.To4() == nil {
+		return ipv6.NewPacketConn(c)
+	}
+	return ipv4.NewPacketConn(c)
+}
+
+// socketSetup 记录 quic-go 对 socket 做过的设置，rebind 后在新 socket 上重放。
+type socketSetup struct {
+	mu       sync.Mutex
+	readBuf  int
+	writeBuf int
+	controls []func(fd uintptr)
+}
+
+// maxSocketControls 限制记录的 Control 回调数量；quic-go 只在建连时调用少数几次。
+const maxSocketControls = 32
+
+func (s *socketSetup) recordControl(f func(fd uintptr)) {
+	s.mu.Lock()
+	if len(s.controls) < maxSocketControls {
+		s.controls = append(s.controls, f)
+	}
+	s.mu.Unlock()
+}
+
+// apply 把记录的设置重放到 c 上（尽力而为：新 socket 不支持某个选项时忽略）。
+func (s *socketSetup) apply(c *net.UDPConn) {
+	s.mu.Lock()
+	readBuf, writeBuf := s.readBuf, s.writeBuf
+	controls := append([]func(fd uintptr){}, s.controls...)
+	s.mu.Unlock()
+
+	if readBuf > 0 {
+		_ = c.SetReadBuffer(readBuf)
+	}
+	if writeBuf > 0 {
+		_ = c.SetWriteBuffer(writeBuf)
+	}
+	if len(controls) == 0 {
+		return
+	}
+	if rc, err := c.SyscallConn(); err == nil {
+		_ = rc.Control(func(fd uintptr) {
+			for _, f := range controls {
+				f(fd)
+			}
+		})
+	}
+}
+
+// rebindableRawConn 是 SyscallConn 返回的 syscall.RawConn：每次调用都作用于当前代的 socket，
+// Control 还会被记录（先记录再执行，避免与并发的 RebindLocal 错过）。
+type rebindableRawConn struct {
+	s *SwappableUDPConn
+}
+
+func (r rebindableRawConn) raw() (syscall.RawConn, error) {
+	c, _, _ := r.s.current()
+	if c == nil {
+		return nil, errors.New("udp conn is nil")
+	}
+	return c.SyscallConn()
+}
+
+func (r rebindableRawConn) Control(f func(fd uintptr)) error {
+	r.s.setup.recordControl(f)
+	rc, err := r.raw()
+	if err != nil {
+		return err
+	}
+	return rc.Control(f)
+}
+
+func (r rebindableRawConn) Read(f func(fd uintptr) bool) error {
+	rc, err := r.raw()
+	if err != nil {
+		return err
+	}
+	return rc.Read(f)
+}
+
+func (r rebindableRawConn) Write(f func(fd uintptr) bool) error {
+	rc, err := r.raw()
+	if err != nil {
+		return err
+	}
+	return rc.Write(f)
+}
+
+func (s *SwappableUDPConn) SyscallConn() (syscall.RawConn, error) {
+	if c, _, _ := s.current(); c == nil {
+		return nil, errors.New("udp conn is nil")
+	}
+	return rebindableRawConn{s: s}, nil
+}
+
+func (s *SwappableUDPConn) SetReadBuffer(bytes int) error {
+	s.setup.mu.Lock()
+	s.setup.readBuf = bytes
+	s.setup.mu.Unlock()
+	c, _, _ := s.current()
+	if c == nil {
+		return errors.New("udp conn is nil")
+	}
+	return c.SetReadBuffer(bytes)
+}
+
+func (s *SwappableUDPConn) SetWriteBuffer(bytes int) error {
+	s.setup.mu.Lock()
+	s.setup.writeBuf = bytes
+	s.setup.mu.Unlock()
+	c, _, _ := s.current()
+	if c == nil {
+		return errors.New("udp conn is nil")
+	}
+	return c.SetWriteBuffer(bytes)
+}
+
+func (s *SwappableUDPConn) ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error) {
+	for {
+		c, _, g := s.current()
+		if c == nil {
+			return 0, 0, 0, nil, errors.New("udp conn is nil")
+		}
+		var from *net.UDPAddr
+		n, oobn, flags, from, err = c.ReadMsgUDP(b, oob)
+		if err == nil {
+			if !s.accept(b[:n], from) {
+				continue
+			}
+			if fake, ok := s.presentAddr(from).(*net.UDPAddr); ok {
+				from = fake
+			}
+			return n, oobn, flags, from, nil
+		}
+		if s.swapped(c, g, err) {
+			continue
+		}
+		return 0, 0, 0, nil, err
+	}
+}
+
+func (s *SwappableUDPConn) WriteMsgUDP(b, oob []byte, _ *net.UDPAddr) (n, oobn int, err error) {
+	peer := s.getPeer()
+	if peer == nil {
+		return 0, 0, errors.New("real peer is nil")
+	}
+	for {
+		c, _, g := s.current()
+		if c == nil {
+			return 0, 0, errors.New("udp conn is nil")
+		}
+		n, oobn, err = c.WriteMsgUDP(b, oob, peer)
+		if err == nil {
+			return n, oobn, nil
+		}
+		if s.swapped(c, g, err) {
+			continue
+		}
+		// RebindLocal 换了网卡/地址后，quic-go 仍按旧路径的 packet-info 指定源地址/网卡，
+		// 发送必然失败：去掉 packet-info 让内核自己选源地址。
+		if stripped, ok := stripPacketInfo(oob); ok {
+			oob = stripped
+			continue
+		}
+		return n, oobn, err
+	}
+}
+
+func (s *SwappableUDPConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	for {
+		c, bc, g := s.current()
+		if c == nil {
+			return 0, errors.New("udp conn is nil")
+		}
+		n, err := bc.ReadBatch(ms, flags)
+		if err == nil {
+			// 整批都被过滤时继续读（quic-go 不接受 n==0 且无错误）。
+			if n = s.filterBatch(ms[:n]); n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if s.swapped(c, g, err) {
+			continue
+		}
+		return 0, err
+	}
+}
+
+func (s *SwappableUDPConn) filterBatch(ms []ipv4.Message) int {
+	k := 0
+	for i := range ms {
+		from, _ := ms[i].Addr.(*net.UDPAddr)
+		if !s.accept(ms[i].Buffers[0][:ms[i].N], from) {
+			continue
+		}
+		if k != i {
+			moveMessage(&ms[k], &ms[i])
+		}
+		ms[k].Addr = s.presentAddr(from)
+		k++
+	}
+	return k
+}
+
+// moveMessage 把 src 的内容拷贝到 dst。quic-go 的 packet buffer 与 ms 下标一一对应，
+// 所以只能拷贝数据，不能交换 Buffers。
+func moveMessage(dst, src *ipv4.Message) {
+	dst.N = copy(dst.Buffers[0], src.Buffers[0][:src.N])
+	dst.NN = copy(dst.OOB, src.OOB[:src.NN])
+	dst.Flags = src.Flags
+	dst.Addr = src.Addr
+}
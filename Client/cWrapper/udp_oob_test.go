@@ -0,0 +1,56 @@
+package wrapper
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ReadBatch 在 IPv4 与 IPv6 socket 上都要能读出数据报，并按 fakePeer 伪装来源。
+func TestReadBatch(t *testing.T) {
+	for _, tc := range []struct {
+		network string
+		ip      net.IP
+		v6      bool
+	}{
+		{"udp4", net.IPv4(127, 0, 0, 1), false},
+		{"udp6", net.IPv6loopback, true},
+	} {
+		t.Run(tc.network, func(t *testing.T) {
+			peer, err := net.ListenUDP(tc.network, &net.UDPAddr{IP: tc.ip})
+			if err != nil {
+				t.Skipf("%s loopback: %v", tc.network, err)
+			}
+			defer peer.Close()
+			peerAddr := peer.LocalAddr().(*net.UDPAddr)
+			fake := &net.UDPAddr{IP: tc.ip, Port: 1}
+			pc, err := NewSwappableUDPConn(tc.network, &net.UDPAddr{IP: tc.ip}, peerAddr, fake)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			if _, v6 := pc.batch.(*ipv6.PacketConn); v6 != tc.v6 {
+				t.Fatalf("batch reader %T for %s", pc.batch, tc.network)
+			}
+
+			if _, err := peer.WriteToUDP([]byte("hello"), pc.LocalAddr().(*net.UDPAddr)); err != nil {
+				t.Fatal(err)
+			}
+			_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			ms := []ipv4.Message{{Buffers: [][]byte{make([]byte, 1500)}, OOB: make([]byte, 128)}}
+			n, err := pc.ReadBatch(ms, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 || string(ms[0].Buffers[0][:ms[0].N]) != "hello" {
+				t.Fatalf("read %d messages: %q", n, ms[0].Buffers[0][:ms[0].N])
+			}
+			if from, ok := ms[0].Addr.(*net.UDPAddr); !ok || !udpAddrEqual(from, fake) {
+				t.Fatalf("from %v, want %v", ms[0].Addr, fake)
+			}
+		})
+	}
+}
@@ -26,5 +26,6 @@ func handleEcho(st io.ReadWriteCloser) {
 		if err := w.Flush(); err != nil {
 			return
 		}
+		echoed.Add(1)
 	}
 }
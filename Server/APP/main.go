@@ -13,6 +13,8 @@ import (
 // QUIC、控制流（migrate/ack）、信号处理、可迁移 UDP 都由 Server/sWrapper 负责。
 func main() {
 	opts := wrapper.DefaultServerOptions()
+	opts.SaveState = saveState
+	opts.LoadState = loadState
 	if err := wrapper.Serve(context.Background(), opts, handleEcho); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
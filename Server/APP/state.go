@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// echoed 是 demo 的“业务状态”：累计回显的行数。
+// 模拟检查点（Control --checkpointer=sim）经 SaveState/LoadState 在重启之间带走它。
+var echoed atomic.Int64
+
+type appState struct {
+	EchoedLines int64 `json:"echoed_lines"`
+}
+
+func saveState() ([]byte, error) {
+	return json.Marshal(appState{EchoedLines: echoed.Load()})
+}
+
+func loadState(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	var st appState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+	echoed.Store(st.EchoedLines)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// agentCmd 运行目标主机上的 Control agent：
+//   - 监听 TCP（--listen），接收源端 Control 推来的 CRIU 镜像（见 transfer.go）；只接受携带 --agent-token 的连接。
+//   - 收到 restore 请求后，在本机 B(壳) 中 nsenter restore，并让恢复进程 rebind。
+//   - 源端使用 --page-server 时，按请求在 B 中启动 criu page-server 接收内存页（见 pageserver.go）。
+//
+// 同一时刻只处理一次迁移。B 可以预先由 `control up` 创建，也可以用 --start-shell 让 agent 自己启动。
+func agentCmd(args []string) {
+	cfg := parseCommonFlags("agent", args)
+	if cfg.listenAddr == "" {
+		die("agent: --listen is required")
+	}
+	if cfg.agentToken == "" {
+		die("agent: --agent-token (or WRAPPER_AGENT_TOKEN) is required")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", r)
+			os.Exit(2)
+		}
+	}()
+
+	if cfg.agentStartShell {
+		prepareImgDir(cfg)
+		startB(cfg)
+	}
+
+	ln, err := net.Listen("tcp", cfg.listenAddr)
+	if err != nil {
+		dief("agent: listen %s: %v", cfg.listenAddr, err)
+	}
+	cfg.logf("[控制端] agent 监听 %s：B=%s imgDir=%s\n", ln.Addr(), cfg.bName, cfg.imgDir)
+
+	var mu sync.Mutex
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			dief("agent: accept: %v", err)
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			mu.Lock()
+			defer mu.Unlock()
+
+			cfg.logf("[控制端] agent 接收迁移 from=%s\n", conn.RemoteAddr())
+			pages := &pageServerHost{cfg: cfg}
+			defer pages.stop()
+			err := receiveImages(conn, cfg.imgDir, cfg.agentToken,
+				func() error { return clearDir(cfg.imgDir) },
+				func(dir, parent string, port int) error {
+					cfg.logf("[控制端] agent 启动 page-server：dir=%s port=%d\n", dir, port)
+					return pages.start(dir, parent, "", port)
+				},
+				func(id string) (int, error) {
+					cfg.logf("[控制端] 步骤：恢复：注入到B（id=%s）\n", id)
+					if err := pages.wait(pageServerWait); err != nil {
+						return 0, err
+					}
+					if err := restoreIntoB(cfg); err != nil {
+						return 0, err
+					}
+					return cfg.restoredPID, nil
+				})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[控制端] agent 迁移失败：%v\n", err)
+				return
+			}
+			cfg.logf("[控制端] agent 迁移完成：restoredPID=%d\n", cfg.restoredPID)
+		}(conn)
+	}
+}
+
+// clearDir 清空目录内容但保留目录本身（B 以 bind mount 引用该目录，不能 rm -rf 后重建）。
+func clearDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// control bench：在 A/B 之间来回迁移 N 次，汇总服务中断与各阶段耗时的分布（min/median/p95/p99/max）。
+//
+// 每次迁移成功后原源容器被重建为壳（recycleSource），下一次迁回去；同一个 client 全程保持连接。
+// 服务中断取 client 相邻两次 echo 的最大间隔（见 clientObserver.maxEchoGap），
+// 因此 --ping-interval 决定了测量精度。每次迁移的完整报告照常写到 --report-dir。
+//
+// 失败的迁移计入 failed、不参与统计；回滚成功时继续下一次，否则中止。
+// --pool-size 时每次迁移从壳池取目标（shellpool.go），原 A 在后台回收进池，不再等待壳重建。
+
+// benchRun 是一次迁移的结果（JSON 输出的 runs）。
+type benchRun struct {
+	Iteration  int    `json:"iteration"`
+	ID         string `json:"id"`
+	State      string `json:"state"`
+	Error      string `json:"error,omitempty"`
+	DowntimeMS *int64 `json:"downtime_ms,omitempty"`
+	TotalMS    int64  `json:"total_ms"`
+	Report     string `json:"report,omitempty"`
+}
+
+// benchMetric 是一个指标的分布（毫秒）。
+type benchMetric struct {
+	Metric string  `json:"metric"`
+	N      int     `json:"n"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+}
+
+type benchSummary struct {
+	Iterations   int           `json:"iterations"`
+	Failed       int           `json:"failed"`
+	Runtime      string        `json:"runtime"`
+	Checkpointer string        `json:"checkpointer"`
+	Metrics      []benchMetric `json:"metrics"`
+	Runs         []benchRun    `json:"runs"`
+}
+
+// benchSamples 按指标收集样本，保持指标首次出现的顺序。
+type benchSamples struct {
+	order []string
+	ms    map[string][]float64
+}
+
+func (s *benchSamples) add(metric string, d time.Duration) {
+	if s.ms == nil {
+		s.ms = map[string][]float64{}
+	}
+	if _, ok := s.ms[metric]; !ok {
+		s.order = append(s.order, metric)
+	}
+	s.ms[metric] = append(s.ms[metric], float64(d.Microseconds())/1000)
+}
+
+func (s *benchSamples) metrics() []benchMetric {
+	out := make([]benchMetric, 0, len(s.order))
+	for _, name := range s.order {
+		v := append([]float64(nil), s.ms[name]...)
+		sort.Float64s(v)
+		out = append(out, benchMetric{
+			Metric: name, N: len(v), Min: v[0], Max: v[len(v)-1],
+			Median: percentile(v, 50), P95: percentile(v, 95), P99: percentile(v, 99),
+		})
+	}
+	return out
+}
+
+// percentile 取已排序样本的 p 分位（nearest-rank）。
+func percentile(sorted []float64, p float64) float64 {
+	k := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if k < 0 {
+		k = 0
+	}
+	return sorted[k]
+}
+
+func benchCmd(args []string) {
+	cfg := parseCommonFlags("bench", args)
+	if cfg.benchIterations <= 0 {
+		dief("bench: --iterations must be > 0")
+	}
+	if cfg.benchFormat != "csv" && cfg.benchFormat != "json" {
+		dief("bench: unknown --format %q (csv | json)", cfg.benchFormat)
+	}
+
+	var clientProc *exec.Cmd
+	var clientObs *clientObserver
+	stopClient := func() {
+		if clientProc != nil && clientProc.Process != nil {
+			_ = clientProc.Process.Signal(os.Interrupt)
+		}
+		if clientObs != nil {
+			clientObs.stop()
+		}
+	}
+	// 回收后 aName/bName 会交换，清理时按当时的名字删除。
+	clean := func() {
+		cfg.pool.close()
+		cleanContainers(cfg.rt, cfg.aName, cfg.bName)
+	}
+	if !cfg.noCleanup {
+		defer clean()
+	}
+	defer stopClient()
+
+	defer func() {
+		if r := recover(); r != nil {
+			stopClient()
+			if !cfg.noCleanup {
+				clean()
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", r)
+			os.Exit(2)
+		}
+	}()
+
+	buildAndImage(cfg)
+	prepareImgDir(cfg)
+	startA(cfg)
+	if cfg.poolSize > 0 {
+		step(cfg, "启动：壳池", func() error {
+			p, err := newShellPool(cfg, cfg.srcPort)
+			if err != nil {
+				return err
+			}
+			cfg.pool = p
+			cfg.bName, cfg.dstPort = "", 0
+			return nil
+		})
+	} else {
+		startB(cfg)
+	}
+
+	step(cfg, "启动：客户端", func() error {
+		_ = os.Remove(cfg.clientLog)
+		clientProc = exec.Command(filepath.Join(cfg.workDir, "Client", "client_bin"),
+			"-interval", cfg.benchPingInterval.String(), "-stay-connected")
+		clientProc.Env = append(os.Environ(), fmt.Sprintf("TARGET_ADDR=127.0.0.1:%d", cfg.srcPort))
+
+		obs, err := startClientObserver(clientProc, cfg.clientLog, cfg.logOut())
+		if err != nil {
+			return err
+		}
+		clientObs = obs
+
+		select {
+		case <-clientObs.connected:
+			return nil
+		case <-time.After(8 * time.Second):
+			fmt.Fprintln(os.Stderr, "[控制端] 警告：客户端连接超时")
+			return nil
+		}
+	})
+
+	sum := benchSummary{Iterations: cfg.benchIterations, Runtime: cfg.rt.Name(), Checkpointer: cfg.ckpt.Name()}
+	var samples benchSamples
+	rec := &stepRecorder{out: cfg.out}
+	for i := 1; i <= cfg.benchIterations; i++ {
+		claimed, err := claimShell(cfg, rec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[控制端] bench：第 %d 次迁移没有可用的壳，中止：%v\n", i, err)
+			break
+		}
+		cfg.logf("[控制端] bench：第 %d/%d 次迁移 %s(port=%d) → %s(port=%d)\n", i, cfg.benchIterations, cfg.aName, cfg.srcPort, cfg.bName, cfg.dstPort)
+		clientObs.rearm()
+		err = doMigrate(cfg, "", clientObs, rec)
+
+		dt := time.Duration(-1)
+		if err == nil {
+			// 中断以恢复后的 echo 为界：等到 doMigrate 结束之后的第一次 echo，再取最大间隔。
+			if clientObs.waitEchoAfter(time.Now(), 5*time.Second) {
+				dt = clientObs.maxEchoGap()
+			} else {
+				fmt.Fprintln(os.Stderr, "[控制端] 警告：迁移后未收到 echo，本次无服务中断数据")
+			}
+		}
+		cfg.report.downtime(dt)
+
+		rep := cfg.report.snapshot()
+		run := benchRun{Iteration: i, ID: rep.ID, State: rep.State, Error: rep.Error, DowntimeMS: rep.DowntimeMS, TotalMS: rep.TotalMS}
+		if path, serr := saveReport(cfg.reportDir, rep); serr != nil {
+			fmt.Fprintf(os.Stderr, "[控制端] 警告：写迁移报告失败：%v\n", serr)
+		} else {
+			run.Report = path
+		}
+		sum.Runs = append(sum.Runs, run)
+
+		if err != nil {
+			sum.Failed++
+			if !recoverBenchSource(cfg, rec, err, claimed) {
+				fmt.Fprintf(os.Stderr, "[控制端] bench：第 %d 次迁移失败且未能回滚，中止：%v\n", i, err)
+				break
+			}
+		} else {
+			if dt >= 0 {
+				samples.add("downtime", dt)
+			}
+			samples.add("total", rep.End.Sub(rep.Start))
+			for _, p := range rep.Phases {
+				samples.add(p.Name, p.End.Sub(p.Start))
+			}
+			if rerr := recycleSource(cfg, rec); rerr != nil {
+				fmt.Fprintf(os.Stderr, "[控制端] bench：回收源容器失败，中止：%v\n", rerr)
+				break
+			}
+		}
+		time.Sleep(cfg.benchSettle)
+	}
+
+	sum.Metrics = samples.metrics()
+	writeBenchSummary(cfg, sum)
+	if sum.Failed > 0 {
+		fmt.Fprintf(os.Stderr, "[控制端] bench：%d/%d 次迁移失败\n", sum.Failed, len(sum.Runs))
+	}
+}
+
+// recoverBenchSource 处理失败的一次迁移：回滚成功（服务仍在 A）时重建 B 壳，返回能否继续。
+// 目标取自壳池时直接丢弃它，下一次迁移再从池中取。
+func recoverBenchSource(cfg *controlConfig, rec *stepRecorder, err error, claimed bool) bool {
+	if claimed {
+		releaseShell(cfg)
+	}
+	var re *rollbackError
+	if !errors.As(err, &re) || !re.Recovered {
+		return false
+	}
+	if claimed {
+		return true
+	}
+	// restore 失败时 B 里可能残留半恢复的进程，重建一个干净的壳。
+	return rec.run("回收：重建 B(壳)", func() error {
+		if err := cfg.rt.Remove(cfg.bName); err != nil {
+			return err
+		}
+		return tryStep(func() { startB(cfg) })
+	}) == nil
+}
+
+// writeBenchSummary 把汇总写到 stdout（--json 时日志在 stderr，stdout 只有汇总与报告）与 --out 指定的文件。
+func writeBenchSummary(cfg *controlConfig, sum benchSummary) {
+	var out io.Writer = os.Stdout
+	if cfg.benchOut != "" {
+		f, err := os.Create(cfg.benchOut)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[控制端] 警告：写 bench 结果失败：%v\n", err)
+		} else {
+			defer f.Close()
+			out = io.MultiWriter(out, f)
+		}
+	}
+
+	if cfg.benchFormat == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(sum)
+		return
+	}
+	w := csv.NewWriter(out)
+	_ = w.Write([]string{"metric", "n", "min", "median", "p95", "p99", "max"})
+	for _, m := range sum.Metrics {
+		_ = w.Write([]string{m.Metric, strconv.Itoa(m.N), fmtMS(m.Min), fmtMS(m.Median), fmtMS(m.P95), fmtMS(m.P99), fmtMS(m.Max)})
+	}
+	w.Flush()
+}
+
+func fmtMS(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
@@ -21,12 +21,12 @@ func mustPickGoBin() string {
 	return "go"
 }
 
-func goBuildStatic(verbose bool, dir string, goBin string, out string, pkg string) error {
+func goBuildStatic(verbose bool, log io.Writer, dir string, goBin string, out string, pkg string) error {
 	cmd := exec.Command(goBin, "build", "-o", out, pkg)
 	cmd.Dir = dir
 	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux")
 	if verbose {
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = log
 		cmd.Stderr = os.Stderr
 		return cmd.Run()
 	}
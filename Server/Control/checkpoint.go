@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Checkpointer 抽象“把源进程存下来、再在壳里恢复”的三步操作。
+//
+// doMigrate 只关心每一步的耗时与镜像大小，不关心底层是 CRIU 还是模拟实现：
+//   - criuCheckpointer：sudo criu pre-dump/dump，nsenter 到壳里 criu restore（生产路径）。
+//   - simCheckpointer：经控制端点 save-state 序列化应用状态，restore 时在壳里重启 server（见 checkpoint_sim.go）。
+type Checkpointer interface {
+	Name() string
+	// PreDump 在进程继续运行的情况下写一轮增量镜像到 req.Dir。
+	PreDump(req checkpointReq) (checkpointResult, error)
+	// Dump 写最终镜像到 req.Dir（进程由调用方随后停止）。
+	Dump(req checkpointReq) (checkpointResult, error)
+	// Restore 在 req.Shell 中恢复 req.Dir 的镜像，返回恢复出的 PID。
+	Restore(req checkpointReq) (checkpointResult, error)
+	// DumpDone 报告 dir 中是否有 since 之后完成的 Dump 镜像（resume 据此判断 A 是否已被 dump 停止）。
+	DumpDone(dir string, since time.Time) bool
+}
+
+type checkpointReq struct {
+	// PID 是 pre-dump/dump 的源进程。
+	PID int
+	// Dir 是本次镜像目录；WorkDir 是日志目录（imgDir）。
+	Dir     string
+	WorkDir string
+	// Parent 是上一轮镜像目录（相对 Dir）；空表示全量。
+	Parent string
+	// Round 是 pre-dump 轮次（日志文件名用）。
+	Round int
+	// LazyPort 是 post-copy 源端 page-server 的 TCP 端口（见 lazy.go）。
+	LazyPort int
+	// PageServer 非空时 pre-dump/dump 把内存页写到该 page-server（host:port，见 pageserver.go）。
+	PageServer string
+
+	// restore：目标壳及其 init PID / 网络命名空间。
+	Shell    containerSpec
+	ShellPID int
+	NetNS    string
+}
+
+type checkpointResult struct {
+	Dir   string
+	Took  time.Duration
+	Files int
+	Bytes int64
+
+	// PID 是 restore 出的进程；Rebind 表示它沿用了旧 socket，需要 rebind。
+	PID    int
+	Rebind bool
+
+	// Log 是 CRIU 日志文件（写进迁移报告，便于定位失败）。
+	Log string
+}
+
+func newCheckpointer(kind string, cfg *controlConfig) (Checkpointer, error) {
+	switch kind {
+	case "", "criu":
+		return criuCheckpointer{criuHost: cfg.criuHost, criuInShell: cfg.criuInB}, nil
+	case "sim":
+		return simCheckpointer{rt: cfg.rt, ctlSocket: cfg.ctlSocket, ctlTimeout: cfg.ctlTimeout}, nil
+	default:
+		return nil, fmt.Errorf("unknown checkpointer: %q", kind)
+	}
+}
+
+// criuCheckpointer 直接 exec CRIU。
+type criuCheckpointer struct {
+	criuHost    string
+	criuInShell string
+}
+
+func (criuCheckpointer) Name() string { return "criu" }
+
+func (c criuCheckpointer) PreDump(req checkpointReq) (checkpointResult, error) {
+	// 这里使用的 CRIU pre-dump 关键参数：
+	//   - --leave-running：不停止进程（即“预拷贝”阶段）。
+	//   - --track-mem：启用脏页跟踪，为增量/多轮 pre-dump 做基础。
+	//   - --prev-images-dir（从第 2 轮开始）：引用上一轮镜像目录，形成增量链。
+	//   - --empty-ns net + --manage-cgroups=ignore：容器 PoC 的务实配置。
+	args := []string{c.criuHost, "pre-dump", "-t", strconv.Itoa(req.PID), "-D", req.Dir, "-W", req.WorkDir,
+		"--shell-job", "--leave-running", "--empty-ns", "net", "--manage-cgroups=ignore", "--track-mem",
+	}
+	if req.Parent != "" {
+		// NOTE: --prev-images-dir is relative to -D. Our image dirs are siblings under cfg.imgDir.
+		args = append(args, "--prev-images-dir", req.Parent)
+	}
+	args = append(args, pageServerArgs(req.PageServer)...)
+	logName := fmt.Sprintf("pre-dump-%d.log", req.Round)
+	args = append(args, append(buildSkipMntArgs(req.WorkDir), "-o", logName, "-v4")...)
+	res, err := timedImages(req.Dir, func() error { return runQuiet("sudo", args...) })
+	res.Log = filepath.Join(req.WorkDir, logName)
+	return res, err
+}
+
+func (c criuCheckpointer) Dump(req checkpointReq) (checkpointResult, error) {
+	args := []string{c.criuHost, "dump", "-t", strconv.Itoa(req.PID), "-D", req.Dir, "-W", req.WorkDir,
+		"--shell-job", "--empty-ns", "net", "--manage-cgroups=ignore",
+	}
+	if req.Parent != "" {
+		// --prev-images-dir is relative to -D (cfg.imgDir).
+		args = append(args, "--prev-images-dir", req.Parent)
+		args = append(args, "--track-mem")
+	}
+	args = append(args, pageServerArgs(req.PageServer)...)
+	args = append(args, append(buildSkipMntArgs(req.WorkDir), "-o", "dump.log", "-v4")...)
+	res, err := timedImages(req.Dir, func() error { return runQuiet("sudo", args...) })
+	res.Log = filepath.Join(req.WorkDir, "dump.log")
+	return res, err
+}
+
+// DumpDone 以 dump.log 中 CRIU 的完成标记为准：镜像文件在 dump 过程中逐个写出，存在不代表完整。
+func (c criuCheckpointer) DumpDone(dir string, since time.Time) bool {
+	log := filepath.Join(dir, "dump.log")
+	if st, err := os.Stat(log); err != nil || st.ModTime().Before(since) {
+		return false
+	}
+	return runQuiet("sudo", "grep", "-q", "Dumping finished successfully", log) == nil
+}
+
+func (c criuCheckpointer) Restore(req checkpointReq) (checkpointResult, error) {
+	return c.restore(req)
+}
+
+// restore 在壳中执行 criu restore；extra 为附加参数（post-copy 时为 --lazy-pages）。
+func (c criuCheckpointer) restore(req checkpointReq, extra ...string) (checkpointResult, error) {
+	pidFile := filepath.Join(req.Dir, "restored.pid")
+	restoreLog := filepath.Join(req.Dir, "restore.log")
+
+	restoreArgs := []string{
+		"restore", "-D", req.Dir, "-W", req.Dir,
+		"--shell-job", "--restore-detached", "--mntns-compat-mode",
+		"--root", "/", "--manage-cgroups=ignore",
+		"--pidfile", pidFile,
+		"-o", filepath.Base(restoreLog), "-v4",
+	}
+	if req.NetNS != "" {
+		restoreArgs = append(restoreArgs, "-J", "net:"+req.NetNS)
+	}
+	restoreArgs = append(restoreArgs, extra...)
+
+	nsenterArgs := []string{"nsenter", "-t", strconv.Itoa(req.ShellPID), "-m", "-n", "--", c.criuInShell}
+	nsenterArgs = append(nsenterArgs, restoreArgs...)
+
+	cmd := exec.Command("sudo", nsenterArgs...)
+	cmd.Dir = req.Dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		reportExecFailure(start, stdout.Bytes(), stderr.Bytes(), err)
+		return checkpointResult{Dir: req.Dir, Took: time.Since(start), Log: restoreLog}, err
+	}
+	res := checkpointResult{Dir: req.Dir, Took: time.Since(start), Rebind: true, Log: restoreLog}
+
+	rpid, err := readPIDFile(pidFile)
+	if err != nil {
+		return res, err
+	}
+	if err := sudoKill0(rpid); err != nil {
+		return res, fmt.Errorf("restored pid not alive: pid=%d err=%w", rpid, err)
+	}
+	res.PID = rpid
+	return res, nil
+}
+
+// timedImages 执行 fn 并统计 dir 下（不含子目录）的镜像文件数与字节数。
+// 只数顶层：pre-dump 子目录各自统计，final dump 不重复计入。
+func timedImages(dir string, fn func() error) (checkpointResult, error) {
+	start := time.Now()
+	err := fn()
+	res := checkpointResult{Dir: dir, Took: time.Since(start)}
+	if err != nil {
+		return res, err
+	}
+	res.Files, res.Bytes = imageSize(dir)
+	return res, nil
+}
+
+func imageSize(dir string) (int, int64) {
+	var files int
+	var bytes int64
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files++
+		bytes += fi.Size()
+	}
+	return files, bytes
+}
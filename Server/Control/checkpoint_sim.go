@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// simCheckpointer 在没有 CRIU 的机器上模拟检查点：
+//   - PreDump/Dump：经控制端点 save-state，让应用把业务状态写到 <Dir>/app.state；
+//   - Restore：在壳里重新启动 server（Runtime.Spawn），RESTORE_STATE_FILE 指向 dump 的状态文件。
+//
+// 进程内存、QUIC 连接不会被带走，client 侧表现为“新 server”；用来验证 Control 的编排/报告/回滚流程。
+type simCheckpointer struct {
+	rt         Runtime
+	ctlSocket  string
+	ctlTimeout time.Duration
+}
+
+const simStateFile = "app.state"
+
+func (simCheckpointer) Name() string { return "sim" }
+
+func (s simCheckpointer) PreDump(req checkpointReq) (checkpointResult, error) {
+	return s.save(req)
+}
+
+func (s simCheckpointer) Dump(req checkpointReq) (checkpointResult, error) {
+	return s.save(req)
+}
+
+func (s simCheckpointer) save(req checkpointReq) (checkpointResult, error) {
+	return timedImages(req.Dir, func() error {
+		_, err := wrapper.CtlCall(ctlSocketPath(req.PID, s.ctlSocket),
+			wrapper.CtlRequest{Cmd: wrapper.CtlSaveState, Path: filepath.Join(req.Dir, simStateFile)}, s.ctlTimeout)
+		return err
+	})
+}
+
+// DumpDone：save-state 返回前状态文件已写完。
+func (s simCheckpointer) DumpDone(dir string, since time.Time) bool {
+	st, err := os.Stat(filepath.Join(dir, simStateFile))
+	return err == nil && !st.ModTime().Before(since)
+}
+
+func (s simCheckpointer) Restore(req checkpointReq) (checkpointResult, error) {
+	start := time.Now()
+	res := checkpointResult{Dir: req.Dir}
+	pid, err := s.rt.Spawn(req.Shell, []string{"RESTORE_STATE_FILE=" + filepath.Join(req.Dir, simStateFile)})
+	if err != nil {
+		return res, err
+	}
+	res.PID = pid
+
+	// 新进程自己监听，不需要 rebind；等控制端点可用即视为 ready。
+	if err := waitCtlReady(s.ctlSocket, pid, s.ctlTimeout); err != nil {
+		return res, fmt.Errorf("restarted server: %w", err)
+	}
+	res.Took = time.Since(start)
+	res.Files, res.Bytes = imageSize(req.Dir)
+	return res, nil
+}
@@ -7,7 +7,9 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,7 @@ var (
 	rePing      = regexp.MustCompile(`\[PING\].*Sending:\s*(.+)$`)
 	reEcho      = regexp.MustCompile(`\[ECHO\].*Echo:\s*(.+)\s*\(rtt=([0-9]+)ms\)$`)
 	reReconnect = regexp.MustCompile(`\[RECONNECT\]`)
+	reDowntime  = regexp.MustCompile(`服务中断 ([0-9]+)ms`)
 )
 
 func simplifyClientLine(line string) (string, bool) {
@@ -38,12 +41,20 @@ type clientObserver struct {
 
 	stopFn func()
 
+	// stdout/stderr 两个解析 goroutine 与调用方并发访问以下状态。
+	mu                   sync.Mutex
 	lastEchoBeforeOutage time.Time
 	firstEchoRecovered   time.Time
 	sawReconnect         bool
+	// echoes 是最近一次 rearm 以来收到 echo 的时间（bench 据此计算最长 echo 间隔）。
+	echoes []time.Time
 }
 
-func startClientObserver(cmd *exec.Cmd, logPath string) (*clientObserver, error) {
+// maxObservedEchoes 限制 echoes 的长度；超过时只保留后一半。
+const maxObservedEchoes = 1 << 16
+
+// startClientObserver 解析 client 的输出：原样写入 logPath，简化后的行打印到 out。
+func startClientObserver(cmd *exec.Cmd, logPath string, out io.Writer) (*clientObserver, error) {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -90,23 +101,22 @@ func startClientObserver(cmd *exec.Cmd, logPath string) (*clientObserver, error)
 				case obs.migrateSeen <- struct{}{}:
 				default:
 				}
+				obs.mu.Lock()
 				obs.sawReconnect = true
+				obs.mu.Unlock()
 			}
 			if reReconnect.MatchString(line) {
+				obs.mu.Lock()
 				obs.sawReconnect = true
+				obs.mu.Unlock()
 			}
 
-			if out, ok := simplifyClientLine(line); ok {
-				fmt.Printf("[客户端] %s\n", out)
-				if strings.HasPrefix(out, "收 ") {
-					if !obs.sawReconnect {
-						obs.lastEchoBeforeOutage = now
-					} else if obs.firstEchoRecovered.IsZero() {
-						obs.firstEchoRecovered = now
-						select {
-						case obs.firstEchoAfterReconnect <- struct{}{}:
-						default:
-						}
+			if msg, ok := simplifyClientLine(line); ok {
+				fmt.Fprintf(out, "[客户端] %s\n", msg)
+				if strings.HasPrefix(msg, "收 ") && obs.echo(now) {
+					select {
+					case obs.firstEchoAfterReconnect <- struct{}{}:
+					default:
 					}
 				}
 			}
@@ -130,9 +140,97 @@ func (o *clientObserver) stop() {
 	}
 }
 
+// echo 记录一次 echo，返回它是否是迁移后的第一次 echo。
+func (o *clientObserver) echo(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.echoes) >= maxObservedEchoes {
+		o.echoes = append(o.echoes[:0], o.echoes[len(o.echoes)/2:]...)
+	}
+	o.echoes = append(o.echoes, now)
+	if !o.sawReconnect {
+		o.lastEchoBeforeOutage = now
+		return false
+	}
+	if o.firstEchoRecovered.IsZero() {
+		o.firstEchoRecovered = now
+		return true
+	}
+	return false
+}
+
 func (o *clientObserver) downtime() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	if o.lastEchoBeforeOutage.IsZero() || o.firstEchoRecovered.IsZero() {
 		return -1
 	}
 	return o.firstEchoRecovered.Sub(o.lastEchoBeforeOutage)
 }
+
+// rearm 为同一个 client 上的下一次迁移重置观测状态（bench 连续迁移时使用）。
+// 保留最后一次 echo，作为下一次迁移“中断前最后一次 echo”的候选。
+func (o *clientObserver) rearm() {
+	o.mu.Lock()
+	o.sawReconnect = false
+	o.firstEchoRecovered = time.Time{}
+	if n := len(o.echoes); n > 0 {
+		o.echoes = append(o.echoes[:0], o.echoes[n-1])
+	}
+	o.mu.Unlock()
+	for _, ch := range []chan struct{}{o.migrateSeen, o.firstEchoAfterReconnect} {
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// waitEchoAfter 等待 t 之后的第一次 echo（确认服务已在新实例上可用）。
+func (o *clientObserver) waitEchoAfter(t time.Time, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		o.mu.Lock()
+		ok := len(o.echoes) > 0 && o.echoes[len(o.echoes)-1].After(t)
+		o.mu.Unlock()
+		if ok {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// maxEchoGap 返回 rearm 以来相邻两次 echo 的最大间隔，即 client 观测到的最长服务中断；不足两次 echo 时返回 -1。
+// 与 downtime 不同，它不受“migrate 之后、dump 之前仍有 echo”的影响。
+func (o *clientObserver) maxEchoGap() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	gap := time.Duration(-1)
+	for i := 1; i < len(o.echoes); i++ {
+		if d := o.echoes[i].Sub(o.echoes[i-1]); d > gap {
+			gap = d
+		}
+	}
+	return gap
+}
+
+// waitClientDowntime 等待 client 输出文件 path 在 offset 之后出现“服务中断 Nms”汇总行（migration.sh 同样依赖它），
+// 返回最后一次出现的值。client 由 run.sh 在前台运行、不归 Control 管，只能这样读取。
+func waitClientDowntime(path string, offset int64, timeout time.Duration) (time.Duration, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if b, err := os.ReadFile(path); err == nil && int64(len(b)) > offset {
+			if m := reDowntime.FindAllSubmatch(b[offset:], -1); len(m) > 0 {
+				ms, _ := strconv.Atoi(string(m[len(m)-1][1]))
+				return time.Duration(ms) * time.Millisecond, true
+			}
+		}
+		if time.Now().After(deadline) {
+			return 0, false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// 与容器内 sWrapper 本地控制端点（Unix socket）的交互。
+//
+// Control 运行在宿主机上（root），通过 /proc/<pid>/root/<path> 进入目标进程的
+// mount namespace 访问 socket，不需要额外挂载或端口映射。
+
+// ctlSocketPath 返回宿主机上访问容器内控制端点的路径。
+// 进程自己的 CONTROL_SOCKET（/proc/<pid>/environ）优先：fake 运行时下每个实例的 socket 不同，
+// 且 restore 出来的进程保留 A 的环境。
+func ctlSocketPath(pid int, inContainer string) string {
+	if p := procEnv(pid, "CONTROL_SOCKET"); p != "" {
+		inContainer = p
+	}
+	return filepath.Join("/proc", strconv.Itoa(pid), "root", inContainer)
+}
+
+func ctlCall(cfg *controlConfig, pid int, req wrapper.CtlRequest) (wrapper.CtlResponse, error) {
+	return wrapper.CtlCall(ctlSocketPath(pid, cfg.ctlSocket), req, cfg.ctlTimeout)
+}
+
+// prepareMigrate 让 pid 内的 server 向所有 client 广播 migrate(addr:port)，并返回 ACK 汇总。
+func prepareMigrate(cfg *controlConfig, pid int, id, addr string, port int) (*wrapper.MigrateResult, error) {
+	resp, err := ctlCall(cfg, pid, wrapper.CtlRequest{Cmd: wrapper.CtlPrepareMigrate, ID: id, Addr: addr, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	res := resp.Migrate
+	if res == nil {
+		return nil, fmt.Errorf("prepare-migrate: empty result")
+	}
+	cfg.logf("[控制端] migrate 已广播 id=%s new=%s:%d acked=%d failed=%d wait=%dms\n", res.ID, addr, port, res.Acked, res.Failed, res.Total.Milliseconds())
+	return res, nil
+}
+
+// waitCtlReady 等待 pid 内的控制端点可用（server 启动需要生成 TLS 证书、建立 listener）。
+func waitCtlReady(ctlSocket string, pid int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := wrapper.CtlCall(ctlSocketPath(pid, ctlSocket), wrapper.CtlRequest{Cmd: wrapper.CtlStatus}, 200*time.Millisecond)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("control socket not ready: pid=%d: %w", pid, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// abortMigrate 让 pid 内的 server 向所有 client 广播 abort(id)（迁移回滚），返回 ACK 汇总。
+func abortMigrate(cfg *controlConfig, pid int, id string) (*wrapper.MigrateResult, error) {
+	resp, err := ctlCall(cfg, pid, wrapper.CtlRequest{Cmd: wrapper.CtlAbort, ID: id})
+	if err != nil {
+		return nil, err
+	}
+	res := resp.Migrate
+	if res == nil {
+		return nil, fmt.Errorf("abort: empty result")
+	}
+	cfg.logf("[控制端] abort 已广播 id=%s acked=%d failed=%d wait=%dms\n", res.ID, res.Acked, res.Failed, res.Total.Milliseconds())
+	return res, nil
+}
+
+// rebindRestored 让 restore 到 shell 中的进程重建 UDP socket。
+// restore 后控制端点可能尚不可用（listener 未被 CRIU 恢复），此时退回 SIGUSR2。
+func rebindRestored(cfg *controlConfig, pid int, shell string) error {
+	phase := "rebind"
+	if shell != cfg.bName {
+		phase = "rollback-rebind"
+	}
+	start := time.Now()
+	resp, err := ctlCall(cfg, pid, wrapper.CtlRequest{Cmd: wrapper.CtlRebind, LAddr: cfg.rt.RestoreLAddr(shell)})
+	if err == nil && resp.Rebind != nil {
+		if cfg.verbose {
+			cfg.logf("[控制端] rebind 完成 laddr=%s gen=%d took=%dus\n", resp.Rebind.LocalAddr, resp.Rebind.Gen, resp.Rebind.Took.Microseconds())
+		}
+		cfg.report.phase(phase, start, nil)
+		cfg.report.rebind(rebindReport{LocalAddr: resp.Rebind.LocalAddr, Gen: resp.Rebind.Gen, TookUS: resp.Rebind.Took.Microseconds()})
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "[控制端] 警告：控制端点 rebind 失败，退回 SIGUSR2：%v\n", err)
+	serr := sudoKill(pid, syscall.SIGUSR2)
+	cfg.report.phase(phase, start, serr)
+	rr := rebindReport{Fallback: "SIGUSR2"}
+	if err != nil {
+		rr.Error = err.Error()
+	}
+	cfg.report.rebind(rr)
+	return serr
+}
+
+// migrateTarget 返回本次迁移推送给 client 的目标：优先 --to，否则同机 B 的 host 端口。
+func migrateTarget(cfg *controlConfig) (string, int, error) {
+	if cfg.migrateTo == "" {
+		return "127.0.0.1", cfg.dstPort, nil
+	}
+	return wrapper.SplitTarget(cfg.migrateTo)
+}
+
+func newMigrationID() string {
+	return fmt.Sprintf("m-%d", time.Now().UnixNano())
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 多实例（一车一实例）。
+//
+// 每个实例有唯一的名字与编号（slot），由 instanceConfig 从基础配置派生：
+//   - 容器 inj-<name>-a / inj-<name>-b，各自的 host UDP 端口；
+//   - 独立的镜像子目录 <img-dir>/<name>；
+//   - post-copy 与 page-server 的 TCP 端口按 2×slot 偏移（默认 27027/27028 交错，互不冲突）。
+//
+// 因此不同实例的迁移可以并行：daemon 与 `control migrate <instance>...` 最多同时进行 --max-parallel 个。
+//
+// CLI 没有常驻进程，`up --instances N` 按固定规则命名与分配端口（<prefix>1..N，端口在 --src-port/--dst-port
+// 基础上按 2×(i-1) 递增），之后的 migrate/down 用同样的参数按名字推导出实例；migrate 再以状态库（state.go）
+// 中的实例记录为准（迁移后服务所在的容器与端口）。
+
+// instance 是一个服务实例：一个运行中的源容器 + 一个待注入的壳容器。
+// 开壳池时实例没有固定的壳（Shell 为空），迁移期间 Shell/DstPort 为从池中取出的目标。
+// Host 是 client 当前访问服务的主机（迁移指定 to 时随之更新），ClientID 是实例服务的 client。
+type instance struct {
+	Name     string `json:"name"`
+	Slot     int    `json:"slot"`
+	Src      string `json:"src"`
+	Shell    string `json:"shell"`
+	SrcPort  int    `json:"src_port"`
+	DstPort  int    `json:"dst_port"`
+	Host     string `json:"host"`
+	ClientID string `json:"client_id,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	ImgDir   string `json:"img_dir,omitempty"`
+	State    string `json:"state"`
+	Job      string `json:"job,omitempty"`
+
+	cfg *controlConfig
+}
+
+// instanceRegistry 是实例表：名字唯一、host 端口互不冲突。不自带锁，daemon 中由 daemon.mu 保护。
+type instanceRegistry map[string]*instance
+
+func (r instanceRegistry) add(in *instance) error {
+	if _, ok := r[in.Name]; ok {
+		return fmt.Errorf("instance %q exists: %w", in.Name, errConflict)
+	}
+	for _, o := range r {
+		for _, p := range []int{in.SrcPort, in.DstPort} {
+			if p != 0 && (p == o.SrcPort || p == o.DstPort) {
+				return fmt.Errorf("port %d is used by instance %q: %w", p, o.Name, errConflict)
+			}
+		}
+	}
+	r[in.Name] = in
+	return nil
+}
+
+// nextSlot 返回最小的未使用编号（default 实例占用 0）。
+func (r instanceRegistry) nextSlot() int {
+	used := map[int]bool{}
+	for _, in := range r {
+		used[in.Slot] = true
+	}
+	s := 1
+	for used[s] {
+		s++
+	}
+	return s
+}
+
+// list 返回按名字排序的实例快照。
+func (r instanceRegistry) list() []instance {
+	out := make([]instance, 0, len(r))
+	for _, in := range r {
+		out = append(out, *in)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// instanceConfig 由基础配置派生实例 name 的配置。dstPort 为 0 时没有固定的壳（迁移时从壳池取）。
+func instanceConfig(base *controlConfig, name string, slot, srcPort, dstPort int) *controlConfig {
+	c := *base
+	c.instanceName = name
+	c.slot = slot
+	c.aName = "inj-" + name + "-a"
+	c.bName = ""
+	if dstPort > 0 {
+		c.bName = "inj-" + name + "-b"
+	}
+	c.srcPort, c.dstPort = srcPort, dstPort
+	c.imgDir = filepath.Join(base.imgDir, name)
+	c.lazyPort += 2 * slot
+	c.pageServerPort += 2 * slot
+	return &c
+}
+
+// cliInstance 返回 `up --instances N` 创建的第 i 个实例的配置。
+func cliInstance(base *controlConfig, i int) *controlConfig {
+	name := base.instancePrefix + strconv.Itoa(i)
+	return instanceConfig(base, name, i, base.srcPort+2*(i-1), base.dstPort+2*(i-1))
+}
+
+// lookupInstance 按名字（<prefix><i>）找回 cliInstance 的配置。
+func lookupInstance(base *controlConfig, name string) (*controlConfig, error) {
+	s, ok := strings.CutPrefix(name, base.instancePrefix)
+	i, err := strconv.Atoi(s)
+	if !ok || err != nil || i <= 0 || strconv.Itoa(i) != s {
+		return nil, fmt.Errorf("unknown instance %q (up --instances N names them %s1..%sN)", name, base.instancePrefix, base.instancePrefix)
+	}
+	return cliInstance(base, i), nil
+}
+
+// upInstances 创建 --instances 个实例（镜像只构建一次）；端口冲突时在启动任何容器之前报错。
+func upInstances(base *controlConfig) {
+	// 状态库中其他实例（例如单个 A/B 的 default）占用的端口同样不能使用。
+	reg := instanceRegistry{}
+	if base.store != nil {
+		if d, err := base.store.load(); err == nil {
+			for name, in := range d.Instances {
+				if _, err := lookupInstance(base, name); err != nil {
+					reg[name] = in
+				}
+			}
+		}
+	}
+	cfgs := make([]*controlConfig, 0, base.instances)
+	for i := 1; i <= base.instances; i++ {
+		c := cliInstance(base, i)
+		if err := reg.add(&instance{Name: c.instanceName, SrcPort: c.srcPort, DstPort: c.dstPort}); err != nil {
+			dief("up: %v", err)
+		}
+		cfgs = append(cfgs, c)
+	}
+
+	var started []*controlConfig
+	defer func() {
+		if r := recover(); r != nil {
+			if !base.noCleanup {
+				for _, c := range started {
+					cleanContainers(c.rt, c.aName, c.bName)
+				}
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", r)
+			os.Exit(2)
+		}
+	}()
+
+	buildAndImage(base)
+	for _, c := range cfgs {
+		started = append(started, c)
+		prepareImgDir(c)
+		startA(c)
+		startB(c)
+		c.store.putInstance(instanceRecord(c, instRunning))
+		c.logf("[控制端] 实例 %s：A=%s(port=%d) B=%s(port=%d) imgDir=%s\n", c.instanceName, c.aName, c.srcPort, c.bName, c.dstPort, c.imgDir)
+	}
+	base.logf("[控制端] up 完成：%d 个实例\n", len(cfgs))
+}
+
+// migrateInstances 迁移 `control migrate [flags] <instance>...` 指定的实例，最多 --max-parallel 个同时进行；
+// 一个实例失败（及其回滚）不影响其他实例。
+func migrateInstances(base *controlConfig) {
+	cfgs := make([]*controlConfig, 0, len(base.args))
+	seen := map[string]bool{}
+	for _, name := range base.args {
+		c, err := lookupInstance(base, name)
+		if err != nil {
+			dief("migrate: %v", err)
+		}
+		if seen[name] {
+			dief("migrate: instance %q given twice", name)
+		}
+		seen[name] = true
+		useStoredInstance(c)
+		cfgs = append(cfgs, c)
+	}
+	if base.migrateTo != "" && len(cfgs) > 1 {
+		dief("migrate: --to applies to a single instance")
+	}
+
+	// 报告逐个输出，避免 --json 时多个实例的报告交错。
+	var mu sync.Mutex
+	failed := 0
+	runLimited(len(cfgs), base.maxParallel, func(i int) {
+		c := cfgs[i]
+		var err error
+		host := migrateHost(c)
+		if perr := tryStep(func() { err = doMigrate(c, "", nil, &stepRecorder{out: c.out}) }); perr != nil {
+			err = perr
+		}
+		recordMigrated(c, host, err)
+		mu.Lock()
+		defer mu.Unlock()
+		emitReport(c)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "[控制端] 实例 %s 迁移失败：%v\n", c.instanceName, err)
+			return
+		}
+		c.logf("[控制端] 实例 %s 迁移完成：restoredPID=%d\n", c.instanceName, c.restoredPID)
+	})
+	if failed > 0 {
+		dief("migrate: %d/%d instances failed", failed, len(cfgs))
+	}
+}
+
+// downInstances 删除 --instances 个实例的容器。
+func downInstances(base *controlConfig) {
+	step(base, "清理：容器", func() error {
+		for i := 1; i <= base.instances; i++ {
+			c := cliInstance(base, i)
+			if in, ok := c.store.instance(c.instanceName); ok {
+				cleanContainers(c.rt, in.Src, in.Shell)
+			}
+			cleanContainers(c.rt, c.aName, c.bName)
+			c.store.deleteInstance(c.instanceName)
+		}
+		return nil
+	})
+}
+
+// runLimited 并行执行 fn(0..n-1)，同一时刻最多 limit 个（limit<=0 视为 1）。
+func runLimited(n, limit int, fn func(i int)) {
+	if limit <= 0 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
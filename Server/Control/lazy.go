@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// post-copy（lazy-pages）迁移：--mode=postcopy | hybrid。
+//
+// pre-copy 的停机时间随 final dump 的脏页集增长；post-copy 把内存页挪到恢复之后：
+//   - 源端：criu dump --lazy-pages 只写内存页以外的镜像，随后留下 page-server 等待取页，A 保持冻结；
+//   - 目标：先起 criu lazy-pages daemon（连源端 page-server），再 criu restore --lazy-pages，
+//     B 立即运行，访问到的页经 userfaultfd 缺页时远程拉取，其余页在后台拉完；
+//   - 页传完后 lazy-pages daemon 与源端 dump 退出，CRIU 结束 A。
+//
+// hybrid 先做一轮 pre-dump（--prev-images-dir 引用它），lazy 阶段只需拉取之后写脏的页。
+//
+// 回滚：页传完之前 A 只是被冻结。失败时先停掉 lazy-pages daemon，源端 page-server 随之失败，
+// CRIU 让 A 恢复运行（见 rollback.go 的 phaseLazy）；镜像里没有内存页，不能像 pre-copy 那样从 dump 恢复。
+
+const (
+	modePrecopy  = "precopy"
+	modePostcopy = "postcopy"
+	modeHybrid   = "hybrid"
+)
+
+// lazyFetchTimeout 是 restore 之后等待内存页全部传完的上限。
+const lazyFetchTimeout = 2 * time.Minute
+
+// lazyCheckpointer 是支持 post-copy 的检查点后端。
+type lazyCheckpointer interface {
+	// LazyDump 写内存页以外的镜像，返回时源端 page-server 已就绪（req.LazyPort）。
+	LazyDump(req checkpointReq) (lazyDump, checkpointResult, error)
+}
+
+// lazyDump 是一次进行中的 post-copy 迁移（源端 page-server 仍在运行）。
+type lazyDump interface {
+	// Restore 启动 lazy-pages daemon 并在壳中恢复；返回时进程已运行，内存页仍在拉取。
+	Restore(req checkpointReq) (checkpointResult, error)
+	// Wait 等待内存页全部传完（lazy-pages daemon 与源端 dump 退出），返回缺页统计。
+	Wait(timeout time.Duration) (lazyStats, error)
+	// Abort 停止 lazy-pages daemon 与源端 page-server；CRIU 随即让源进程恢复运行。
+	Abort()
+}
+
+// lazyStats 是 post-copy 的取页统计（写进迁移报告）。
+type lazyStats struct {
+	// Faults 是 lazy-pages daemon 处理的缺页数（解析其日志；无法统计时为 -1）。
+	Faults int
+	// Fetch 是从 restore 完成到全部页传完的时间。
+	Fetch time.Duration
+	Log   string
+}
+
+// criuLazyDump 持有源端 dump（page-server）与目标端 lazy-pages daemon 两个 CRIU 进程。
+type criuLazyDump struct {
+	c    criuCheckpointer
+	port int
+
+	dump     *criuProc
+	lazy     *criuProc
+	lazyLog  string
+	restored time.Time
+}
+
+// criuProc 是一个后台运行的 CRIU 进程；done 在其退出后关闭，err 为退出结果。
+type criuProc struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+func (c criuCheckpointer) LazyDump(req checkpointReq) (lazyDump, checkpointResult, error) {
+	args := []string{c.criuHost, "dump", "-t", strconv.Itoa(req.PID), "-D", req.Dir, "-W", req.WorkDir,
+		"--shell-job", "--empty-ns", "net", "--manage-cgroups=ignore",
+		"--lazy-pages", "--address", "127.0.0.1", "--port", strconv.Itoa(req.LazyPort),
+	}
+	if req.Parent != "" {
+		args = append(args, "--prev-images-dir", req.Parent, "--track-mem")
+	}
+	args = append(args, append(buildSkipMntArgs(req.WorkDir), "-o", "dump.log", "-v4")...)
+
+	d := &criuLazyDump{c: c, port: req.LazyPort}
+	res, err := timedImages(req.Dir, func() error {
+		p, err := startWithStatus(filepath.Join(req.WorkDir, "lazy-dump.status"), args)
+		d.dump = p
+		return err
+	})
+	res.Log = filepath.Join(req.WorkDir, "dump.log")
+	if err != nil {
+		return nil, res, err
+	}
+	return d, res, nil
+}
+
+func (d *criuLazyDump) Restore(req checkpointReq) (checkpointResult, error) {
+	d.lazyLog = filepath.Join(req.Dir, "lazy-pages.log")
+	args := []string{d.c.criuHost, "lazy-pages", "--page-server", "--address", "127.0.0.1", "--port", strconv.Itoa(d.port),
+		"-D", req.Dir, "-W", req.Dir, "-o", filepath.Base(d.lazyLog), "-v4",
+	}
+	p, err := startWithStatus(filepath.Join(req.Dir, "lazy-pages.status"), args)
+	if err != nil {
+		return checkpointResult{Dir: req.Dir, Log: d.lazyLog}, fmt.Errorf("lazy-pages daemon: %w", err)
+	}
+	d.lazy = p
+
+	res, err := d.c.restore(req, "--lazy-pages")
+	d.restored = time.Now()
+	return res, err
+}
+
+func (d *criuLazyDump) Wait(timeout time.Duration) (lazyStats, error) {
+	st := lazyStats{Faults: -1, Log: d.lazyLog}
+	deadline := time.After(timeout)
+	for _, p := range []*criuProc{d.lazy, d.dump} {
+		if p == nil {
+			continue
+		}
+		select {
+		case <-p.done:
+			if p.err != nil {
+				return st, p.err
+			}
+		case <-deadline:
+			return st, fmt.Errorf("lazy pages not transferred within %s", timeout)
+		}
+	}
+	st.Fetch = time.Since(d.restored)
+	st.Faults = countPageFaults(d.lazyLog)
+	return st, nil
+}
+
+// Abort 先停 lazy-pages daemon，让源端 page-server 因连接断开而失败（CRIU 据此恢复源进程）；
+// 等不到 dump 退出时才强杀它。
+func (d *criuLazyDump) Abort() {
+	d.lazy.stop(2 * time.Second)
+	d.dump.stop(5 * time.Second)
+}
+
+// procPIDs 返回仍在运行的 CRIU 进程（sudo）的 PID，写进状态库：Control 崩溃后 resume 据此停止它们。
+func (d *criuLazyDump) procPIDs() []int {
+	var pids []int
+	for _, p := range []*criuProc{d.dump, d.lazy} {
+		if p == nil {
+			continue
+		}
+		select {
+		case <-p.done:
+		default:
+			pids = append(pids, p.cmd.Process.Pid)
+		}
+	}
+	return pids
+}
+
+// orphanLazyDump 是 Control 崩溃后遗留的 post-copy：只知道 CRIU 进程（sudo）的 PID，只能 Abort。
+type orphanLazyDump struct {
+	pids []int
+}
+
+func (orphanLazyDump) Restore(checkpointReq) (checkpointResult, error) {
+	return checkpointResult{}, errors.New("orphaned lazy dump cannot restore")
+}
+
+func (orphanLazyDump) Wait(time.Duration) (lazyStats, error) {
+	return lazyStats{}, errors.New("orphaned lazy dump cannot be waited for")
+}
+
+// Abort 与 criuLazyDump.Abort 相同：先停 lazy-pages daemon（后启动的 PID），再停源端 dump。
+func (d orphanLazyDump) Abort() {
+	for i := len(d.pids) - 1; i >= 0; i-- {
+		sudoPID := strconv.Itoa(d.pids[i])
+		_ = exec.Command("sudo", "pkill", "-TERM", "-P", sudoPID).Run()
+		for j := 0; j < 50 && exec.Command("sudo", "pgrep", "-P", sudoPID).Run() == nil; j++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		_ = exec.Command("sudo", "pkill", "-KILL", "-P", sudoPID).Run()
+	}
+}
+
+// stop 结束 CRIU（sudo 的子进程）：先 SIGTERM，grace 内未退出再 SIGKILL。
+func (p *criuProc) stop(grace time.Duration) {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	sudoPID := strconv.Itoa(p.cmd.Process.Pid)
+	_ = exec.Command("sudo", "pkill", "-TERM", "-P", sudoPID).Run()
+	select {
+	case <-p.done:
+	case <-time.After(grace):
+		_ = exec.Command("sudo", "pkill", "-KILL", "-P", sudoPID).Run()
+		<-p.done
+	}
+}
+
+// startWithStatus 以 sudo 启动长期运行的 CRIU 命令，等到它经 --status-fd 报告就绪。
+// sudo 会关闭继承的 fd，因此 status fd 经 FIFO 传递：sh 以写方式打开 FIFO 作为 fd 3 再 exec CRIU。
+func startWithStatus(fifo string, args []string) (*criuProc, error) {
+	_ = os.Remove(fifo)
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		return nil, fmt.Errorf("mkfifo %s: %w", fifo, err)
+	}
+	defer os.Remove(fifo)
+
+	shArgs := append([]string{"sh", "-c", `exec 3>"$1"; shift; exec "$@"`, "sh", fifo}, args...)
+	shArgs = append(shArgs, "--status-fd", "3")
+	cmd := exec.Command("sudo", shArgs...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &criuProc{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+
+	ready := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(fifo, os.O_RDONLY, 0)
+		if err != nil {
+			ready <- err
+			return
+		}
+		defer f.Close()
+		if _, err := bufio.NewReader(f).ReadByte(); err != nil {
+			ready <- errors.New("exited before ready")
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			<-p.done
+			return nil, fmt.Errorf("%s: %w (%s)", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return p, nil
+	case <-p.done:
+		// 进程在打开 FIFO 之前就退出了：以写方式打开一次，让阻塞在 open 上的读端返回。
+		if w, werr := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0); werr == nil {
+			_ = w.Close()
+		}
+		<-ready
+		return nil, fmt.Errorf("%s: exited before ready: %v (%s)", strings.Join(args, " "), p.err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// countPageFaults 统计 lazy-pages daemon 日志（-v4）中处理的缺页（“#PF at …”）。
+func countPageFaults(logPath string) int {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if strings.Contains(sc.Text(), "#PF at") {
+			n++
+		}
+	}
+	return n
+}
+
+// simLazyDump 模拟 post-copy：状态在 dump 时已经写出，没有需要拉取的页。
+type simLazyDump struct {
+	s simCheckpointer
+}
+
+func (s simCheckpointer) LazyDump(req checkpointReq) (lazyDump, checkpointResult, error) {
+	res, err := s.save(req)
+	if err != nil {
+		return nil, res, err
+	}
+	return simLazyDump{s: s}, res, nil
+}
+
+func (d simLazyDump) Restore(req checkpointReq) (checkpointResult, error) {
+	return d.s.Restore(req)
+}
+
+func (simLazyDump) Wait(time.Duration) (lazyStats, error) {
+	return lazyStats{}, nil
+}
+
+func (simLazyDump) Abort() {}
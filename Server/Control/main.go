@@ -19,6 +19,18 @@ func main() {
 		migrateCmd(os.Args[2:])
 	case "down":
 		downCmd(os.Args[2:])
+	case "agent":
+		agentCmd(os.Args[2:])
+	case "serve":
+		serveCmd(os.Args[2:])
+	case "bench":
+		benchCmd(os.Args[2:])
+	case "status":
+		statusCmd(os.Args[2:])
+	case "history":
+		historyCmd(os.Args[2:])
+	case "resume":
+		resumeCmd(os.Args[2:])
 	default:
 		usage()
 		os.Exit(2)
@@ -28,7 +40,14 @@ func main() {
 func usage() {
 	fmt.Fprintln(os.Stderr, "Usage:")
 	fmt.Fprintln(os.Stderr, "  sudo ./control up --img-dir /dev/shm/criu-inject --criu-host-bin /usr/local/sbin/criu-4.1.1")
-	fmt.Fprintln(os.Stderr, "  sudo ./control migrate --img-dir /dev/shm/criu-inject --criu-host-bin /usr/local/sbin/criu-4.1.1")
+	fmt.Fprintln(os.Stderr, "  sudo ./control migrate --img-dir /dev/shm/criu-inject --criu-host-bin /usr/local/sbin/criu-4.1.1 [--to 127.0.0.1:5243]")
 	fmt.Fprintln(os.Stderr, "  sudo ./control down --img-dir /dev/shm/criu-inject")
+	fmt.Fprintln(os.Stderr, "  sudo ./control up --instances 4 ... && sudo ./control migrate --max-parallel 2 ... v1 v3   # 多实例（一车一实例）")
+	fmt.Fprintln(os.Stderr, "  sudo ./control serve --listen 127.0.0.1:7380 --img-dir /dev/shm/criu-inject   # 常驻 HTTP/JSON API")
+	fmt.Fprintln(os.Stderr, "  sudo ./control agent --listen :7400 --img-dir /dev/shm/criu-dst --start-shell   # 跨主机：目标主机")
+	fmt.Fprintln(os.Stderr, "  sudo ./control migrate --remote-agent <host>:7400 --to <host>:5243 ...          # 跨主机：源主机")
+	fmt.Fprintln(os.Stderr, "  sudo ./control bench --iterations 50 --format csv --out bench.csv                # 来回迁移 N 次并汇总分布")
+	fmt.Fprintln(os.Stderr, "  sudo ./control status | history [--limit 20] [instance] | resume                 # 状态库：实例、迁移记录、恢复被中断的迁移")
+	fmt.Fprintln(os.Stderr, "  sudo ./control run --runtime fake --checkpointer sim ...                         # 无 podman/CRIU 的流程验证")
 	fmt.Fprintln(os.Stderr, "  sudo ./control run --img-dir /dev/shm/criu-inject --criu-host-bin /usr/local/sbin/criu-4.1.1")
 }
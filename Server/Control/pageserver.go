@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CRIU page-server 流式传输内存页（--page-server）。
+//
+// 默认 pre-dump/dump 把内存页写进 --img-dir，restore 再读回来；跨主机时这些页还要整目录经 TCP 再传一遍。
+// 开启后在目标侧（B 的 mount 命名空间内）为每一轮启动 `criu page-server -D <该轮目录>`，
+// 源端 pre-dump/dump 加 `--page-server --address --port`，pagemap/pages 直接写到目标侧；
+// 源端目录里只剩很小的非内存镜像，照常经共享目录（同机）或传输通道（跨主机，transfer.go）到达目标。
+//
+//   - 同机：Control 自己在 B 中启动 page-server，监听 127.0.0.1:<--page-server-port>。
+//   - 跨主机：经传输通道请求目标 agent 启动（op=page-server），源端连 --page-server-addr（默认 agent 的主机）。
+//
+// page-server 在 dump 连接关闭后自行退出；下一轮开始前与 restore 之前都会等它退出，保证页已落盘。
+// 注意：跨主机时源端不再有内存页，dump 之后失败无法在源端从 dump 恢复 A（见 rollback.go）。
+
+// pageServerWait 是等待上一个 page-server 退出的上限。
+const pageServerWait = 30 * time.Second
+
+// pageServerHost 在目标侧依次运行 page-server（同一时刻至多一个）。
+type pageServerHost struct {
+	cfg *controlConfig
+	cur *criuProc
+}
+
+// start 等上一个 page-server 退出后，为目录 dir 启动新的 page-server（parent 为上一轮目录，相对 dir），
+// 返回时已开始监听。addr 为空时监听所有地址。
+func (h *pageServerHost) start(dir, parent, addr string, port int) error {
+	// 上一轮的 page-server 通常已随 dump 连接关闭而退出；那一轮在源端失败时它可能仍在监听，结束它。
+	if err := h.wait(pageServerWait); err != nil {
+		h.stop()
+	}
+	if err := runQuiet("sudo", "mkdir", "-p", dir); err != nil {
+		return err
+	}
+	shellPID, err := h.cfg.rt.PID(h.cfg.bName)
+	if err != nil {
+		return err
+	}
+	args := []string{"nsenter", "-t", strconv.Itoa(shellPID), "-m", "--",
+		h.cfg.criuInB, "page-server", "-D", dir, "-W", dir, "--port", strconv.Itoa(port),
+		"-o", "page-server.log", "-v4",
+	}
+	if addr != "" {
+		args = append(args, "--address", addr)
+	}
+	if parent != "" {
+		args = append(args, "--prev-images-dir", parent)
+	}
+	p, err := startWithStatus(filepath.Join(dir, "page-server.status"), args)
+	if err != nil {
+		return fmt.Errorf("page-server: %w", err)
+	}
+	h.cur = p
+	return nil
+}
+
+// wait 等当前 page-server 退出（页已全部写入）。nil 可用：直接返回。
+func (h *pageServerHost) wait(timeout time.Duration) error {
+	if h == nil || h.cur == nil {
+		return nil
+	}
+	p := h.cur
+	select {
+	case <-p.done:
+		h.cur = nil
+		if p.err != nil {
+			return fmt.Errorf("page-server: %w", p.err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("page-server did not exit within %s", timeout)
+	}
+}
+
+func (h *pageServerHost) stop() {
+	if h == nil || h.cur == nil {
+		return
+	}
+	h.cur.stop(2 * time.Second)
+	h.cur = nil
+}
+
+// pageServerTarget 返回源端 dump 连接的 page-server 地址（host:port）。
+func pageServerTarget(cfg *controlConfig) (string, error) {
+	host := cfg.pageServerAddr
+	if host == "" && cfg.remoteAgent != "" {
+		h, _, err := net.SplitHostPort(cfg.remoteAgent)
+		if err != nil {
+			return "", fmt.Errorf("page-server address from --remote-agent: %w", err)
+		}
+		host = h
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.pageServerPort)), nil
+}
+
+// pageServerArgs 是 pre-dump/dump 流向 page-server 的 CRIU 参数。
+func pageServerArgs(target string) []string {
+	if target == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil
+	}
+	return []string{"--page-server", "--address", host, "--port", port}
+}
@@ -3,10 +3,117 @@ package main
 import (
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// podmanRuntime 是生产用的 Runtime：所有操作经 sudo podman 完成。
+type podmanRuntime struct{}
+
+func (podmanRuntime) Name() string { return "podman" }
+
+func (podmanRuntime) BuildImage(contextDir, image string) error {
+	return runQuiet("sudo", "podman", "build", "-t", image, contextDir)
+}
+
+func (r podmanRuntime) StartInstance(spec containerSpec) (int, error) {
+	_ = runQuiet("sudo", "podman", "rm", "-f", spec.Name)
+	args := []string{
+		"podman", "run", "-d", "--privileged", "--name", spec.Name, "--pid=host",
+		"-p", fmt.Sprintf("%d:4242/udp", spec.HostPort),
+		"-v", fmt.Sprintf("%s:%s:rw", spec.ImgDir, spec.ImgDir),
+		"-e", fmt.Sprintf("CONTROL_SOCKET=%s", spec.CtlSocket),
+		"-e", "QUIET=1",
+		spec.Image,
+	}
+	if err := runQuiet("sudo", args...); err != nil {
+		return 0, err
+	}
+	return r.PID(spec.Name)
+}
+
+func (r podmanRuntime) CreateShell(spec containerSpec) (int, error) {
+	_ = runQuiet("sudo", "podman", "rm", "-f", spec.Name)
+
+	args := []string{
+		"podman", "run", "-d", "--privileged", "--name", spec.Name, "--pid=host",
+		"-p", fmt.Sprintf("%d:4242/udp", spec.HostPort),
+		"-v", fmt.Sprintf("%s:%s:rw", spec.ImgDir, spec.ImgDir),
+		"--entrypoint", "sleep",
+	}
+
+	// 将 host 上 criu 的所在目录挂进容器，避免假设 /usr/local/sbin。
+	if spec.CRIUHost != "" {
+		args = mountIfExists(args, filepath.Dir(spec.CRIUHost), "/hostbin", "ro")
+	}
+
+	// criu/loader 依赖的动态库：不同发行版路径不同，按存在性选择性挂载。
+	args = mountIfExists(args, "/lib64", "/lib64", "ro")
+	args = mountIfExists(args, "/usr/lib64", "/usr/lib64", "ro")
+	args = mountIfExists(args, "/lib/x86_64-linux-gnu", "/lib/x86_64-linux-gnu", "ro")
+	args = mountIfExists(args, "/usr/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu", "ro")
+
+	args = append(args, spec.Image, "infinity")
+
+	if err := runQuiet("sudo", args...); err != nil {
+		return 0, err
+	}
+	return r.PID(spec.Name)
+}
+
+// Spawn 用 podman exec 在壳里启动 /server_bin。
+// exec -d 不返回 PID：由 sh 把自己的 PID（--pid=host 下即 host PID）写到共享的 imgDir 再 exec。
+func (podmanRuntime) Spawn(spec containerSpec, env []string) (int, error) {
+	pidFile := filepath.Join(spec.ImgDir, spec.Name+".spawn.pid")
+	_ = runQuiet("sudo", "rm", "-f", pidFile)
+	args := []string{"podman", "exec", "-d",
+		"-e", fmt.Sprintf("CONTROL_SOCKET=%s", spec.CtlSocket),
+		"-e", "QUIET=1",
+	}
+	for _, kv := range env {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, spec.Name, "sh", "-c", fmt.Sprintf("echo $$ > %s; exec /server_bin", pidFile))
+	if err := runQuiet("sudo", args...); err != nil {
+		return 0, err
+	}
+	var lastErr error
+	for i := 0; i < 100; i++ {
+		pid, err := readPIDFile(pidFile)
+		if err == nil {
+			return pid, nil
+		}
+		lastErr = err
+		time.Sleep(20 * time.Millisecond)
+	}
+	return 0, fmt.Errorf("spawn in %s: %w", spec.Name, lastErr)
+}
+
+func (podmanRuntime) PID(name string) (int, error) {
+	return podmanStatePID(name)
+}
+
+func (podmanRuntime) NetNS(name string) (string, error) {
+	pid, err := podmanStatePID(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", pid), nil
+}
+
+func (podmanRuntime) CRIUPath(hostBin string) string {
+	return filepath.Join("/hostbin", filepath.Base(hostBin))
+}
+
+// RestoreLAddr 返回空：容器内端口固定为 4242，host 端口由 -p 映射区分。
+func (podmanRuntime) RestoreLAddr(name string) string { return "" }
+
+func (podmanRuntime) Remove(name string) error {
+	return exec.Command("sudo", "podman", "rm", "-f", name).Run()
+}
+
 func podmanStatePID(name string) (int, error) {
 	cmd := exec.Command("sudo", "podman", "inspect", "-f", "{{.State.Pid}}", name)
 	out, err := cmd.Output()
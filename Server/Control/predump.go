@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pre-dump 轮数控制。
+//
+// 固定模式（默认）：执行 --predump-rounds 轮。
+// 自适应模式（--predump-adaptive）：每轮 pre-dump 后读取本轮写出的脏页数（pages-*.img 的大小），
+// 满足以下任一条件即停止、进入 final dump：
+//   - converged：脏页数低于 --predump-threshold-pages（final dump 已足够小）；
+//   - stalled：与上一轮相比缩小不到 --predump-min-shrink（再迭代也追不上应用的写速度）；
+//   - budget：已用时间加上一轮的耗时将超出 --predump-budget；
+//   - max-rounds：达到 --predump-max-rounds。
+//
+// 第 0 轮是全量，只参与 converged 判断（内存本来就小的进程一轮即可）。
+// 脏页数取自源端镜像目录，因此不能与 --page-server（页直接写到目标侧）同时使用。
+// --mode=postcopy 不做 pre-dump，hybrid 固定一轮（见 lazy.go）。
+// 每一轮的判断都打印出来并写进迁移报告（predump.rounds）。
+
+// criuPageSize 是 CRIU pages-*.img 中页的大小。
+const criuPageSize = 4096
+
+const (
+	predumpContinue  = "continue"
+	predumpConverged = "converged"
+	predumpStalled   = "stalled"
+	predumpBudget    = "budget"
+	predumpMaxRounds = "max-rounds"
+	predumpFixed     = "fixed"
+	predumpFailed    = "failed"
+)
+
+type predumpController struct {
+	adaptive  bool
+	rounds    int
+	threshold int64
+	minShrink float64
+	budget    time.Duration
+
+	start     time.Time
+	prevPages int64
+	out       io.Writer
+}
+
+func newPredumpController(cfg *controlConfig) *predumpController {
+	c := &predumpController{rounds: cfg.predumpRounds, start: time.Now(), prevPages: -1, out: cfg.logOut()}
+	switch {
+	case cfg.mode == modePostcopy:
+		c.rounds = 0
+	case cfg.mode == modeHybrid:
+		c.rounds = 1
+	case cfg.predumpAdaptive:
+		c.adaptive = true
+		c.rounds = cfg.predumpMaxRounds
+		c.threshold = cfg.predumpThreshold
+		c.minShrink = cfg.predumpMinShrink
+		c.budget = cfg.predumpBudget
+	}
+	return c
+}
+
+func (c *predumpController) enabled() bool {
+	return c.rounds > 0
+}
+
+// predumpRound 是一轮 pre-dump 的脏页数与判断（写进迁移报告）。
+type predumpRound struct {
+	Round    int    `json:"round"`
+	Pages    int64  `json:"pages"`
+	Bytes    int64  `json:"bytes"`
+	TookMS   int64  `json:"took_ms"`
+	Decision string `json:"decision"`
+}
+
+// next 根据第 round 轮的结果决定是否继续（Decision 为 predumpContinue 或停止原因）。
+func (c *predumpController) next(round int, res checkpointResult) predumpRound {
+	r := predumpRound{Round: round, Pages: dirtyPages(res), Bytes: res.Bytes, TookMS: res.Took.Milliseconds()}
+	prev := c.prevPages
+	c.prevPages = r.Pages
+
+	switch {
+	case !c.adaptive && round+1 < c.rounds:
+		r.Decision = predumpContinue
+	case !c.adaptive:
+		r.Decision = predumpFixed
+	case r.Pages < c.threshold:
+		r.Decision = predumpConverged
+	case round > 0 && float64(r.Pages) > float64(prev)*(1-c.minShrink):
+		r.Decision = predumpStalled
+	case c.budget > 0 && time.Since(c.start)+res.Took > c.budget:
+		r.Decision = predumpBudget
+	case round+1 >= c.rounds:
+		r.Decision = predumpMaxRounds
+	default:
+		r.Decision = predumpContinue
+	}
+	logPredumpRound(c.out, r, prev)
+	return r
+}
+
+// dirtyPages 返回一轮 pre-dump 写出的页数：pages-*.img 的总大小 / 页大小。
+// 增量轮次只写脏页，已在上一轮镜像中的页记在 pagemap 里，因此这就是本轮的脏页集。
+// 没有 pages-*.img（sim 后端）时按镜像总大小折算。
+func dirtyPages(res checkpointResult) int64 {
+	var n int64
+	found := false
+	entries, _ := os.ReadDir(res.Dir)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), "pages-") || filepath.Ext(e.Name()) != ".img" {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		found = true
+		n += fi.Size()
+	}
+	if !found {
+		n = res.Bytes
+	}
+	return (n + criuPageSize - 1) / criuPageSize
+}
+
+// logPredumpRound 打印一轮的收敛判断。
+func logPredumpRound(w io.Writer, r predumpRound, prev int64) {
+	was := ""
+	if prev >= 0 {
+		was = fmt.Sprintf("（上一轮 %d）", prev)
+	}
+	if r.Decision == predumpContinue {
+		fmt.Fprintf(w, "[控制端] pre-dump #%d：脏页 %d%s → 继续\n", r.Round, r.Pages, was)
+		return
+	}
+	fmt.Fprintf(w, "[控制端] pre-dump #%d：脏页 %d%s → 停止（%s），进入 final dump\n", r.Round, r.Pages, was, r.Decision)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// 迁移报告：每次迁移生成一份机器可读的 JSON，便于横向比较迁移配置（pre-dump 轮数、检查点后端、跨主机等），
+// 不必再从日志里手抄数字。
+//
+//   - CLI（run/migrate）：写到 --report-dir/<id>.json；--json 时同时输出到 stdout（其余日志改走 stderr）。
+//   - daemon：每个任务结束时同样写到 --report-dir，并经 GET /migrations/{id}/report 返回。
+//
+// doMigrate 开始时创建 cfg.report，各阶段把起止时间、镜像大小、CRIU 退出信息、ACK 与 rebind 结果记进去。
+// 与 stepRecorder 相同，nil *reportBuilder 可用：只是不记录。
+
+type migrationReport struct {
+	ID            string    `json:"id"`
+	Instance      string    `json:"instance,omitempty"`
+	To            string    `json:"to"`
+	Runtime       string    `json:"runtime"`
+	Checkpointer  string    `json:"checkpointer"`
+	Mode          string    `json:"mode"`
+	PredumpRounds int       `json:"predump_rounds"`
+	RemoteAgent   string    `json:"remote_agent,omitempty"`
+	PageServer    string    `json:"page_server,omitempty"`
+	State         string    `json:"state"`
+	Error         string    `json:"error,omitempty"`
+	Rollback      string    `json:"rollback,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	TotalMS       int64     `json:"total_ms"`
+	RestoredPID   int       `json:"restored_pid,omitempty"`
+
+	// Steps 是 stepRecorder 记录的步骤（与日志中的步骤名一致）。
+	Steps []stepReport `json:"steps"`
+	// Phases 是关键阶段，名字固定：predump-<n> / signal / dump / kill / transfer / restore / rebind / postcopy-fetch / commit；
+	// 回滚中的恢复记为 rollback-restore / rollback-rebind。
+	// in-band commit 由 server 在 rebind 成功后立即发出，计入 rebind；commit 只记录旧的带外通道（--commit-addr）。
+	Phases    []stepReport           `json:"phases"`
+	Images    []imageReport          `json:"images"`
+	Transfers []transferReport       `json:"transfers,omitempty"`
+	Ack       *wrapper.MigrateResult `json:"ack,omitempty"`
+	Rebind    *rebindReport          `json:"rebind,omitempty"`
+	// Predump 是各轮 pre-dump 的脏页数与收敛判断（见 predump.go）；关闭 pre-dump 时省略。
+	Predump *predumpReport `json:"predump,omitempty"`
+	// Postcopy 是 post-copy 的取页统计（--mode=postcopy|hybrid）。
+	Postcopy *postcopyReport `json:"postcopy,omitempty"`
+	// DowntimeMS 是 client 观测到的服务中断（最后一次 echo 到恢复后第一次 echo）；无法观测时省略。
+	DowntimeMS *int64 `json:"downtime_ms,omitempty"`
+}
+
+type stepReport struct {
+	Name   string    `json:"name"`
+	State  string    `json:"state"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	TookMS int64     `json:"took_ms"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// imageReport 是一次 checkpoint 操作（pre-dump/dump/restore）的镜像大小与 CRIU 退出信息。
+type imageReport struct {
+	Kind   string `json:"kind"`
+	Round  int    `json:"round"`
+	Dir    string `json:"dir"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	TookMS int64  `json:"took_ms"`
+	// ExitCode：0 为成功；进程非零退出时为其退出码；未能执行（或非 exec 错误）时为 -1。
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	// Log 是 CRIU 日志文件（sim 后端为空）。
+	Log string `json:"log,omitempty"`
+}
+
+type predumpReport struct {
+	// Mode：fixed | adaptive。
+	Mode   string         `json:"mode"`
+	Stop   string         `json:"stop,omitempty"`
+	Rounds []predumpRound `json:"rounds"`
+}
+
+type postcopyReport struct {
+	Port int `json:"port"`
+	// Faults 是 lazy-pages daemon 处理的缺页数；-1 表示无法统计。
+	Faults  int    `json:"faults"`
+	FetchMS int64  `json:"fetch_ms"`
+	Log     string `json:"log,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type transferReport struct {
+	Dir    string `json:"dir"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	TookMS int64  `json:"took_ms"`
+}
+
+type rebindReport struct {
+	LocalAddr string `json:"local_addr,omitempty"`
+	Gen       uint64 `json:"gen,omitempty"`
+	TookUS    int64  `json:"took_us"`
+	// Fallback 非空表示控制端点 rebind 失败，退回了信号（SIGUSR2）。
+	Fallback string `json:"fallback,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type reportBuilder struct {
+	mu  sync.Mutex
+	rep migrationReport
+}
+
+func newReport(id string, cfg *controlConfig) *reportBuilder {
+	to := cfg.migrateTo
+	if to == "" {
+		to = fmt.Sprintf("127.0.0.1:%d", cfg.dstPort)
+	}
+	b := &reportBuilder{rep: migrationReport{
+		ID: id, Instance: cfg.instanceName, To: to, Mode: cfg.mode, PredumpRounds: cfg.predumpRounds, RemoteAgent: cfg.remoteAgent,
+		State: jobRunning, Start: time.Now(),
+	}}
+	if cfg.rt != nil {
+		b.rep.Runtime = cfg.rt.Name()
+	}
+	if cfg.ckpt != nil {
+		b.rep.Checkpointer = cfg.ckpt.Name()
+	}
+	return b
+}
+
+func newStepReport(name string, start, end time.Time, err error) stepReport {
+	s := stepReport{Name: name, State: stepDone, Start: start, End: end, TookMS: end.Sub(start).Milliseconds()}
+	if err != nil {
+		s.State = stepFailed
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// phase 记录从 start 到现在的一个关键阶段。
+func (b *reportBuilder) phase(name string, start time.Time, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.Phases = append(b.rep.Phases, newStepReport(name, start, time.Now(), err))
+}
+
+// checkpoint 记录一次 checkpoint 操作：既是一个阶段，也产出一条镜像记录。
+func (b *reportBuilder) checkpoint(phase, kind string, round int, start time.Time, res checkpointResult, err error) {
+	if b == nil {
+		return
+	}
+	b.phase(phase, start, err)
+	img := imageReport{
+		Kind: kind, Round: round, Dir: res.Dir, Files: res.Files, Bytes: res.Bytes,
+		TookMS: res.Took.Milliseconds(), ExitCode: exitCode(err), Log: res.Log,
+	}
+	if err != nil {
+		img.Error = err.Error()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.Images = append(b.rep.Images, img)
+}
+
+// predumpRound 记录一轮 pre-dump 的判断；停止判断同时记为 Stop。
+func (b *reportBuilder) predumpRound(mode string, r predumpRound) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rep.Predump == nil {
+		b.rep.Predump = &predumpReport{Mode: mode}
+	}
+	b.rep.Predump.Rounds = append(b.rep.Predump.Rounds, r)
+	if r.Decision != predumpContinue {
+		b.rep.Predump.Stop = r.Decision
+	}
+}
+
+func (b *reportBuilder) postcopy(port int, st lazyStats, err error) {
+	if b == nil {
+		return
+	}
+	r := postcopyReport{Port: port, Faults: st.Faults, FetchMS: st.Fetch.Milliseconds(), Log: st.Log}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.Postcopy = &r
+}
+
+func (b *reportBuilder) pageServer(target string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.PageServer = target
+}
+
+func (b *reportBuilder) ack(res *wrapper.MigrateResult) {
+	if b == nil || res == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.Ack = res
+}
+
+func (b *reportBuilder) rebind(r rebindReport) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.Rebind = &r
+}
+
+func (b *reportBuilder) transfer(st xferStat) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.Transfers = append(b.rep.Transfers, transferReport{Dir: st.Dir, Files: st.Files, Bytes: st.Bytes, TookMS: st.Took.Milliseconds()})
+}
+
+func (b *reportBuilder) downtime(d time.Duration) {
+	if b == nil || d < 0 {
+		return
+	}
+	ms := d.Milliseconds()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.DowntimeMS = &ms
+}
+
+// finish 在 doMigrate 返回时调用：记录结果、回滚信息与步骤。
+func (b *reportBuilder) finish(rec *stepRecorder, restoredPID int, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rep.End = time.Now()
+	b.rep.TotalMS = b.rep.End.Sub(b.rep.Start).Milliseconds()
+	b.rep.RestoredPID = restoredPID
+	b.rep.State = jobDone
+	if err != nil {
+		b.rep.State = jobFailed
+		b.rep.Error = err.Error()
+		var re *rollbackError
+		if errors.As(err, &re) {
+			b.rep.Rollback = re.outcome()
+		}
+	}
+	b.rep.Steps = stepReports(rec.snapshot())
+}
+
+func (b *reportBuilder) snapshot() migrationReport {
+	if b == nil {
+		return migrationReport{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rep := b.rep
+	rep.Steps = append([]stepReport(nil), b.rep.Steps...)
+	rep.Phases = append([]stepReport(nil), b.rep.Phases...)
+	rep.Images = append([]imageReport(nil), b.rep.Images...)
+	rep.Transfers = append([]transferReport(nil), b.rep.Transfers...)
+	if b.rep.Predump != nil {
+		pd := *b.rep.Predump
+		pd.Rounds = append([]predumpRound(nil), pd.Rounds...)
+		rep.Predump = &pd
+	}
+	if b.rep.Postcopy != nil {
+		pc := *b.rep.Postcopy
+		rep.Postcopy = &pc
+	}
+	return rep
+}
+
+func stepReports(steps []stepRecord) []stepReport {
+	out := make([]stepReport, 0, len(steps))
+	for _, s := range steps {
+		r := stepReport{Name: s.Name, State: s.State, Start: s.Start, End: s.End, Error: s.Error}
+		if !s.End.IsZero() {
+			r.TookMS = s.End.Sub(s.Start).Milliseconds()
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// exitCode 从命令错误中取退出码（runQuiet 以 %w 包装 *exec.ExitError）。
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// saveReport 把报告写到 dir/<id>.json，返回文件路径。
+func saveReport(dir string, rep migrationReport) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, rep.ID+".json")
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// emitReport 是 CLI 的报告出口：写文件；--json 时把报告输出到原 stdout。
+func emitReport(cfg *controlConfig) {
+	rep := cfg.report.snapshot()
+	if rep.ID == "" {
+		return
+	}
+	path, err := saveReport(cfg.reportDir, rep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[控制端] 警告：写迁移报告失败：%v\n", err)
+	} else {
+		cfg.logf("[控制端] 迁移报告：%s\n", path)
+	}
+	if cfg.jsonOut != nil {
+		enc := json.NewEncoder(cfg.jsonOut)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+	}
+}
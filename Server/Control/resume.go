@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// control status / history / resume：读取状态库（state.go）。
+//
+//   - status：实例记录（附源进程是否存活）与进行中的迁移；Control 已不在的迁移标为 interrupted。
+//   - history [instance]：最近的迁移记录（--limit，最新的在前）。
+//   - resume：恢复被中断的迁移，按记录的阶段处理：
+//     idle/armed：A 仍在运行，确认后通知 client abort；
+//     armed 但 A 已不在且 img-dir 中有本次迁移完成的 dump（stopped 异步记录，崩溃时可能没写上）：按 stopped 处理；
+//     lazy：停止遗留的 CRIU 进程让 A 恢复运行，再 abort；
+//     stopped：删除（可能半恢复的）壳，A 从 dump 恢复，再 abort；
+//     restored：恢复出的进程还在则补完迁移（服务在壳里，原 A 重建为壳），否则 pre-copy 按 stopped 处理。
+//     daemon 启动时自动执行 resume。
+
+// statusView 是 `status --json` 的输出。
+type statusView struct {
+	Instances  []instanceStatus   `json:"instances"`
+	Migrations []*migrationRecord `json:"migrations"`
+}
+
+type instanceStatus struct {
+	instance
+	Alive bool `json:"alive"`
+}
+
+func statusCmd(args []string) {
+	cfg := parseCommonFlags("status", args)
+	cfg.openStore()
+	d := mustLoadState(cfg)
+
+	var v statusView
+	for _, in := range instanceRegistry(d.Instances).list() {
+		v.Instances = append(v.Instances, instanceStatus{instance: in, Alive: in.PID > 0 && sudoKill0(in.PID) == nil})
+	}
+	for _, m := range d.Migrations {
+		if m.State != jobRunning {
+			continue
+		}
+		mc := *m
+		if mc.interrupted() {
+			mc.State = migInterrupted
+		}
+		v.Migrations = append(v.Migrations, &mc)
+	}
+	if cfg.jsonOut != nil {
+		writeIndented(cfg.jsonOut, v)
+		return
+	}
+
+	w := tabwriter.NewWriter(cfg.logOut(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tSTATE\tSRC\tPID\tALIVE\tSHELL\tHOST\tCLIENT")
+	for _, in := range v.Instances {
+		shell := "-"
+		if in.Shell != "" {
+			shell = fmt.Sprintf("%s:%d", in.Shell, in.DstPort)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s:%d\t%d\t%v\t%s\t%s\t%s\n", in.Name, in.State, in.Src, in.SrcPort, in.PID, in.Alive, shell, in.Host, dash(in.ClientID))
+	}
+	w.Flush()
+	if len(v.Migrations) == 0 {
+		return
+	}
+	fmt.Fprintln(cfg.logOut())
+	w = tabwriter.NewWriter(cfg.logOut(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tINSTANCE\tSTATE\tPHASE\tOWNER\tSTARTED")
+	for _, m := range v.Migrations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", m.ID, m.Instance, m.State, m.Phase, m.Owner, m.Started.Format(time.RFC3339))
+	}
+	w.Flush()
+	for _, m := range v.Migrations {
+		if m.State == migInterrupted {
+			fmt.Fprintln(os.Stderr, "[控制端] 存在被中断的迁移：运行 `control resume` 恢复")
+			break
+		}
+	}
+}
+
+func historyCmd(args []string) {
+	cfg := parseCommonFlags("history", args)
+	cfg.openStore()
+	d := mustLoadState(cfg)
+
+	var out []*migrationRecord
+	for i := len(d.Migrations) - 1; i >= 0; i-- {
+		m := d.Migrations[i]
+		if len(cfg.args) > 0 && m.Instance != cfg.args[0] {
+			continue
+		}
+		if m.interrupted() {
+			mc := *m
+			mc.State = migInterrupted
+			m = &mc
+		}
+		out = append(out, m)
+		if cfg.historyLimit > 0 && len(out) >= cfg.historyLimit {
+			break
+		}
+	}
+	if cfg.jsonOut != nil {
+		writeIndented(cfg.jsonOut, out)
+		return
+	}
+
+	w := tabwriter.NewWriter(cfg.logOut(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tINSTANCE\tSTATE\tPHASE\tMODE\tSTARTED\tTOOK\tERROR")
+	for _, m := range out {
+		took := "-"
+		if !m.Finished.IsZero() {
+			took = m.Finished.Sub(m.Started).Round(time.Millisecond).String()
+		}
+		msg := m.Error
+		if m.Rollback != "" {
+			msg = m.Rollback
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.ID, m.Instance, m.State, m.Phase, m.Mode, m.Started.Format(time.RFC3339), took, dash(msg))
+	}
+	w.Flush()
+}
+
+func resumeCmd(args []string) {
+	cfg := parseCommonFlags("resume", args)
+	cfg.openStore()
+	mustLoadState(cfg)
+	results := resumeInterrupted(cfg)
+	if cfg.jsonOut != nil {
+		writeIndented(cfg.jsonOut, results)
+	}
+	if len(results) == 0 {
+		cfg.logf("[控制端] 没有被中断的迁移\n")
+		return
+	}
+	failed := 0
+	for _, m := range results {
+		if m.State == jobFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		dief("resume: %d/%d migrations could not be recovered", failed, len(results))
+	}
+}
+
+// resumeInterrupted 恢复所有被中断的迁移，返回更新后的记录。
+func resumeInterrupted(base *controlConfig) []*migrationRecord {
+	d, err := base.store.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[控制端] 警告：读状态库失败：%v\n", err)
+		return nil
+	}
+	var out []*migrationRecord
+	for _, m := range d.Migrations {
+		if !m.interrupted() {
+			continue
+		}
+		in, ok := d.Instances[m.Instance]
+		if !ok {
+			in = &instance{Name: m.Instance, Src: m.Src, SrcPort: m.SrcPort, Host: "127.0.0.1", ImgDir: m.ImgDir}
+		}
+		base.logf("[控制端] resume：迁移 id=%s instance=%s 中断于 phase=%s\n", m.ID, m.Instance, m.Phase)
+		resumeMigration(base, m, in)
+		m.Finished = time.Now()
+		base.logf("[控制端] resume：id=%s → %s %s\n", m.ID, m.State, m.Rollback)
+
+		inst := *in
+		inst.Job = ""
+		base.store.save(func(d *stateData) error {
+			for i, x := range d.Migrations {
+				if x.ID == m.ID {
+					d.Migrations[i] = m
+				}
+			}
+			d.Instances[inst.Name] = &inst
+			return nil
+		})
+		out = append(out, m)
+	}
+	return out
+}
+
+// resumeMigration 按 m 记录的阶段处理一次被中断的迁移，更新 m（State/Rollback/Resumed）与实例记录 in。
+func resumeMigration(base *controlConfig, m *migrationRecord, in *instance) {
+	c := *base
+	c.instanceName = m.Instance
+	c.aName, c.bName = m.Src, m.Shell
+	c.srcPort, c.dstPort = m.SrcPort, m.DstPort
+	c.imgDir = m.ImgDir
+	c.mode = m.Mode
+	c.remoteAgent = m.RemoteAgent
+	c.srcPID, c.aInitPID = m.SrcPID, m.SrcPID
+	c.predumpLastDir = m.PredumpLastDir
+	c.pool = nil
+	rec := &stepRecorder{}
+	m.Resumed = time.Now()
+	cause := fmt.Errorf("migration %s interrupted: control pid=%d is gone", m.ID, m.Owner)
+
+	phase := phaseFromString(m.Phase)
+	if phase == phaseRestored {
+		if m.RestoredPID > 0 && sudoKill0(m.RestoredPID) == nil {
+			// 服务已在壳里运行：补完迁移。原 A 在 pre-copy 中早已停止，post-copy 的遗留 CRIU 会在页传完后结束它。
+			m.State = jobDone
+			m.Rollback = fmt.Sprintf("已补完：服务在 %s pid=%d", m.Shell, m.RestoredPID)
+			in.Src, in.SrcPort, in.PID = m.Shell, m.DstPort, m.RestoredPID
+			in.Shell, in.DstPort = "", 0
+			if m.RemoteAgent == "" {
+				if _, err := c.rt.CreateShell(c.spec(m.Src, m.SrcPort)); err == nil {
+					in.Shell, in.DstPort = m.Src, m.SrcPort
+				}
+			}
+			in.State = instRunning
+			return
+		}
+		if m.Mode != modePrecopy {
+			m.State = jobFailed
+			m.Rollback = fmt.Sprintf("无法恢复：恢复出的进程 pid=%d 已退出，%s 的镜像中没有内存页", m.RestoredPID, m.Mode)
+			in.State = instFailed
+			return
+		}
+		phase = phaseStopped
+	}
+
+	if phase == phaseArmed && m.Mode == modePrecopy && (m.SrcPID <= 0 || sudoKill0(m.SrcPID) != nil) &&
+		c.ckpt.DumpDone(m.ImgDir, m.Started) {
+		// dump 已停止 A，但 stopped 还没写进日志 Control 就退出了：从 dump 恢复，而不是确认一个已不存在的 A。
+		c.logf("[控制端] resume：id=%s 源进程 pid=%d 已退出且 dump 已完成，按 stopped 恢复\n", m.ID, m.SrcPID)
+		phase = phaseStopped
+	}
+
+	re := &rollbackError{Err: cause, Phase: phase}
+	if phase == phaseIdle {
+		// prepare-migrate 之前：client 未被通知，只需确认 A 仍在运行。
+		re.RollbackErr = confirmSource(&c, re)
+		re.Recovered = re.RollbackErr == nil
+	} else {
+		switch phase {
+		case phaseLazy:
+			c.lazy = orphanLazyDump{pids: m.LazyPIDs}
+		case phaseStopped:
+			// 壳里可能有半恢复的进程：删除壳（跨主机时壳在目标主机上，由其 agent 管理）。
+			if m.Shell != "" && m.RemoteAgent == "" {
+				_ = c.rt.Remove(m.Shell)
+			}
+		}
+		if err := rollback(&c, rec, m.ID, phase, cause); !errors.As(err, &re) {
+			re = &rollbackError{Err: cause, Phase: phase, RollbackErr: err}
+		}
+	}
+	if !re.Recovered {
+		m.State = jobFailed
+		m.Rollback = fmt.Sprintf("无法恢复 phase=%s：%v", phase, re.RollbackErr)
+		in.State = instFailed
+		return
+	}
+	m.State = migRecovered
+	m.Rollback = re.outcome()
+	if phase == phaseIdle {
+		m.Rollback = fmt.Sprintf("未开始切换：服务仍在 A pid=%d", re.PID)
+	}
+	in.Src, in.SrcPort, in.PID = m.Src, m.SrcPort, re.PID
+	in.State = instRunning
+	switch {
+	case m.Pooled:
+		// 池中取出的壳不再归还（daemon 重启后壳池是新的）。
+		_ = c.rt.Remove(m.Shell)
+		in.Shell, in.DstPort = "", 0
+	case phase == phaseStopped && m.Shell != "" && m.RemoteAgent == "":
+		in.Shell, in.DstPort = "", 0
+		if _, err := c.rt.CreateShell(c.spec(m.Shell, m.DstPort)); err == nil {
+			in.Shell, in.DstPort = m.Shell, m.DstPort
+		}
+	}
+}
+
+func phaseFromString(s string) migPhase {
+	for p := phaseIdle; p <= phaseRestored; p++ {
+		if p.String() == s {
+			return p
+		}
+	}
+	return phaseIdle
+}
+
+// mustLoadState 读取状态库，迁移记录按开始时间排序。
+func mustLoadState(cfg *controlConfig) *stateData {
+	if cfg.store == nil {
+		dief("state store unavailable (--state-dir %s)", cfg.stateDir)
+	}
+	d, err := cfg.store.load()
+	if err != nil {
+		dief("state: %v", err)
+	}
+	sort.SliceStable(d.Migrations, func(i, j int) bool { return d.Migrations[i].Started.Before(d.Migrations[j].Started) })
+	return d
+}
+
+func writeIndented(w io.Writer, v any) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// 迁移状态机与回滚。
+//
+// doMigrate 按阶段推进，每个阶段对应一种“失败时如何让服务继续可用”的处理：
+//
+//	idle     ──prepare-migrate──▶ armed ──dump 成功──▶ stopped ──restore+rebind──▶ restored
+//	  │失败：无副作用            │失败：A 仍在运行      │失败：A 已停止
+//	  ▼                          ▼                      ▼
+//	直接返回                  abort(A)               A 从 dump 恢复到新的 A 壳，再 abort
+//
+// post-copy（lazy.go）中 dump 成功后进入 lazy 而不是 stopped：A 被 CRIU 冻结、内存页不在镜像里，
+// 失败时停掉 page-server 让 A 恢复运行，再 abort。
+//
+// abort 让 client 解除 armed peer（已 cutover 的切回 A），见 sWrapper 的 abort 控制命令。
+// restored 之后的失败（例如等待 client 重连超时）不回滚：服务已在 B 上运行。
+type migPhase int
+
+const (
+	phaseIdle migPhase = iota
+	phaseArmed
+	phaseStopped
+	phaseLazy
+	phaseRestored
+)
+
+func (p migPhase) String() string {
+	switch p {
+	case phaseIdle:
+		return "idle"
+	case phaseArmed:
+		return "armed"
+	case phaseStopped:
+		return "stopped"
+	case phaseLazy:
+		return "lazy"
+	case phaseRestored:
+		return "restored"
+	default:
+		return fmt.Sprintf("phase(%d)", int(p))
+	}
+}
+
+// rollbackError 表示迁移失败且已尝试回滚；errors.Unwrap 得到导致回滚的原始错误。
+type rollbackError struct {
+	Err   error
+	Phase migPhase
+	// Action 是回滚动作：abort（A 仍在运行）、resume-a（post-copy：停止 page-server 让 A 恢复运行）或 restore-a（A 从 dump 恢复）。
+	Action string
+	// Recovered 表示服务已回到 A（PID 为其进程）；RollbackErr 为回滚本身的失败。
+	Recovered   bool
+	PID         int
+	RollbackErr error
+	// Aborted/AbortFailed 是收到 abort 并 ACK / 未 ACK 的 client 数；AbortErr 为 abort 调用本身的失败。
+	Aborted     int
+	AbortFailed int
+	AbortErr    error
+}
+
+func (e *rollbackError) Error() string {
+	return fmt.Sprintf("%v（%s）", e.Err, e.outcome())
+}
+
+func (e *rollbackError) Unwrap() error { return e.Err }
+
+// outcome 是回滚结果的一行描述（日志与 daemon 报告共用）。
+func (e *rollbackError) outcome() string {
+	if !e.Recovered {
+		return fmt.Sprintf("回滚失败 phase=%s action=%s：%v", e.Phase, e.Action, e.RollbackErr)
+	}
+	if e.AbortErr != nil {
+		return fmt.Sprintf("已回滚 phase=%s action=%s：服务仍在 A pid=%d，abort 未送达：%v", e.Phase, e.Action, e.PID, e.AbortErr)
+	}
+	return fmt.Sprintf("已回滚 phase=%s action=%s：服务仍在 A pid=%d，abort acked=%d failed=%d", e.Phase, e.Action, e.PID, e.Aborted, e.AbortFailed)
+}
+
+// confirmSource 确认 A 中的服务进程仍然存活，并记到 re.PID。
+func confirmSource(cfg *controlConfig, re *rollbackError) error {
+	pid, err := sourcePID(cfg)
+	if err != nil {
+		return err
+	}
+	if err := sudoKill0(pid); err != nil {
+		return fmt.Errorf("source not alive: pid=%d err=%w", pid, err)
+	}
+	re.PID = pid
+	return nil
+}
+
+// rollback 按失败时所处阶段撤销迁移，返回包装后的错误（idle/restored 阶段原样返回 cause）。
+// 成功回滚后 cfg.srcPID 指向 A 中的服务进程，后续迁移可直接以它为源。
+func rollback(cfg *controlConfig, rec *stepRecorder, id string, phase migPhase, cause error) error {
+	if phase == phaseIdle || phase == phaseRestored {
+		return cause
+	}
+	re := &rollbackError{Err: cause, Phase: phase}
+
+	switch phase {
+	case phaseArmed:
+		re.Action = "abort"
+		re.RollbackErr = rec.run("回滚：确认 A 存活", func() error {
+			return confirmSource(cfg, re)
+		})
+
+	case phaseLazy:
+		re.Action = "resume-a"
+		re.RollbackErr = rec.run("回滚：停止 page-server，恢复 A", func() error {
+			cfg.lazy.Abort()
+			return confirmSource(cfg, re)
+		})
+
+	case phaseStopped:
+		re.Action = "restore-a"
+		re.RollbackErr = rec.run("回滚：A 从 dump 恢复", func() error {
+			_ = sudoKill(cfg.aInitPID, syscall.SIGKILL)
+			if _, err := cfg.rt.CreateShell(cfg.spec(cfg.aName, cfg.srcPort)); err != nil {
+				return fmt.Errorf("recreate %s: %w", cfg.aName, err)
+			}
+			pid, err := restoreInto(cfg, cfg.aName, cfg.srcPort)
+			if err != nil {
+				return err
+			}
+			re.PID = pid
+			return nil
+		})
+	}
+
+	if re.RollbackErr == nil {
+		re.Recovered = true
+		cfg.srcPID = re.PID
+		// abort 失败不影响“服务在 A 上”这一事实，只记录未确认的 client 数。
+		re.AbortErr = rec.run("回滚：通知 client abort", func() error {
+			res, err := abortMigrate(cfg, re.PID, id)
+			if err != nil {
+				return err
+			}
+			re.Aborted, re.AbortFailed = res.Acked, res.Failed
+			return nil
+		})
+	}
+	fmt.Fprintf(os.Stderr, "[控制端] 迁移失败 id=%s：%s\n", id, re.outcome())
+	return re
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// 回滚状态机：fake 运行时（本地进程）+ sim 检查点，不需要 podman、CRIU 或 root。
+// 没有 sudo 时用一个直接执行命令的 shim 代替（fake 实例都属于当前用户）。
+
+var (
+	serverBinOnce sync.Once
+	serverBin     string
+	serverBinErr  error
+)
+
+// testServerBin 构建一次 Server/APP，并在缺少 sudo 时把 shim 放到 PATH 前面。
+func testServerBin(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds and runs server processes")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("fake runtime needs /proc")
+	}
+	serverBinOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "wrapper-test-bin")
+		if err != nil {
+			serverBinErr = err
+			return
+		}
+		serverBin = filepath.Join(dir, "server_bin")
+		out, err := exec.Command("go", "build", "-o", serverBin, "github.com/Liangxia6/Wrapper/Server/APP").CombinedOutput()
+		if err != nil {
+			serverBinErr = errors.New(string(out))
+			return
+		}
+		if exec.Command("sudo", "-n", "true").Run() != nil {
+			shim := filepath.Join(dir, "sudo")
+			if serverBinErr = os.WriteFile(shim, []byte("#!/bin/sh\nexec \"$@\"\n"), 0o755); serverBinErr != nil {
+				return
+			}
+			os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		}
+	})
+	if serverBinErr != nil {
+		t.Fatalf("build server: %v", serverBinErr)
+	}
+	return serverBin
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if serverBin != "" {
+		_ = os.RemoveAll(filepath.Dir(serverBin))
+	}
+	os.Exit(code)
+}
+
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func running(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// killInstance 结束 pid，并等 fake 运行时把它回收（之后 kill -0 失败，与源进程真正退出时一致）。
+func killInstance(t *testing.T, pid int) {
+	t.Helper()
+	if err := sudoKill(pid, syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); running(pid); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pid %d not reaped after kill", pid)
+		}
+	}
+}
+
+// newRollbackInstance 启动 A(源) 与 B(壳)，检查点用 sim。
+func newRollbackInstance(t *testing.T) *controlConfig {
+	t.Helper()
+	bin := testServerBin(t)
+	// 控制端点在 stateDir 下、经 /proc/<pid>/root 访问：unix socket 路径有长度限制，不用 t.TempDir()。
+	stateDir, err := os.MkdirTemp("", "wrt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &fakeRuntime{serverBin: bin, stateDir: stateDir}
+	cfg := &controlConfig{
+		imgDir:     t.TempDir(),
+		aName:      "a",
+		bName:      "b",
+		srcPort:    freeUDPPort(t),
+		dstPort:    freeUDPPort(t),
+		ctlSocket:  wrapper.DefaultControlSocket,
+		ctlTimeout: 5 * time.Second,
+		rt:         rt,
+	}
+	cfg.ckpt = simCheckpointer{rt: rt, ctlSocket: cfg.ctlSocket, ctlTimeout: cfg.ctlTimeout}
+	t.Cleanup(func() {
+		_ = rt.Remove(cfg.aName)
+		_ = rt.Remove(cfg.bName)
+		_ = os.RemoveAll(stateDir)
+	})
+	startA(cfg)
+	startB(cfg)
+	return cfg
+}
+
+// TestRollback 对每个阶段调用 rollback：armed 确认 A 后 abort，stopped 从 dump 把 A 恢复出来，
+// idle/restored 不回滚、原样返回错误。
+func TestRollback(t *testing.T) {
+	const (
+		confirmA = "回滚：确认 A 存活"
+		restoreA = "回滚：A 从 dump 恢复"
+		abortA   = "回滚：通知 client abort"
+	)
+	cause := errors.New("step failed")
+	cases := []struct {
+		name  string
+		phase migPhase
+		// setup 把实例带到该阶段失败时的状态（例如 dump 后已停止 A）。
+		setup func(t *testing.T, cfg *controlConfig)
+
+		steps     []string
+		action    string
+		recovered bool
+		// moved 表示恢复后服务进程不再是原来的 A（从 dump 恢复）。
+		moved bool
+	}{
+		{name: "idle", phase: phaseIdle},
+		{name: "armed", phase: phaseArmed, steps: []string{confirmA, abortA}, action: "abort", recovered: true},
+		{
+			name: "armed, source dead", phase: phaseArmed,
+			setup: func(t *testing.T, cfg *controlConfig) {
+				killInstance(t, cfg.aInitPID)
+			},
+			steps: []string{confirmA}, action: "abort",
+		},
+		{
+			name: "stopped", phase: phaseStopped,
+			setup: func(t *testing.T, cfg *controlConfig) {
+				if _, err := cfg.ckpt.Dump(checkpointReq{PID: cfg.aInitPID, Dir: cfg.imgDir, WorkDir: cfg.imgDir}); err != nil {
+					t.Fatal(err)
+				}
+				killInstance(t, cfg.aInitPID)
+			},
+			steps: []string{restoreA, abortA}, action: "restore-a", recovered: true, moved: true,
+		},
+		{
+			name: "stopped, no dump", phase: phaseStopped,
+			setup: func(t *testing.T, cfg *controlConfig) { killInstance(t, cfg.aInitPID) },
+			steps: []string{restoreA}, action: "restore-a",
+		},
+		{name: "restored", phase: phaseRestored},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newRollbackInstance(t)
+			srcPID := cfg.aInitPID
+			if tc.setup != nil {
+				tc.setup(t, cfg)
+			}
+			rec := &stepRecorder{}
+			err := rollback(cfg, rec, "m-1", tc.phase, cause)
+
+			var steps []string
+			for _, s := range rec.snapshot() {
+				steps = append(steps, s.Name)
+			}
+			if strings.Join(steps, ",") != strings.Join(tc.steps, ",") {
+				t.Errorf("steps %q, want %q", steps, tc.steps)
+			}
+			if !errors.Is(err, cause) {
+				t.Fatalf("rollback error %v does not wrap the cause", err)
+			}
+			var re *rollbackError
+			if tc.action == "" {
+				if errors.As(err, &re) {
+					t.Fatalf("phase %s rolled back: %v", tc.phase, err)
+				}
+				return
+			}
+			if !errors.As(err, &re) {
+				t.Fatalf("want *rollbackError, got %v", err)
+			}
+			if re.Phase != tc.phase || re.Action != tc.action || re.Recovered != tc.recovered {
+				t.Fatalf("rollback phase=%s action=%s recovered=%v, want %s/%s/%v (%v)",
+					re.Phase, re.Action, re.Recovered, tc.phase, tc.action, tc.recovered, re.RollbackErr)
+			}
+			if !tc.recovered {
+				if re.RollbackErr == nil {
+					t.Error("unrecovered rollback without RollbackErr")
+				}
+				return
+			}
+			if re.AbortErr != nil {
+				t.Errorf("abort: %v", re.AbortErr)
+			}
+			if !running(re.PID) || cfg.srcPID != re.PID {
+				t.Fatalf("service pid %d (srcPID %d) after rollback", re.PID, cfg.srcPID)
+			}
+			if moved := re.PID != srcPID; moved != tc.moved {
+				t.Errorf("service pid %d, source %d, moved=%v want %v", re.PID, srcPID, moved, tc.moved)
+			}
+			if err := waitCtlReady(cfg.ctlSocket, re.PID, cfg.ctlTimeout); err != nil {
+				t.Errorf("service in A: %v", err)
+			}
+		})
+	}
+}
@@ -1,18 +1,19 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
 )
 
 type controlConfig struct {
@@ -34,6 +35,10 @@ type controlConfig struct {
 	aInitPID    int
 	restoredPID int
 
+	// srcPID 非 0 时作为迁移源进程 PID（例如上一次迁移 restore 出来的进程），
+	// 否则从运行时取 A 的 init PID。
+	srcPID int
+
 	// Incremental pre-copy (CRIU pre-dump) settings.
 	//
 	// predumpRounds：
@@ -47,8 +52,88 @@ type controlConfig struct {
 	predumpRounds  int
 	predumpLastDir string
 
-	// scheme2: out-of-band commit notify address (client listens on UDP).
+	// 自适应 pre-dump（predump.go）：按每轮脏页数决定何时停止，predumpRounds 不再生效。
+	predumpAdaptive  bool
+	predumpMaxRounds int
+	predumpThreshold int64
+	predumpMinShrink float64
+	predumpBudget    time.Duration
+
+	// 迁移模式（lazy.go）：precopy | postcopy | hybrid；lazyPort 是 post-copy 源端 page-server 端口。
+	// lazy 是进行中的 post-copy（lazy dump 成功后设置，doMigrate 开始时清空）。
+	mode     string
+	lazyPort int
+	lazy     lazyDump
+
+	// 内存页经 criu page-server 流向目标侧（pageserver.go）。
+	pageServer     bool
+	pageServerPort int
+	pageServerAddr string
+
+	// scheme2: out-of-band commit notify address (client listens on UDP). Empty disables it;
+	// the restored server now sends commit in-band on the control stream after rebind.
 	commitAddr string
+
+	// migrateTo 是本次迁移推送给 client 的目标（host:port）；为空时默认 127.0.0.1:<dst-port>。
+	migrateTo string
+
+	// 跨主机迁移与常驻模式：
+	//   - remoteAgent：目标主机上 Control agent 的地址；非空时镜像经 TCP 传输，由 agent restore。
+	//   - listenAddr：`control agent`/`control serve` 的监听地址。
+	//   - agentStartShell：是否由 agent 自己启动 B；
+	//   - agentToken：源端与 agent 共享的令牌（transfer.go 的 begin 消息）。
+	remoteAgent     string
+	listenAddr      string
+	agentStartShell bool
+	agentToken      string
+
+	// 容器内 sWrapper 本地控制端点（Unix socket）路径与单次调用超时。
+	ctlSocket  string
+	ctlTimeout time.Duration
+
+	// rt 是实例的运行时后端（--runtime=podman|fake）。
+	rt Runtime
+	// ckpt 是检查点后端（--checkpointer=criu|sim）。
+	ckpt Checkpointer
+
+	// 迁移报告（report.go）：
+	//   - reportDir：报告目录（--report-dir）；
+	//   - jsonOut：--json 时为 stdout，报告输出到这里；
+	//   - out：人类可读的日志（步骤、进度、汇总），默认 stdout，--json 时为 stderr；
+	//   - report：本次迁移的报告，doMigrate 开始时创建。
+	reportDir string
+	clientOut string
+	jsonOut   *os.File
+	out       io.Writer
+	report    *reportBuilder
+
+	// control bench（bench.go）：迁移次数、输出格式（csv|json）与文件、每次迁移后的间隔、client ping 间隔。
+	benchIterations   int
+	benchFormat       string
+	benchOut          string
+	benchSettle       time.Duration
+	benchPingInterval time.Duration
+
+	// 壳容器池（shellpool.go）：serve/bench 每次迁移从池中取目标壳；pool 在各实例的配置副本间共享。
+	poolSize  int
+	poolPorts string
+	pool      *shellPool
+
+	// 多实例（instances.go）：instanceName 为本配置所属实例（单个 A/B 时为空）；
+	// instances/instancePrefix 是 up/down 的实例数与名字前缀，args 是 migrate 的实例名；
+	// maxParallel 限制同时进行的迁移数（migrate 多个实例与 serve）。
+	instanceName   string
+	slot           int
+	instances      int
+	instancePrefix string
+	maxParallel    int
+	args           []string
+
+	// 状态库（state.go）：实例记录与迁移日志；bPooled 表示 bName 是本次迁移从壳池取出的壳。
+	stateDir     string
+	store        *stateStore
+	bPooled      bool
+	historyLimit int
 }
 
 func mountIfExists(args []string, hostPath, containerPath, mode string) []string {
@@ -67,12 +152,12 @@ func mountIfExists(args []string, hostPath, containerPath, mode string) []string
 // 这是 server 控制层（Control Layer）在 PoC/MVP 阶段的“单机编排器”。
 // 它复刻 container_pro 的 injectctl run：
 // - build server/client
-// - podman 起 A(源) + B(壳)
+// - 运行时（默认 podman）起 A(源) + B(壳)
 // - 起 client
-// - SIGTERM 触发 migrate
+// - 控制端点 prepare-migrate 触发 migrate
 // - CRIU dump -> kill A
 // - nsenter 到 B restore
-// - SIGUSR2 让服务 rebind
+// - 控制端点 rebind（失败时退回 SIGUSR2）让服务 rebind
 // - 汇总：客户端感知服务中断时间
 
 func parseCommonFlags(cmd string, args []string) *controlConfig {
@@ -85,13 +170,57 @@ func parseCommonFlags(cmd string, args []string) *controlConfig {
 	fs.StringVar(&cfg.imageName, "image", "wrapper-pingserver-criu", "server 镜像名")
 	fs.IntVar(&cfg.srcPort, "src-port", 5242, "A 对外暴露的 host UDP 端口")
 	fs.IntVar(&cfg.dstPort, "dst-port", 5243, "B 对外暴露的 host UDP 端口")
-	fs.StringVar(&cfg.commitAddr, "commit-addr", "127.0.0.1:7360", "方案2：client 侧 commit 通道监听地址(udp)，B restore+rebind 后由 Control 发送 commit")
+	fs.StringVar(&cfg.commitAddr, "commit-addr", "", "旧方案：client 侧带外 commit 通道地址(udp)；为空则不发送（commit 已由 B 经控制流下发）")
 	criuHostBin := ""
 	fs.StringVar(&criuHostBin, "criu-host-bin", "", "host 上 criu 可执行文件路径")
 	fs.BoolVar(&cfg.verbose, "verbose", false, "打印更多执行细节")
 	fs.BoolVar(&cfg.noCleanup, "no-cleanup", false, "失败时不清理容器")
 	fs.IntVar(&cfg.predumpRounds, "predump-rounds", 2, "迁移前执行 pre-dump 轮数（0=关闭；建议>=1用于大内存）")
+	fs.BoolVar(&cfg.predumpAdaptive, "predump-adaptive", false, "按每轮脏页数自适应决定 pre-dump 轮数（忽略 --predump-rounds）")
+	fs.IntVar(&cfg.predumpMaxRounds, "predump-max-rounds", 8, "自适应 pre-dump：最多轮数（0=关闭）")
+	fs.Int64Var(&cfg.predumpThreshold, "predump-threshold-pages", 2048, "自适应 pre-dump：本轮脏页数低于该值即停止（4KiB 页）")
+	fs.Float64Var(&cfg.predumpMinShrink, "predump-min-shrink", 0.2, "自适应 pre-dump：脏页数较上一轮缩小不到该比例即停止")
+	fs.DurationVar(&cfg.predumpBudget, "predump-budget", 10*time.Second, "自适应 pre-dump：总时间预算（0=不限）")
+	fs.StringVar(&cfg.mode, "mode", modePrecopy, "迁移模式：precopy | postcopy（dump --lazy-pages，内存页在 restore 后按缺页拉取）| hybrid（1 轮 pre-dump + postcopy）")
+	fs.IntVar(&cfg.lazyPort, "lazy-port", 27027, "postcopy/hybrid：源端 page-server 的 TCP 端口")
+	fs.BoolVar(&cfg.pageServer, "page-server", false, "pre-dump/dump 的内存页经目标侧 criu page-server 直接传输，不写入源端镜像目录")
+	fs.IntVar(&cfg.pageServerPort, "page-server-port", 27028, "page-server 的 TCP 端口")
+	fs.StringVar(&cfg.pageServerAddr, "page-server-addr", "", "源端连接的 page-server 主机（默认同机 127.0.0.1，跨主机为 --remote-agent 的主机）")
+	fs.StringVar(&cfg.migrateTo, "to", "", "迁移目标 host:port（推送给 client；默认 127.0.0.1:<dst-port>）")
+	fs.StringVar(&cfg.remoteAgent, "remote-agent", "", "跨主机迁移：目标主机 Control agent 地址(host:port)；为空表示同机共享 img-dir")
+	fs.StringVar(&cfg.listenAddr, "listen", "", "agent/serve：监听地址(tcp)")
+	fs.BoolVar(&cfg.agentStartShell, "start-shell", false, "agent：启动时自行创建 B(壳)")
+	fs.StringVar(&cfg.agentToken, "agent-token", os.Getenv("WRAPPER_AGENT_TOKEN"), "跨主机迁移：源端与 agent 共享的令牌（默认取环境变量 WRAPPER_AGENT_TOKEN）")
+	fs.StringVar(&cfg.ctlSocket, "ctl-socket", wrapper.DefaultControlSocket, "容器内 sWrapper 控制端点路径(unix socket)")
+	fs.DurationVar(&cfg.ctlTimeout, "ctl-timeout", 5*time.Second, "单次控制端点调用超时")
+	runtimeKind := ""
+	fs.StringVar(&runtimeKind, "runtime", "podman", "实例运行时：podman | fake（本地进程，无需容器）")
+	ckptKind := ""
+	fs.StringVar(&ckptKind, "checkpointer", "criu", "检查点后端：criu | sim（save-state + 在壳里重启，无需 CRIU）")
+	fs.StringVar(&cfg.clientOut, "client-out", "", "migrate：client 输出文件（run.sh 的 client.out），从中读取服务中断时间写进报告")
+	fs.StringVar(&cfg.reportDir, "report-dir", "", "迁移报告(JSON)目录（默认 <工作目录>/reports）")
+	jsonOut := false
+	fs.BoolVar(&jsonOut, "json", false, "迁移报告输出到 stdout（其余日志改走 stderr）")
+	fs.IntVar(&cfg.benchIterations, "iterations", 10, "bench：A/B 之间来回迁移的次数")
+	fs.StringVar(&cfg.benchFormat, "format", "csv", "bench：汇总格式 csv | json")
+	fs.StringVar(&cfg.benchOut, "out", "", "bench：汇总同时写入该文件")
+	fs.DurationVar(&cfg.benchSettle, "settle", time.Second, "bench：每次迁移后等待多久再开始下一次")
+	fs.DurationVar(&cfg.benchPingInterval, "ping-interval", 20*time.Millisecond, "bench：client ping 间隔（服务中断的测量精度）")
+	fs.IntVar(&cfg.poolSize, "pool-size", 0, "serve/bench：预先启动的壳容器数，每次迁移从池中取目标（0=关闭，使用固定的 B）")
+	fs.StringVar(&cfg.poolPorts, "pool-ports", "5300-5399", "serve/bench：壳池使用的 host UDP 端口范围 lo-hi")
+	fs.IntVar(&cfg.instances, "instances", 0, "up/down：创建/删除 N 个实例 <prefix>1..N（一车一实例，端口按 2×(i-1) 递增；0=单个 A/B）")
+	fs.StringVar(&cfg.instancePrefix, "instance-prefix", "v", "多实例：实例名前缀")
+	fs.IntVar(&cfg.maxParallel, "max-parallel", 2, "migrate/serve：同时进行的迁移数上限")
+	fs.StringVar(&cfg.stateDir, "state-dir", "", "状态库目录：实例记录与迁移日志（默认 <工作目录>/state）")
+	fs.IntVar(&cfg.historyLimit, "limit", 20, "history：最多显示的迁移记录数（0=全部）")
 	_ = fs.Parse(args)
+	cfg.args = fs.Args()
+
+	cfg.out = os.Stdout
+	if jsonOut {
+		cfg.jsonOut = os.Stdout
+		cfg.out = os.Stderr
+	}
 
 	wd, err := os.Getwd()
 	if err != nil {
@@ -100,13 +229,65 @@ func parseCommonFlags(cmd string, args []string) *controlConfig {
 	cfg.workDir = wd
 	cfg.goBin = mustPickGoBin()
 	cfg.clientLog = filepath.Join(wd, "client.log")
+	if cfg.reportDir == "" {
+		cfg.reportDir = filepath.Join(wd, "reports")
+	}
+	if cfg.stateDir == "" {
+		cfg.stateDir = filepath.Join(wd, "state")
+	}
 
-	criuHost, err := pickCRIUHostBin(criuHostBin)
+	rt, err := newRuntime(runtimeKind, cfg)
 	if err != nil {
+		dief("runtime: %v", err)
+	}
+	cfg.rt = rt
+
+	// sim 不需要 CRIU；找不到时 criuHost 留空，podman 壳也就不挂载 /hostbin。
+	criuHost, err := pickCRIUHostBin(criuHostBin)
+	if err != nil && (ckptKind == "" || ckptKind == "criu") {
 		dief("missing dependency: criu (host): %v", err)
 	}
-	cfg.criuHost = criuHost
-	cfg.criuInB = filepath.Join("/hostbin", filepath.Base(criuHost))
+	if err == nil {
+		cfg.criuHost = criuHost
+		cfg.criuInB = rt.CRIUPath(criuHost)
+	}
+
+	ckpt, err := newCheckpointer(ckptKind, cfg)
+	if err != nil {
+		dief("checkpointer: %v", err)
+	}
+	cfg.ckpt = ckpt
+
+	switch cfg.mode {
+	case modePrecopy:
+	case modePostcopy, modeHybrid:
+		if _, ok := ckpt.(lazyCheckpointer); !ok {
+			dief("--mode=%s: checkpointer %s does not support lazy pages", cfg.mode, ckpt.Name())
+		}
+		if cfg.remoteAgent != "" {
+			dief("--mode=%s: cross-host migration (--remote-agent) is not supported yet", cfg.mode)
+		}
+	default:
+		dief("unknown --mode %q (precopy | postcopy | hybrid)", cfg.mode)
+	}
+	if cfg.remoteAgent != "" && cfg.agentToken == "" {
+		dief("--remote-agent requires --agent-token (or WRAPPER_AGENT_TOKEN)")
+	}
+	if cfg.poolSize > 0 && cfg.remoteAgent != "" {
+		dief("--pool-size: the target shell of a cross-host migration is managed by the agent")
+	}
+	if cfg.pageServer {
+		if ckpt.Name() != "criu" {
+			dief("--page-server requires --checkpointer criu")
+		}
+		if cfg.mode == modePostcopy {
+			dief("--page-server: postcopy already streams pages through the lazy-pages page-server")
+		}
+		if cfg.predumpAdaptive {
+			// 页写到目标侧，源端目录里没有 pages-*.img，自适应判断拿不到脏页数。
+			dief("--predump-adaptive cannot be used with --page-server (dirty pages are not written to the source image dir)")
+		}
+	}
 
 	return cfg
 }
@@ -145,109 +326,239 @@ func buildSkipMntArgs(imgDir string) []string {
 	return skipArgs
 }
 
-func cleanContainers(aName, bName string) {
-	_ = exec.Command("sudo", "podman", "rm", "-f", aName).Run()
-	_ = exec.Command("sudo", "podman", "rm", "-f", bName).Run()
+// logOut 返回人类可读日志的去向（cfg.out；未设置时为 stdout）。
+func (cfg *controlConfig) logOut() io.Writer {
+	if cfg.out == nil {
+		return os.Stdout
+	}
+	return cfg.out
+}
+
+func (cfg *controlConfig) logf(format string, args ...any) {
+	fmt.Fprintf(cfg.logOut(), format, args...)
+}
+
+func printCheckpoint(cfg *controlConfig, what string, res checkpointResult) {
+	cfg.logf("[控制端] %s：files=%d bytes=%d took=%dms\n", what, res.Files, res.Bytes, res.Took.Milliseconds())
+}
+
+func cleanContainers(rt Runtime, aName, bName string) {
+	_ = rt.Remove(aName)
+	_ = rt.Remove(bName)
 }
 
 func buildAndImage(cfg *controlConfig) {
-	step("构建：编译 + 镜像", func() error {
-		if err := goBuildStatic(cfg.verbose, cfg.workDir, cfg.goBin, filepath.Join(cfg.workDir, "Server", "server_bin"), "./Server/APP"); err != nil {
+	step(cfg, "构建：编译 + 镜像", func() error {
+		if err := goBuildStatic(cfg.verbose, cfg.logOut(), cfg.workDir, cfg.goBin, filepath.Join(cfg.workDir, "Server", "server_bin"), "./Server/APP"); err != nil {
 			return err
 		}
-		if err := goBuildStatic(cfg.verbose, cfg.workDir, cfg.goBin, filepath.Join(cfg.workDir, "Client", "client_bin"), "./Client/APP"); err != nil {
+		if err := goBuildStatic(cfg.verbose, cfg.logOut(), cfg.workDir, cfg.goBin, filepath.Join(cfg.workDir, "Client", "client_bin"), "./Client/APP"); err != nil {
 			return err
 		}
-		return runQuiet("sudo", "podman", "build", "-t", cfg.imageName, filepath.Join(cfg.workDir, "Server"))
+		return cfg.rt.BuildImage(filepath.Join(cfg.workDir, "Server"), cfg.imageName)
 	})
 }
 
-func prepareImgDir(imgDir string) {
-	step("准备：镜像目录", func() error {
-		if err := runQuiet("sudo", "rm", "-rf", imgDir); err != nil {
+func prepareImgDir(cfg *controlConfig) {
+	step(cfg, "准备：镜像目录", func() error {
+		if err := runQuiet("sudo", "rm", "-rf", cfg.imgDir); err != nil {
 			return err
 		}
-		return runQuiet("sudo", "mkdir", "-p", imgDir)
+		return runQuiet("sudo", "mkdir", "-p", cfg.imgDir)
 	})
 }
 
+// restoreIntoB 在 B 中恢复镜像（CRIU：nsenter restore；sim：在壳里重启），并让恢复出来的进程 rebind。
+// 成功后 cfg.restoredPID 为恢复出的 PID。同机迁移与跨主机 agent 共用。
+func restoreIntoB(cfg *controlConfig) error {
+	pid, err := restoreInto(cfg, cfg.bName, cfg.dstPort)
+	if err != nil {
+		return err
+	}
+	cfg.restoredPID = pid
+	return nil
+}
+
+// restoreInto 把 cfg.imgDir 中的镜像恢复到壳 shell（host 端口 port）中，返回恢复出的 PID。
+// rebind 失败时结束恢复出的进程，避免留下一个收不到包的副本。
+func restoreInto(cfg *controlConfig, shell string, port int) (int, error) {
+	// 壳的 PID 可能变化，实时从运行时拿。
+	pid, err := cfg.rt.PID(shell)
+	if err != nil {
+		return 0, err
+	}
+	if shell == cfg.bName {
+		cfg.bInitPID = pid
+	}
+	netns, err := cfg.rt.NetNS(shell)
+	if err != nil {
+		return 0, err
+	}
+
+	phase := "restore"
+	if shell != cfg.bName {
+		phase = "rollback-restore"
+	}
+	start := time.Now()
+	req := checkpointReq{
+		Dir: cfg.imgDir, WorkDir: cfg.imgDir,
+		Shell: cfg.spec(shell, port), ShellPID: pid, NetNS: netns,
+	}
+	var res checkpointResult
+	if cfg.lazy != nil && shell == cfg.bName {
+		res, err = cfg.lazy.Restore(req)
+	} else {
+		res, err = cfg.ckpt.Restore(req)
+	}
+	cfg.report.checkpoint(phase, "restore", 0, start, res, err)
+	if err != nil {
+		return 0, err
+	}
+	printCheckpoint(cfg, "restore", res)
+	if !res.Rebind {
+		return res.PID, nil
+	}
+	if err := rebindRestored(cfg, res.PID, shell); err != nil {
+		_ = sudoKill(res.PID, syscall.SIGKILL)
+		return 0, err
+	}
+	return res.PID, nil
+}
+
 func startA(cfg *controlConfig) {
-	step("启动：A(源)", func() error {
-		_ = runQuiet("sudo", "podman", "rm", "-f", cfg.aName)
-		args := []string{
-			"podman", "run", "-d", "--privileged", "--name", cfg.aName, "--pid=host",
-			"-p", fmt.Sprintf("%d:4242/udp", cfg.srcPort),
-			"-v", fmt.Sprintf("%s:%s:rw", cfg.imgDir, cfg.imgDir),
-			"-e", "MIGRATE_ADDR=127.0.0.1",
-			"-e", fmt.Sprintf("MIGRATE_PORT=%d", cfg.dstPort),
-			"-e", "QUIET=1",
-			cfg.imageName,
-		}
-		if err := runQuiet("sudo", args...); err != nil {
-			return err
-		}
-		pid, err := podmanStatePID(cfg.aName)
+	step(cfg, "启动：A(源)", func() error {
+		pid, err := cfg.rt.StartInstance(cfg.spec(cfg.aName, cfg.srcPort))
 		if err != nil {
 			return err
 		}
 		cfg.aInitPID = pid
+		// 等 server 就绪，避免紧接着的 prepare-migrate 连到上一次残留的 socket 文件。
+		if err := waitCtlReady(cfg.ctlSocket, pid, cfg.ctlTimeout); err != nil {
+			fmt.Fprintf(os.Stderr, "[控制端] 警告：%v\n", err)
+		}
 		return nil
 	})
 }
 
 func startB(cfg *controlConfig) {
-	step("启动：B(壳)", func() error {
-		_ = runQuiet("sudo", "podman", "rm", "-f", cfg.bName)
-
-		args := []string{
-			"podman", "run", "-d", "--privileged", "--name", cfg.bName, "--pid=host",
-			"-p", fmt.Sprintf("%d:4242/udp", cfg.dstPort),
-			"-v", fmt.Sprintf("%s:%s:rw", cfg.imgDir, cfg.imgDir),
-			"--entrypoint", "sleep",
+	step(cfg, "启动：B(壳)", func() error {
+		pid, err := cfg.rt.CreateShell(cfg.spec(cfg.bName, cfg.dstPort))
+		if err != nil {
+			return err
 		}
+		cfg.bInitPID = pid
+		return nil
+	})
+}
 
-		// 将 host 上 criu 的所在目录挂进容器，避免假设 /usr/local/sbin。
-		hostBinDir := filepath.Dir(cfg.criuHost)
-		args = mountIfExists(args, hostBinDir, "/hostbin", "ro")
+// recycleSource 在迁移成功后交换源/壳角色（服务已运行在壳里），并把原源容器重建为新的壳，
+// 这样同一实例可以继续下一次迁移（daemon 与 bench 共用）。
+func recycleSource(c *controlConfig, rec *stepRecorder) error {
+	c.aName, c.bName = c.bName, c.aName
+	c.srcPort, c.dstPort = c.dstPort, c.srcPort
+	c.srcPID = c.restoredPID
+	c.migrateTo = ""
+	c.bPooled = false
+	if c.pool != nil {
+		// 开池时原 A 在后台重建为壳并归还池中，下一次迁移再从池中取目标。
+		return rec.run("回收：原 A 归还壳池", func() error {
+			c.pool.recycle(c.bName, c.dstPort)
+			c.bName, c.dstPort, c.bInitPID = "", 0, 0
+			c.bPooled = false
+			return nil
+		})
+	}
+	return rec.run("回收：原 A 重建为壳", func() error {
+		return tryStep(func() { startB(c) })
+	})
+}
 
-		// criu/loader 依赖的动态库：不同发行版路径不同，按存在性选择性挂载。
-		args = mountIfExists(args, "/lib64", "/lib64", "ro")
-		args = mountIfExists(args, "/usr/lib64", "/usr/lib64", "ro")
-		args = mountIfExists(args, "/lib/x86_64-linux-gnu", "/lib/x86_64-linux-gnu", "ro")
-		args = mountIfExists(args, "/usr/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu", "ro")
+// sourcePID 返回迁移源进程的 PID。A 的 PID 可能变化，未指定 srcPID 时实时从运行时拿。
+func sourcePID(cfg *controlConfig) (int, error) {
+	if cfg.srcPID > 0 {
+		return cfg.srcPID, nil
+	}
+	return cfg.rt.PID(cfg.aName)
+}
 
-		args = append(args, cfg.imageName, "infinity")
+// doMigrate 执行一次迁移。id 贯穿 client 消息、报告与日志；调用方没有现成 ID（为空）时新建一个。
+func doMigrate(cfg *controlConfig, id string, clientObs *clientObserver, rec *stepRecorder) (err error) {
+	if id == "" {
+		id = newMigrationID()
+	}
+	cfg.report = newReport(id, cfg)
+	cfg.lazy = nil
+	journal := cfg.store.begin(id, cfg)
+	defer func() {
+		cfg.report.finish(rec, cfg.restoredPID, err)
+		journal.finish(cfg, err)
+	}()
 
-		if err := runQuiet("sudo", args...); err != nil {
+	// phase 记录迁移推进到哪一步，失败时据此回滚（见 rollback.go）。
+	phase := phaseIdle
+
+	// 跨主机：镜像经 TCP 推给目标主机 agent；pre-dump 目录在下一轮运行时并行传输。
+	var xfer *imgSender
+	predumpDirs := map[string]bool{}
+	if cfg.remoteAgent != "" {
+		if err := rec.run("连接：目标 agent", func() error {
+			if cfg.migrateTo == "" {
+				fmt.Fprintln(os.Stderr, "[控制端] 警告：跨主机迁移未指定 --to，client 将被指向 127.0.0.1")
+			}
+			s, err := dialAgent(cfg.remoteAgent, cfg.imgDir, id, cfg.agentToken)
+			if err != nil {
+				return err
+			}
+			xfer = s
+			return nil
+		}); err != nil {
 			return err
 		}
-		pid, err := podmanStatePID(cfg.bName)
+		defer xfer.close()
+	}
+
+	// --page-server：每一轮 pre-dump/dump 之前在目标侧为该轮目录启动 page-server。
+	// rel 为相对 imgDir 的目录，parent 为上一轮目录（相对 rel）。
+	var pages *pageServerHost
+	var pageTarget string
+	openPages := func(rel, parent string) error { return nil }
+	if cfg.pageServer {
+		t, err := pageServerTarget(cfg)
 		if err != nil {
 			return err
 		}
-		cfg.bInitPID = pid
-		return nil
-	})
-}
-
-func doMigrate(cfg *controlConfig, clientObs *clientObserver) {
-	skipArgs := buildSkipMntArgs(cfg.imgDir)
+		pageTarget = t
+		if xfer != nil {
+			openPages = func(rel, parent string) error { return xfer.pageServer(rel, parent, cfg.pageServerPort) }
+		} else {
+			pages = &pageServerHost{cfg: cfg}
+			defer pages.stop()
+			openPages = func(rel, parent string) error {
+				return pages.start(filepath.Join(cfg.imgDir, rel), parent, "127.0.0.1", cfg.pageServerPort)
+			}
+		}
+		cfg.report.pageServer(pageTarget)
+	}
 
-	step("预拷贝：pre-dump(A)", func() error {
-		if cfg.predumpRounds <= 0 {
+	if err := rec.run("预拷贝：pre-dump(A)", func() error {
+		pd := newPredumpController(cfg)
+		if !pd.enabled() {
 			cfg.predumpLastDir = ""
 			return nil
 		}
+		mode := predumpFixed
+		if pd.adaptive {
+			mode = "adaptive"
+		}
 
-		// A 的 PID 可能变化，实时从 podman 拿。
-		pid, err := podmanStatePID(cfg.aName)
+		pid, err := sourcePID(cfg)
 		if err != nil {
 			return err
 		}
 		cfg.aInitPID = pid
 
 		cfg.predumpLastDir = ""
-		for i := 0; i < cfg.predumpRounds; i++ {
+		for i := 0; ; i++ {
 			dirName := fmt.Sprintf("pd-%d", i)
 			imgSubdir := filepath.Join(cfg.imgDir, dirName)
 			_ = runQuiet("sudo", "rm", "-rf", imgSubdir)
@@ -255,39 +566,61 @@ func doMigrate(cfg *controlConfig, clientObs *clientObserver) {
 				return err
 			}
 
-			// 这里使用的 CRIU pre-dump 关键参数：
-			//   - --leave-running：不停止进程（即“预拷贝”阶段）。
-			//   - --track-mem：启用脏页跟踪，为增量/多轮 pre-dump 做基础。
-			//   - --prev-images-dir（从第 2 轮开始）：引用上一轮镜像目录，形成增量链。
-			//   - --empty-ns net + --manage-cgroups=ignore：容器 PoC 的务实配置。
-			args := []string{cfg.criuHost, "pre-dump", "-t", strconv.Itoa(cfg.aInitPID), "-D", imgSubdir, "-W", cfg.imgDir,
-				"--shell-job", "--leave-running", "--empty-ns", "net", "--manage-cgroups=ignore", "--track-mem",
-			}
+			req := checkpointReq{PID: cfg.aInitPID, Dir: imgSubdir, WorkDir: cfg.imgDir, Round: i, PageServer: pageTarget}
 			if i > 0 {
-				// NOTE: --prev-images-dir is relative to -D. Our image dirs are siblings under cfg.imgDir.
-				args = append(args, "--prev-images-dir", fmt.Sprintf("../pd-%d", i-1))
+				req.Parent = fmt.Sprintf("../pd-%d", i-1)
 			}
-			args = append(args, append(skipArgs, "-o", fmt.Sprintf("pre-dump-%d.log", i), "-v4")...)
-
-			if err := runQuiet("sudo", args...); err != nil {
+			start := time.Now()
+			var res checkpointResult
+			err := openPages(dirName, req.Parent)
+			if err == nil {
+				res, err = cfg.ckpt.PreDump(req)
+			}
+			cfg.report.checkpoint(fmt.Sprintf("predump-%d", i), "pre-dump", i, start, res, err)
+			if err != nil {
 				// Fall back to normal (non-incremental) final dump.
 				fmt.Fprintf(os.Stderr, "[控制端] 警告：pre-dump #%d 失败，将退化为普通 dump：%v\n", i, err)
+				cfg.report.predumpRound(mode, predumpRound{Round: i, TookMS: res.Took.Milliseconds(), Decision: predumpFailed})
+				pages.stop()
 				cfg.predumpLastDir = ""
 				return nil
 			}
+			printCheckpoint(cfg, fmt.Sprintf("pre-dump #%d", i), res)
 			cfg.predumpLastDir = dirName
+			if xfer != nil {
+				xfer.sendDirAsync(dirName)
+				predumpDirs[dirName] = true
+			}
+			r := pd.next(i, res)
+			cfg.report.predumpRound(mode, r)
+			if r.Decision != predumpContinue {
+				return nil
+			}
 		}
-		return nil
-	})
+	}); err != nil {
+		return err
+	}
 
-	step("触发：迁移信号", func() error {
-		// A 的 PID 可能变化，实时从 podman 拿。
-		pid, err := podmanStatePID(cfg.aName)
+	// 从 prepare-migrate 开始，client 可能已经 arm 了目标：之后的失败都需要回滚。
+	phase = phaseArmed
+	journal.phase(phase, cfg)
+	if err := rec.run("触发：prepare-migrate", func() error {
+		pid, err := sourcePID(cfg)
 		if err != nil {
 			return err
 		}
 		cfg.aInitPID = pid
-		_ = sudoKill(cfg.aInitPID, syscall.SIGTERM)
+		addr, port, err := migrateTarget(cfg)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		res, err := prepareMigrate(cfg, cfg.aInitPID, id, addr, port)
+		cfg.report.phase("signal", start, err)
+		if err != nil {
+			return err
+		}
+		cfg.report.ack(res)
 		if clientObs != nil {
 			wait := 5 * time.Second
 			// If we already did pre-dump, keep the gap to the final dump small to reduce newly dirtied pages.
@@ -301,83 +634,133 @@ func doMigrate(cfg *controlConfig, clientObs *clientObserver) {
 			}
 		}
 		return nil
-	})
+	}); err != nil {
+		return rollback(cfg, rec, id, phase, err)
+	}
 
-	step("检查点：dump(A)", func() error {
-		args := []string{cfg.criuHost, "dump", "-t", strconv.Itoa(cfg.aInitPID), "-D", cfg.imgDir, "-W", cfg.imgDir,
-			"--shell-job", "--empty-ns", "net", "--manage-cgroups=ignore",
-		}
-		if cfg.predumpLastDir != "" {
-			// --prev-images-dir is relative to -D (cfg.imgDir).
-			args = append(args, "--prev-images-dir", cfg.predumpLastDir)
-			args = append(args, "--track-mem")
+	if err := rec.run("检查点：dump(A)", func() error {
+		start := time.Now()
+		req := checkpointReq{PID: cfg.aInitPID, Dir: cfg.imgDir, WorkDir: cfg.imgDir, Parent: cfg.predumpLastDir}
+		var res checkpointResult
+		var err error
+		if cfg.mode == modePrecopy {
+			req.PageServer = pageTarget
+			if err = openPages(".", cfg.predumpLastDir); err == nil {
+				res, err = cfg.ckpt.Dump(req)
+			}
+		} else {
+			req.LazyPort = cfg.lazyPort
+			cfg.lazy, res, err = cfg.ckpt.(lazyCheckpointer).LazyDump(req)
 		}
-		args = append(args, append(skipArgs, "-o", "dump.log", "-v4")...)
-		return runQuiet("sudo", args...)
-	})
-
-	step("停止：A(快速)", func() error {
-		_ = sudoKill(cfg.aInitPID, syscall.SIGKILL)
-		return nil
-	})
-
-	step("恢复：注入到B", func() error {
-		// B 的 PID 可能变化，实时从 podman 拿。
-		pid, err := podmanStatePID(cfg.bName)
+		cfg.report.checkpoint("dump", "dump", 0, start, res, err)
 		if err != nil {
 			return err
 		}
-		cfg.bInitPID = pid
-
-		pidFile := filepath.Join(cfg.imgDir, "restored.pid")
-		restoreLog := filepath.Join(cfg.imgDir, "restore.log")
+		printCheckpoint(cfg, "dump", res)
+		return nil
+	}); err != nil {
+		return rollback(cfg, rec, id, phase, err)
+	}
 
-		restoreArgs := []string{
-			"restore", "-D", cfg.imgDir, "-W", cfg.imgDir,
-			"--shell-job", "--restore-detached", "--mntns-compat-mode",
-			"--root", "/", "--manage-cgroups=ignore",
-			"--pidfile", pidFile,
-			"-J", fmt.Sprintf("net:/proc/%d/ns/net", cfg.bInitPID),
-			"-o", filepath.Base(restoreLog), "-v4",
+	if cfg.lazy != nil {
+		// post-copy：A 被 CRIU 冻结并充当 page-server，页传完之前不能停止；失败时让它恢复运行。
+		phase = phaseLazy
+		journal.phaseAsync(phase, cfg)
+	} else {
+		// CRIU dump 成功后进程已停止：之后的失败只能从 dump 恢复 A。
+		phase = phaseStopped
+		journal.phaseAsync(phase, cfg)
+		if err := rec.run("停止：A(快速)", func() error {
+			start := time.Now()
+			_ = sudoKill(cfg.aInitPID, syscall.SIGKILL)
+			cfg.report.phase("kill", start, nil)
+			return nil
+		}); err != nil {
+			return rollback(cfg, rec, id, phase, err)
 		}
+	}
 
-		nsenterArgs := []string{"nsenter", "-t", strconv.Itoa(cfg.bInitPID), "-m", "-n", "--", cfg.criuInB}
-		nsenterArgs = append(nsenterArgs, restoreArgs...)
-
-		cmd := exec.Command("sudo", nsenterArgs...)
-		cmd.Dir = cfg.imgDir
-		var stdout, stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
-		start := time.Now()
-		if err := cmd.Run(); err != nil {
-			reportExecFailure(start, stdout.Bytes(), stderr.Bytes(), err)
-			return err
+	if xfer != nil {
+		if err := rec.run("传输：镜像(A→B agent)", func() error {
+			start := time.Now()
+			st, err := xfer.sendTop(predumpDirs)
+			cfg.report.phase("transfer", start, err)
+			if err != nil {
+				return err
+			}
+			xfer.mu.Lock()
+			stats := append(append([]xferStat(nil), xfer.stats...), st)
+			xfer.mu.Unlock()
+			for _, t := range stats {
+				cfg.logf("[控制端] 传输 %s：files=%d bytes=%d took=%dms\n", t.Dir, t.Files, t.Bytes, t.Took.Milliseconds())
+				cfg.report.transfer(t)
+			}
+			return nil
+		}); err != nil {
+			return rollback(cfg, rec, id, phase, err)
 		}
+	}
 
-		rpid, err := readPIDFile(pidFile)
-		if err != nil {
-			return err
-		}
-		if err := sudoKill0(rpid); err != nil {
-			return fmt.Errorf("restored pid not alive: pid=%d err=%w", rpid, err)
-		}
-		cfg.restoredPID = rpid
-		if err := sudoKill(cfg.restoredPID, syscall.SIGUSR2); err != nil {
-			return err
+	if err := rec.run("恢复：注入到B", func() error {
+		if xfer != nil {
+			// 远端 restore（含 rebind）在 agent 上执行，这里只能记录整体耗时。
+			start := time.Now()
+			r, err := xfer.restore(id)
+			cfg.report.checkpoint("restore", "restore", 0, start, checkpointResult{Dir: cfg.remoteAgent, Took: time.Duration(r.TookMS) * time.Millisecond}, err)
+			if err != nil {
+				return fmt.Errorf("remote restore via %s: %w", cfg.remoteAgent, err)
+			}
+			cfg.restoredPID = r.RestoredPID
+			cfg.logf("[控制端] 远端 restore 完成：pid=%d took=%dms\n", r.RestoredPID, r.TookMS)
+		} else {
+			// 同机 page-server：等它退出，保证内存页已全部写入。
+			if err := pages.wait(pageServerWait); err != nil {
+				return err
+			}
+			if err := restoreIntoB(cfg); err != nil {
+				return err
+			}
 		}
 
-		// 方案2：显式 commit 信号。
-		// 目的：让 client 在 B 已 ready 后立刻 cutover，避免依赖业务 IO deadline 超时触发。
-		// 注意：该信号是“加速路径”，发送失败不应中断迁移。
-		time.Sleep(10 * time.Millisecond)
-		if err := sendCommit(cfg.commitAddr); err != nil {
-			fmt.Fprintf(os.Stderr, "[控制端] 警告：发送 commit 失败 addr=%s err=%v\n", cfg.commitAddr, err)
+		// commit：B 在 rebind 后已经经控制流下发（client 探测到 B 回复即 cutover）。
+		// 带外 commit 只在显式配置 --commit-addr 时发送；它是“加速路径”，发送失败不应中断迁移。
+		if cfg.commitAddr != "" {
+			time.Sleep(10 * time.Millisecond)
+			start := time.Now()
+			err := sendCommit(cfg.commitAddr)
+			cfg.report.phase("commit", start, err)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[控制端] 警告：发送 commit 失败 addr=%s err=%v\n", cfg.commitAddr, err)
+			}
 		}
 		return nil
-	})
+	}); err != nil {
+		return rollback(cfg, rec, id, phase, err)
+	}
 
-	step("等待：客户端重连", func() error {
+	phase = phaseRestored
+	journal.phase(phase, cfg)
+	if cfg.lazy != nil {
+		if err := rec.run("后拷贝：等待内存页传完", func() error {
+			start := time.Now()
+			st, err := cfg.lazy.Wait(lazyFetchTimeout)
+			cfg.report.phase("postcopy-fetch", start, err)
+			cfg.report.postcopy(cfg.lazyPort, st, err)
+			if err != nil {
+				return err
+			}
+			cfg.logf("[控制端] 后拷贝完成：缺页 %d，拉取 %dms\n", st.Faults, st.Fetch.Milliseconds())
+			start = time.Now()
+			_ = sudoKill(cfg.aInitPID, syscall.SIGKILL)
+			cfg.report.phase("kill", start, nil)
+			return nil
+		}); err != nil {
+			// 服务已在 B 上运行但内存页未传完：无法回滚，只能报告。
+			return err
+		}
+	}
+
+	if err := rec.run("等待：客户端重连", func() error {
 		if clientObs == nil {
 			return nil
 		}
@@ -386,7 +769,10 @@ func doMigrate(cfg *controlConfig, clientObs *clientObserver) {
 		case <-time.After(25 * time.Second):
 		}
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	return nil
 }
 
 func runCmd(args []string) {
@@ -395,7 +781,7 @@ func runCmd(args []string) {
 	var clientProc *exec.Cmd
 	var clientObs *clientObserver
 
-	clean := func() { cleanContainers(cfg.aName, cfg.bName) }
+	clean := func() { cleanContainers(cfg.rt, cfg.aName, cfg.bName) }
 	if !cfg.noCleanup {
 		defer clean()
 	}
@@ -414,16 +800,16 @@ func runCmd(args []string) {
 	}()
 
 	buildAndImage(cfg)
-	prepareImgDir(cfg.imgDir)
+	prepareImgDir(cfg)
 	startA(cfg)
 	startB(cfg)
 
-	step("启动：客户端", func() error {
+	step(cfg, "启动：客户端", func() error {
 		_ = os.Remove(cfg.clientLog)
 		clientProc = exec.Command(filepath.Join(cfg.workDir, "Client", "client_bin"))
 		clientProc.Env = append(os.Environ(), fmt.Sprintf("TARGET_ADDR=127.0.0.1:%d", cfg.srcPort))
 
-		obs, err := startClientObserver(clientProc, cfg.clientLog)
+		obs, err := startClientObserver(clientProc, cfg.clientLog, cfg.logOut())
 		if err != nil {
 			return err
 		}
@@ -438,14 +824,19 @@ func runCmd(args []string) {
 		}
 	})
 
-	doMigrate(cfg, clientObs)
+	if err := doMigrate(cfg, "", clientObs, &stepRecorder{out: cfg.out}); err != nil {
+		emitReport(cfg)
+		panic(err)
+	}
 
 	if clientObs != nil {
 		dt := clientObs.downtime()
 		if dt >= 0 {
-			fmt.Printf("[客户端] 汇总：服务中断 %dms\n", dt.Milliseconds())
+			cfg.logf("[客户端] 汇总：服务中断 %dms\n", dt.Milliseconds())
 		}
+		cfg.report.downtime(dt)
 	}
+	emitReport(cfg)
 
 	if clientProc != nil && clientProc.Process != nil {
 		_ = clientProc.Process.Signal(os.Interrupt)
@@ -457,7 +848,12 @@ func runCmd(args []string) {
 
 func upCmd(args []string) {
 	cfg := parseCommonFlags("up", args)
-	clean := func() { cleanContainers(cfg.aName, cfg.bName) }
+	cfg.openStore()
+	if cfg.instances > 0 {
+		upInstances(cfg)
+		return
+	}
+	clean := func() { cleanContainers(cfg.rt, cfg.aName, cfg.bName) }
 	defer func() {
 		if r := recover(); r != nil {
 			if !cfg.noCleanup {
@@ -469,15 +865,21 @@ func upCmd(args []string) {
 	}()
 
 	buildAndImage(cfg)
-	prepareImgDir(cfg.imgDir)
+	prepareImgDir(cfg)
 	startA(cfg)
 	startB(cfg)
+	cfg.store.putInstance(instanceRecord(cfg, instRunning))
 
-	fmt.Printf("[控制端] up 完成：A=%s(port=%d) B=%s(port=%d) imgDir=%s\n", cfg.aName, cfg.srcPort, cfg.bName, cfg.dstPort, cfg.imgDir)
+	cfg.logf("[控制端] up 完成：A=%s(port=%d) B=%s(port=%d) imgDir=%s\n", cfg.aName, cfg.srcPort, cfg.bName, cfg.dstPort, cfg.imgDir)
 }
 
 func migrateCmd(args []string) {
 	cfg := parseCommonFlags("migrate", args)
+	cfg.openStore()
+	if len(cfg.args) > 0 {
+		migrateInstances(cfg)
+		return
+	}
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -486,26 +888,61 @@ func migrateCmd(args []string) {
 		}
 	}()
 
+	useStoredInstance(cfg)
+
 	// 这里只做迁移链路；client 由 run.sh 在前台跑。
-	doMigrate(cfg, nil)
-	fmt.Printf("[控制端] migrate 完成：restoredPID=%d\n", cfg.restoredPID)
+	// 指定 --client-out 时从 client 输出里读取本次迁移的服务中断时间，写进报告。
+	var clientOff int64
+	watchClient := false
+	if cfg.clientOut != "" {
+		if fi, err := os.Stat(cfg.clientOut); err == nil {
+			clientOff, watchClient = fi.Size(), true
+		}
+	}
+	host := migrateHost(cfg)
+	err := doMigrate(cfg, "", nil, &stepRecorder{out: cfg.out})
+	recordMigrated(cfg, host, err)
+	if err != nil {
+		emitReport(cfg)
+		panic(err)
+	}
+	cfg.logf("[控制端] migrate 完成：restoredPID=%d\n", cfg.restoredPID)
+	if watchClient {
+		if dt, ok := waitClientDowntime(cfg.clientOut, clientOff, 30*time.Second); ok {
+			cfg.logf("[客户端] 汇总：服务中断 %dms\n", dt.Milliseconds())
+			cfg.report.downtime(dt)
+		} else {
+			fmt.Fprintf(os.Stderr, "[控制端] 警告：%s 中未出现服务中断汇总\n", cfg.clientOut)
+		}
+	}
+	emitReport(cfg)
 }
 
 func downCmd(args []string) {
 	// down 只需要容器名与 imgDir，使用同一套解析函数获取默认值。
 	cfg := parseCommonFlags("down", args)
-	step("清理：容器", func() error {
-		cleanContainers(cfg.aName, cfg.bName)
-		return nil
-	})
-	step("清理：镜像目录", func() error {
+	cfg.openStore()
+	if cfg.instances > 0 {
+		downInstances(cfg)
+	} else {
+		step(cfg, "清理：容器", func() error {
+			// 迁移后源/壳可能已经换了名字：状态库中有记录时一并删除。
+			if in, ok := cfg.store.instance("default"); ok {
+				cleanContainers(cfg.rt, in.Src, in.Shell)
+			}
+			cleanContainers(cfg.rt, cfg.aName, cfg.bName)
+			cfg.store.deleteInstance("default")
+			return nil
+		})
+	}
+	step(cfg, "清理：镜像目录", func() error {
 		return runQuiet("sudo", "rm", "-rf", cfg.imgDir)
 	})
 }
 
-func step(name string, fn func() error) {
-	fmt.Printf("[控制端] 步骤：%s\n", name)
-	if err := fn(); err != nil {
-		panic(fmt.Errorf("步骤失败：%s：%w", name, err))
+func step(cfg *controlConfig, name string, fn func() error) {
+	rec := &stepRecorder{out: cfg.out}
+	if err := rec.run(name, fn); err != nil {
+		panic(err)
 	}
 }
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// doMigrate 的阶段推进与回滚，实例与工具函数见 rollback_test.go。
+
+// faultyCheckpointer 让 op（pre-dump/dump/restore）的前 times 次调用失败（times<0 表示总是失败）。
+type faultyCheckpointer struct {
+	Checkpointer
+	op    string
+	times int
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *faultyCheckpointer) fail(op string) bool {
+	if op != f.op || f.times == 0 {
+		return false
+	}
+	if f.times > 0 {
+		f.times--
+	}
+	return true
+}
+
+func (f *faultyCheckpointer) PreDump(req checkpointReq) (checkpointResult, error) {
+	if f.fail("pre-dump") {
+		return checkpointResult{}, errInjected
+	}
+	return f.Checkpointer.PreDump(req)
+}
+
+func (f *faultyCheckpointer) Dump(req checkpointReq) (checkpointResult, error) {
+	if f.fail("dump") {
+		return checkpointResult{}, errInjected
+	}
+	return f.Checkpointer.Dump(req)
+}
+
+func (f *faultyCheckpointer) Restore(req checkpointReq) (checkpointResult, error) {
+	if f.fail("restore") {
+		return checkpointResult{}, errInjected
+	}
+	return f.Checkpointer.Restore(req)
+}
+
+// newTestInstance 启动 A(源) 与 B(壳)，返回可直接交给 doMigrate 的配置。
+func newTestInstance(t *testing.T, failOp string, failTimes int) *controlConfig {
+	t.Helper()
+	cfg := newRollbackInstance(t)
+	cfg.predumpRounds = 1
+	cfg.mode = modePrecopy
+	store, err := openStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.store = store
+	cfg.ckpt = &faultyCheckpointer{Checkpointer: cfg.ckpt, op: failOp, times: failTimes}
+	return cfg
+}
+
+func stepNames(rec *stepRecorder) (names []string, failed []string) {
+	for _, s := range rec.snapshot() {
+		names = append(names, s.Name)
+		if s.State == stepFailed {
+			failed = append(failed, s.Name)
+		}
+	}
+	return names, failed
+}
+
+func TestMigratePhases(t *testing.T) {
+	const (
+		predump  = "预拷贝：pre-dump(A)"
+		prepare  = "触发：prepare-migrate"
+		dump     = "检查点：dump(A)"
+		kill     = "停止：A(快速)"
+		restore  = "恢复：注入到B"
+		wait     = "等待：客户端重连"
+		confirmA = "回滚：确认 A 存活"
+		restoreA = "回滚：A 从 dump 恢复"
+		abortA   = "回滚：通知 client abort"
+	)
+	cases := []struct {
+		name      string
+		failOp    string
+		failTimes int
+		badTarget bool
+
+		steps  []string
+		failed []string
+		// phase 是迁移结束时（或失败时）所处的阶段；action 为空表示迁移成功，recovered 表示回滚后服务回到了 A。
+		phase     migPhase
+		action    string
+		recovered bool
+	}{
+		{
+			name:  "success",
+			steps: []string{predump, prepare, dump, kill, restore, wait},
+			phase: phaseRestored,
+		},
+		{
+			// pre-dump 失败只退化为普通 dump，迁移照常完成。
+			name: "predump fails", failOp: "pre-dump", failTimes: -1,
+			steps: []string{predump, prepare, dump, kill, restore, wait},
+			phase: phaseRestored,
+		},
+		{
+			name: "prepare-migrate fails", badTarget: true,
+			steps:  []string{predump, prepare, confirmA, abortA},
+			failed: []string{prepare},
+			phase:  phaseArmed, action: "abort", recovered: true,
+		},
+		{
+			name: "dump fails", failOp: "dump", failTimes: -1,
+			steps:  []string{predump, prepare, dump, confirmA, abortA},
+			failed: []string{dump},
+			phase:  phaseArmed, action: "abort", recovered: true,
+		},
+		{
+			name: "restore fails", failOp: "restore", failTimes: 1,
+			steps:  []string{predump, prepare, dump, kill, restore, restoreA, abortA},
+			failed: []string{restore},
+			phase:  phaseStopped, action: "restore-a", recovered: true,
+		},
+		{
+			name: "restore and rollback fail", failOp: "restore", failTimes: -1,
+			steps:  []string{predump, prepare, dump, kill, restore, restoreA},
+			failed: []string{restore, restoreA},
+			phase:  phaseStopped, action: "restore-a",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestInstance(t, tc.failOp, tc.failTimes)
+			srcPID := cfg.aInitPID
+			if tc.badTarget {
+				cfg.migrateTo = "no-port"
+			}
+			rec := &stepRecorder{}
+			err := doMigrate(cfg, "m-job", nil, rec)
+
+			steps, failed := stepNames(rec)
+			if !reflect.DeepEqual(steps, tc.steps) {
+				t.Errorf("steps:\n got %q\nwant %q", steps, tc.steps)
+			}
+			if !reflect.DeepEqual(failed, tc.failed) {
+				t.Errorf("failed steps: got %q, want %q", failed, tc.failed)
+			}
+
+			d, lerr := cfg.store.load()
+			if lerr != nil || len(d.Migrations) != 1 {
+				t.Fatalf("journal: %v %+v", lerr, d)
+			}
+			m := d.Migrations[0]
+			// 调用方给出的 ID 贯穿日志与报告，doMigrate 不另起一个。
+			if m.ID != "m-job" || cfg.report.rep.ID != "m-job" {
+				t.Errorf("journal id %q, report id %q, want m-job", m.ID, cfg.report.rep.ID)
+			}
+			if m.Phase != tc.phase.String() {
+				t.Errorf("journal phase %s, want %s", m.Phase, tc.phase)
+			}
+
+			if tc.action == "" {
+				if err != nil {
+					t.Fatalf("migrate: %v", err)
+				}
+				if m.State != jobDone {
+					t.Errorf("journal state %s, want %s", m.State, jobDone)
+				}
+				if !running(cfg.restoredPID) {
+					t.Errorf("restored pid %d is not running", cfg.restoredPID)
+				}
+				if running(srcPID) {
+					t.Errorf("source pid %d still running after migration", srcPID)
+				}
+				return
+			}
+
+			var re *rollbackError
+			if !errors.As(err, &re) {
+				t.Fatalf("want *rollbackError, got %v", err)
+			}
+			if re.Phase != tc.phase || re.Action != tc.action || re.Recovered != tc.recovered {
+				t.Errorf("rollback phase=%s action=%s recovered=%v, want %s/%s/%v (%v)",
+					re.Phase, re.Action, re.Recovered, tc.phase, tc.action, tc.recovered, re.RollbackErr)
+			}
+			if m.State != jobFailed || m.Rollback != re.outcome() {
+				t.Errorf("journal state=%s rollback=%q", m.State, m.Rollback)
+			}
+			if !tc.recovered {
+				return
+			}
+			if !running(re.PID) {
+				t.Fatalf("service pid %d is not running after rollback", re.PID)
+			}
+			if cfg.srcPID != re.PID {
+				t.Errorf("srcPID %d, want %d", cfg.srcPID, re.PID)
+			}
+			// 服务回到 A：控制端点可用，下一次迁移可以直接以它为源。
+			if err := waitCtlReady(cfg.ctlSocket, re.PID, cfg.ctlTimeout); err != nil {
+				t.Errorf("service in A: %v", err)
+			}
+			if tc.phase == phaseArmed && re.PID != srcPID {
+				t.Errorf("armed rollback moved the service: pid %d, want %d", re.PID, srcPID)
+			}
+		})
+	}
+}
+
+// TestResumeDumpedBeforeStopped：dump 已停止 A，但 Control 在 stopped 写进日志之前退出（日志仍是 armed）。
+// resume 不能再确认 A，而应从 dump 恢复。
+func TestResumeDumpedBeforeStopped(t *testing.T) {
+	cfg := newTestInstance(t, "", 0)
+	srcPID := cfg.aInitPID
+	j := cfg.store.begin("m-crash", cfg)
+	j.phase(phaseArmed, cfg)
+	if _, err := cfg.ckpt.Dump(checkpointReq{PID: srcPID, Dir: cfg.imgDir, WorkDir: cfg.imgDir}); err != nil {
+		t.Fatal(err)
+	}
+	killInstance(t, srcPID)
+
+	d, err := cfg.store.load()
+	if err != nil || len(d.Migrations) != 1 || d.Migrations[0].Phase != phaseArmed.String() {
+		t.Fatalf("journal: %v %+v", err, d)
+	}
+	m := d.Migrations[0]
+	in := &instance{Name: m.Instance}
+	resumeMigration(cfg, m, in)
+	if m.State != migRecovered || !strings.Contains(m.Rollback, "action=restore-a") {
+		t.Fatalf("resume: state=%s rollback=%q", m.State, m.Rollback)
+	}
+	if in.PID == srcPID || !running(in.PID) {
+		t.Fatalf("service pid %d after resume (source %d)", in.PID, srcPID)
+	}
+	if err := waitCtlReady(cfg.ctlSocket, in.PID, cfg.ctlTimeout); err != nil {
+		t.Errorf("service in A: %v", err)
+	}
+}
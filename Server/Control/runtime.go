@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Runtime 抽象 Control 对“容器”的全部操作。
+//
+// doMigrate/startA/startB/down 只通过该接口创建、查询与删除实例，
+// 从而可以用 fakeRuntime（本地进程）在没有 podman/root 的机器上跑通编排逻辑。
+type Runtime interface {
+	Name() string
+	// BuildImage 从 contextDir 构建 server 镜像（fake 下为空操作）。
+	BuildImage(contextDir, image string) error
+	// StartInstance 启动运行 server 的源实例，返回其 init PID。
+	StartInstance(spec containerSpec) (int, error)
+	// CreateShell 启动只占位的壳（sleep infinity），供 restore 注入，返回其 init PID。
+	CreateShell(spec containerSpec) (int, error)
+	// Spawn 在已存在的壳 spec.Name 中启动 server 进程（附加 env），返回其 PID。
+	// 供不依赖 CRIU 的 checkpointer 在壳里“重启”服务。
+	Spawn(spec containerSpec, env []string) (int, error)
+	// PID 返回实例 init 进程的 PID。
+	PID(name string) (int, error)
+	// NetNS 返回实例网络命名空间路径（restore 时 -J net:<path>）。
+	NetNS(name string) (string, error)
+	// CRIUPath 返回 host 上的 criu 在壳内可见的路径。
+	CRIUPath(hostBin string) string
+	// RestoreLAddr 返回 restore 到壳 name 后 rebind 的本地地址；空表示沿用原地址。
+	RestoreLAddr(name string) string
+	// Remove 强制删除实例（不存在时不报错）。
+	Remove(name string) error
+}
+
+// containerSpec 描述一个源实例或壳。
+type containerSpec struct {
+	Name      string
+	Image     string
+	HostPort  int
+	ImgDir    string
+	CtlSocket string
+	CRIUHost  string
+}
+
+func newRuntime(kind string, cfg *controlConfig) (Runtime, error) {
+	switch kind {
+	case "", "podman":
+		return podmanRuntime{}, nil
+	case "fake":
+		return newFakeRuntime(cfg.workDir, cfg.stateDir)
+	default:
+		return nil, fmt.Errorf("unknown runtime: %q", kind)
+	}
+}
+
+func (c *controlConfig) spec(name string, port int) containerSpec {
+	return containerSpec{Name: name, Image: c.imageName, HostPort: port, ImgDir: c.imgDir, CtlSocket: c.ctlSocket, CRIUHost: c.criuHost}
+}
+
+// procEnv 从 /proc/<pid>/environ 读取环境变量（restore 出来的进程保留原环境）。
+func procEnv(pid int, key string) string {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return ""
+	}
+	for _, kv := range strings.Split(string(b), "\x00") {
+		if v, ok := strings.CutPrefix(kv, key+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// fakeRuntime 用本地进程模拟容器，便于在没有 podman 的 CI/开发机上跑通编排逻辑。
+//
+//   - 源实例：直接运行 Server/server_bin，LISTEN_ADDR=:<host-port>，控制端点在 stateDir 下。
+//   - 壳：`sleep infinity`，只用来提供 nsenter 的目标（与 host 同命名空间）。
+//   - 每个实例的 PID/端口记录在 stateDir/<name>.pid|.port（Spawn 的进程为 .child），Control 重启后仍可查询/删除。
+//     stateDir 在状态库目录下（--state-dir/fake-rt），不同工作目录/状态库的实例互不干扰。
+//   - 进程由启动它的 Control 回收（Wait），被 kill 后不会以僵尸进程的形式继续“存活”。
+//
+// 进程与 host 共享命名空间，没有端口映射：restore 后必须 rebind 到壳的端口（见 RestoreLAddr）。
+type fakeRuntime struct {
+	serverBin string
+	stateDir  string
+}
+
+func newFakeRuntime(workDir, stateDir string) (*fakeRuntime, error) {
+	dir := filepath.Join(stateDir, "fake-rt")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fakeRuntime{serverBin: filepath.Join(workDir, "Server", "server_bin"), stateDir: dir}, nil
+}
+
+func (r *fakeRuntime) Name() string { return "fake" }
+
+func (r *fakeRuntime) BuildImage(contextDir, image string) error { return nil }
+
+func (r *fakeRuntime) StartInstance(spec containerSpec) (int, error) {
+	_ = r.Remove(spec.Name)
+	return r.spawn(spec, "pid", r.serverEnv(spec, nil), r.serverBin)
+}
+
+func (r *fakeRuntime) CreateShell(spec containerSpec) (int, error) {
+	_ = r.Remove(spec.Name)
+	return r.spawn(spec, "pid", os.Environ(), "sleep", "infinity")
+}
+
+// Spawn 在壳旁边启动 server：没有真正的命名空间隔离，监听壳的端口；PID 记为 <name>.child，Remove 时一并结束。
+func (r *fakeRuntime) Spawn(spec containerSpec, env []string) (int, error) {
+	if _, err := r.PID(spec.Name); err != nil {
+		return 0, err
+	}
+	return r.spawn(spec, "child", r.serverEnv(spec, env), r.serverBin)
+}
+
+func (r *fakeRuntime) serverEnv(spec containerSpec, extra []string) []string {
+	ctl := spec.CtlSocket
+	if ctl == "" || ctl == wrapper.DefaultControlSocket {
+		ctl = filepath.Join(r.stateDir, spec.Name+".sock")
+	}
+	env := append(os.Environ(),
+		fmt.Sprintf("LISTEN_ADDR=:%d", spec.HostPort),
+		fmt.Sprintf("CONTROL_SOCKET=%s", ctl),
+		"QUIET=1",
+	)
+	return append(env, extra...)
+}
+
+func (r *fakeRuntime) spawn(spec containerSpec, pidExt string, env []string, name string, args ...string) (int, error) {
+	logf, err := os.Create(filepath.Join(r.stateDir, spec.Name+"."+pidExt+".log"))
+	if err != nil {
+		return 0, err
+	}
+	defer logf.Close()
+
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	// 独立会话：CRIU --shell-job 之外不与 Control 的终端/进程组绑定，Control 退出后实例继续存活。
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	// 实例的生命周期由 Remove/kill 决定；这里只负责在它退出后回收。
+	go func() { _ = cmd.Wait() }()
+
+	if err := os.WriteFile(r.file(spec.Name, pidExt), []byte(strconv.Itoa(pid)), 0o644); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(r.file(spec.Name, "port"), []byte(strconv.Itoa(spec.HostPort)), 0o644); err != nil {
+		return 0, err
+	}
+	return pid, nil
+}
+
+func (r *fakeRuntime) PID(name string) (int, error) {
+	return r.readPID(name, "pid")
+}
+
+func (r *fakeRuntime) readPID(name, ext string) (int, error) {
+	b, err := os.ReadFile(r.file(name, ext))
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid: %q", strings.TrimSpace(string(b)))
+	}
+	if err := syscall.Kill(pid, 0); err != nil {
+		return 0, fmt.Errorf("%s not running: pid=%d: %w", name, pid, err)
+	}
+	return pid, nil
+}
+
+// NetNS 返回壳的网络命名空间（即 host 的）：restore 后进程仍在 host 网络中。
+func (r *fakeRuntime) NetNS(name string) (string, error) {
+	pid, err := r.PID(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", pid), nil
+}
+
+func (r *fakeRuntime) CRIUPath(hostBin string) string { return hostBin }
+
+// RestoreLAddr 返回 restore 到壳 name 后应 rebind 的本地地址（壳创建时的端口）。
+func (r *fakeRuntime) RestoreLAddr(name string) string {
+	b, err := os.ReadFile(r.file(name, "port"))
+	if err != nil {
+		return ""
+	}
+	return ":" + strings.TrimSpace(string(b))
+}
+
+func (r *fakeRuntime) Remove(name string) error {
+	for _, ext := range []string{"child", "pid"} {
+		pid, err := r.readPID(name, ext)
+		if err != nil {
+			continue
+		}
+		// Setsid 后 pid 即进程组 ID：连同子进程一起结束。
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+		for i := 0; i < 50 && syscall.Kill(pid, 0) == nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for _, ext := range []string{"child", "pid", "port"} {
+		if rerr := os.Remove(r.file(name, ext)); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			return rerr
+		}
+	}
+	return nil
+}
+
+func (r *fakeRuntime) file(name, ext string) string {
+	return filepath.Join(r.stateDir, name+"."+ext)
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// serveCmd 运行常驻的 Control daemon，通过 HTTP/JSON 管理实例与迁移：
+//
+//	GET  /instances                 列出实例
+//	POST /instances                 启动实例 {"name","src_port","dst_port","client_id"}（开壳池时 dst_port 可省略）
+//	POST /migrations                发起迁移 {"instance","to"}，返回任务 ID
+//	GET  /migrations                列出迁移任务
+//	GET  /migrations/{id}           查询任务状态与逐步进度
+//	GET  /migrations/{id}/report    获取已结束任务的报告（migrationReport，同时写到 --report-dir）
+//	GET  /pool                      壳池状态（--pool-size）
+//
+// 与一次性 CLI 不同：步骤失败只会让对应任务进入 failed，daemon 继续服务。
+// 不同实例的迁移并行执行，同时运行的至多 --max-parallel 个，其余保持 pending 排队。
+// 实例与迁移记录写进状态库（state.go）：启动时先恢复上次被中断的迁移，再载入实例。
+func serveCmd(args []string) {
+	cfg := parseCommonFlags("serve", args)
+	cfg.openStore()
+	if cfg.listenAddr == "" {
+		cfg.listenAddr = "127.0.0.1:7380"
+	}
+
+	d := newDaemon(cfg)
+	if cfg.poolSize > 0 {
+		if err := d.startPool(); err != nil {
+			dief("serve: %v", err)
+		}
+	}
+	resumeInterrupted(cfg)
+	d.loadInstances()
+	d.adoptDefault()
+
+	cfg.logf("[控制端] daemon 监听 http://%s\n", cfg.listenAddr)
+	if err := http.ListenAndServe(cfg.listenAddr, d.routes()); err != nil {
+		dief("serve: %v", err)
+	}
+}
+
+const (
+	instRunning   = "running"
+	instMigrating = "migrating"
+	instFailed    = "failed"
+
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+type migrationJob struct {
+	ID          string    `json:"id"`
+	Instance    string    `json:"instance"`
+	To          string    `json:"to"`
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	Rollback    string    `json:"rollback,omitempty"`
+	Created     time.Time `json:"created"`
+	Started     time.Time `json:"started,omitempty"`
+	Finished    time.Time `json:"finished,omitempty"`
+	RestoredPID int       `json:"restored_pid,omitempty"`
+
+	rec *stepRecorder
+	// rep 是 doMigrate 填充的报告（见 report.go）。
+	rep *reportBuilder
+}
+
+type daemon struct {
+	base *controlConfig
+
+	buildOnce sync.Once
+	buildErr  error
+
+	// sem 限制同时运行的迁移数（--max-parallel）。
+	sem chan struct{}
+
+	mu        sync.Mutex
+	instances instanceRegistry
+	jobs      map[string]*migrationJob
+}
+
+func newDaemon(cfg *controlConfig) *daemon {
+	return &daemon{
+		base: cfg, sem: make(chan struct{}, max(cfg.maxParallel, 1)),
+		instances: instanceRegistry{}, jobs: map[string]*migrationJob{},
+	}
+}
+
+// loadInstances 从状态库载入实例；源进程已不在的实例标为 failed。
+func (d *daemon) loadInstances() {
+	st, err := d.base.store.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[控制端] 警告：读状态库失败：%v\n", err)
+		return
+	}
+	for _, rec := range instanceRegistry(st.Instances).list() {
+		in := rec
+		in.cfg = instanceConfig(d.base, in.Name, in.Slot, in.SrcPort, in.DstPort)
+		applyInstance(in.cfg, in)
+		in.Job = ""
+		in.State = instRunning
+		if in.cfg.srcPID == 0 {
+			if pid, err := d.base.rt.PID(in.Src); err == nil {
+				in.PID = pid
+			} else {
+				in.State = instFailed
+			}
+		}
+		if err := d.instances.add(&in); err != nil {
+			fmt.Fprintf(os.Stderr, "[控制端] 警告：忽略实例 %s：%v\n", in.Name, err)
+			continue
+		}
+		d.base.logf("[控制端] 载入实例 %s：%s(port=%d) pid=%d %s\n", in.Name, in.Src, in.SrcPort, in.PID, in.State)
+	}
+}
+
+// adoptDefault 把 `control up` 已启动的 A/B（--a-name/--b-name）登记为 default 实例（状态库中没有时）。
+func (d *daemon) adoptDefault() {
+	if _, ok := d.instances["default"]; ok {
+		return
+	}
+	pid, err := d.base.rt.PID(d.base.aName)
+	if err != nil {
+		return
+	}
+	c := *d.base
+	c.instanceName = "default"
+	in := &instance{
+		Name: "default", Src: c.aName, Shell: c.bName, SrcPort: c.srcPort, DstPort: c.dstPort, Host: "127.0.0.1",
+		PID: pid, ImgDir: c.imgDir, State: instRunning, cfg: &c,
+	}
+	d.instances["default"] = in
+	d.base.store.putInstance(*in)
+}
+
+// startPool 构建镜像并启动壳池；default 实例（`control up`）的端口不分配给壳。
+func (d *daemon) startPool() error {
+	if err := d.ensureBuilt(); err != nil {
+		return err
+	}
+	// 壳挂载基础镜像目录，各实例的子目录在它下面；这里只确保它存在，不清空。
+	if err := runQuiet("sudo", "mkdir", "-p", d.base.imgDir); err != nil {
+		return err
+	}
+	p, err := newShellPool(d.base, d.base.srcPort, d.base.dstPort)
+	if err != nil {
+		return err
+	}
+	d.base.pool = p
+	return nil
+}
+
+func (d *daemon) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/instances", d.handleInstances)
+	mux.HandleFunc("/migrations", d.handleMigrations)
+	mux.HandleFunc("/migrations/", d.handleMigration)
+	mux.HandleFunc("/pool", d.handlePool)
+	return mux
+}
+
+func (d *daemon) handleInstances(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		d.mu.Lock()
+		out := d.instances.list()
+		d.mu.Unlock()
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var req struct {
+			Name     string `json:"name"`
+			SrcPort  int    `json:"src_port"`
+			DstPort  int    `json:"dst_port"`
+			ClientID string `json:"client_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		in, err := d.startInstance(req.Name, req.SrcPort, req.DstPort, req.ClientID)
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, in)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var (
+	reInstanceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+	errConflict = errors.New("conflict")
+	errNotFound = errors.New("not found")
+)
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, errConflict):
+		return http.StatusConflict
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// startInstance 为实例创建源容器与壳容器（配置见 instanceConfig）。
+// 开壳池且未指定 dstPort 时只创建源容器，迁移时从池中取壳。
+func (d *daemon) startInstance(name string, srcPort, dstPort int, clientID string) (instance, error) {
+	if !reInstanceName.MatchString(name) {
+		return instance{}, fmt.Errorf("bad instance name: %q", name)
+	}
+	pool := d.base.pool
+	if srcPort <= 0 || dstPort < 0 || srcPort == dstPort || (dstPort == 0 && pool == nil) {
+		return instance{}, fmt.Errorf("bad ports: src=%d dst=%d", srcPort, dstPort)
+	}
+	if pool != nil && (pool.owns(srcPort) || pool.owns(dstPort)) {
+		return instance{}, fmt.Errorf("bad ports: src=%d dst=%d belong to the shell pool (--pool-ports %s or recycled)", srcPort, dstPort, d.base.poolPorts)
+	}
+
+	d.mu.Lock()
+	slot := d.instances.nextSlot()
+	c := instanceConfig(d.base, name, slot, srcPort, dstPort)
+	in := &instance{
+		Name: name, Slot: slot, Src: c.aName, Shell: c.bName, SrcPort: srcPort, DstPort: dstPort,
+		Host: "127.0.0.1", ClientID: clientID, ImgDir: c.imgDir, State: instMigrating, cfg: c,
+	}
+	if err := d.instances.add(in); err != nil {
+		d.mu.Unlock()
+		return instance{}, err
+	}
+	d.mu.Unlock()
+
+	err := d.ensureBuilt()
+	if err == nil {
+		err = tryStep(func() {
+			prepareImgDir(c)
+			startA(c)
+			if c.bName != "" {
+				startB(c)
+			}
+		})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		delete(d.instances, name)
+		cleanContainers(c.rt, c.aName, c.bName)
+		return instance{}, err
+	}
+	in.PID = c.aInitPID
+	in.State = instRunning
+	d.base.store.putInstance(*in)
+	return *in, nil
+}
+
+func (d *daemon) ensureBuilt() error {
+	d.buildOnce.Do(func() {
+		d.buildErr = tryStep(func() { buildAndImage(d.base) })
+	})
+	return d.buildErr
+}
+
+func (d *daemon) handleMigrations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		d.mu.Lock()
+		out := make([]jobView, 0, len(d.jobs))
+		for _, j := range d.jobs {
+			out = append(out, j.view())
+		}
+		d.mu.Unlock()
+		sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var req struct {
+			Instance string `json:"instance"`
+			To       string `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.To != "" {
+			if _, _, err := wrapper.SplitTarget(req.To); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		j, err := d.startMigration(req.Instance, req.To)
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, j)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMigration 处理 /migrations/{id} 与 /migrations/{id}/report。
+func (d *daemon) handleMigration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/migrations/"), "/")
+	id, sub, _ := strings.Cut(rest, "/")
+
+	d.mu.Lock()
+	j := d.jobs[id]
+	var v jobView
+	if j != nil {
+		v = j.view()
+	}
+	d.mu.Unlock()
+	if j == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("migration %q: %w", id, errNotFound))
+		return
+	}
+
+	switch sub {
+	case "":
+		writeJSON(w, http.StatusOK, v)
+	case "report":
+		if v.State != jobDone && v.State != jobFailed {
+			writeError(w, http.StatusConflict, fmt.Errorf("migration %q still %s", id, v.State))
+			return
+		}
+		writeJSON(w, http.StatusOK, v.report())
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown resource %q", sub))
+	}
+}
+
+// startMigration 创建迁移任务并在后台执行；同一实例同一时刻只允许一个迁移。
+func (d *daemon) startMigration(name, to string) (jobView, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	in := d.instances[name]
+	if in == nil {
+		return jobView{}, fmt.Errorf("instance %q: %w", name, errNotFound)
+	}
+	if in.State != instRunning {
+		return jobView{}, fmt.Errorf("instance %q is %s: %w", name, in.State, errConflict)
+	}
+
+	j := &migrationJob{ID: newMigrationID(), Instance: name, To: to, State: jobPending, Created: time.Now(), rec: &stepRecorder{out: d.base.out}}
+	d.jobs[j.ID] = j
+	in.State = instMigrating
+	in.Job = j.ID
+
+	c := *in.cfg
+	c.migrateTo = to
+	go d.runMigration(in, j, &c)
+	return j.view(), nil
+}
+
+func (d *daemon) runMigration(in *instance, j *migrationJob, c *controlConfig) {
+	// 任务结束后把报告落盘（在释放 d.mu 之后执行）。
+	defer func() {
+		d.mu.Lock()
+		rep := j.view().report()
+		d.mu.Unlock()
+		if _, err := saveReport(c.reportDir, rep); err != nil {
+			fmt.Fprintf(os.Stderr, "[控制端] 警告：写迁移报告失败 id=%s：%v\n", j.ID, err)
+		}
+	}()
+
+	// 超出 --max-parallel 的任务保持 pending，等其他迁移结束。
+	d.sem <- struct{}{}
+	defer func() { <-d.sem }()
+
+	d.mu.Lock()
+	j.State = jobRunning
+	j.Started = time.Now()
+	d.mu.Unlock()
+
+	claimed, err := claimShell(c, j.rec)
+	// 没取到壳时迁移尚未开始，实例不受影响。
+	untouched := err != nil
+	if err == nil {
+		d.mu.Lock()
+		in.Shell, in.DstPort = c.bName, c.dstPort
+		d.base.store.putInstance(*in)
+		d.mu.Unlock()
+
+		if perr := tryStep(func() { err = doMigrate(c, j.ID, nil, j.rec) }); perr != nil {
+			err = perr
+		}
+		d.mu.Lock()
+		j.rep = c.report
+		d.mu.Unlock()
+
+		if err != nil && claimed {
+			releaseShell(c)
+		}
+	}
+
+	// recycleSource 会清空 migrateTo，先记下 client 被指向的主机。
+	host := ""
+	if h, _, serr := wrapper.SplitTarget(c.migrateTo); serr == nil {
+		host = h
+	}
+	if err == nil {
+		err = recycleSource(c, j.rec)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer func() { d.base.store.putInstance(*in) }()
+	j.Finished = time.Now()
+	j.RestoredPID = c.restoredPID
+	in.Job = ""
+	if err != nil {
+		j.State = jobFailed
+		j.Error = err.Error()
+		in.State = instFailed
+		in.Shell, in.DstPort = in.cfg.bName, in.cfg.dstPort
+		if untouched {
+			in.State = instRunning
+		}
+		// 回滚成功：服务仍在原 A 中运行（可能是从 dump 恢复出的新进程），实例可继续使用。
+		var re *rollbackError
+		if errors.As(err, &re) {
+			j.Rollback = re.outcome()
+			if re.Recovered {
+				in.PID = re.PID
+				in.cfg.srcPID = re.PID
+				in.State = instRunning
+			}
+		}
+		fmt.Fprintf(os.Stderr, "[控制端] 迁移失败 id=%s instance=%s：%v\n", j.ID, in.Name, err)
+		return
+	}
+	j.State = jobDone
+	in.cfg = c
+	in.Src, in.Shell = c.aName, c.bName
+	in.SrcPort, in.DstPort = c.srcPort, c.dstPort
+	in.PID = c.srcPID
+	in.State = instRunning
+	if host != "" {
+		in.Host = host
+	}
+	if in.ClientID == "" {
+		in.ClientID = ackedClient(c.report)
+	}
+}
+
+// ackedClient 返回迁移中第一个确认 migrate 的 client 标识（用于补全实例的 ClientID）。
+func ackedClient(b *reportBuilder) string {
+	rep := b.snapshot()
+	if rep.Ack == nil {
+		return ""
+	}
+	for _, cr := range rep.Ack.Clients {
+		if cr.Acked && cr.ClientID != "" {
+			return cr.ClientID
+		}
+	}
+	return ""
+}
+
+// poolView 是 GET /pool 的返回：目标就绪数、端口范围、就绪的壳与正在创建/回收的数量。
+type poolView struct {
+	Size    int           `json:"size"`
+	Ports   string        `json:"ports"`
+	Ready   []pooledShell `json:"ready"`
+	Pending int           `json:"pending"`
+}
+
+func (d *daemon) handlePool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p := d.base.pool
+	if p == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("shell pool disabled (--pool-size 0): %w", errNotFound))
+		return
+	}
+	ready, pending := p.snapshot()
+	writeJSON(w, http.StatusOK, poolView{Size: p.size, Ports: d.base.poolPorts, Ready: ready, Pending: pending})
+}
+
+// jobView 是迁移任务对外的快照（含逐步进度）。
+type jobView struct {
+	migrationJob
+	Steps   []stepRecord `json:"steps"`
+	Current string       `json:"current,omitempty"`
+}
+
+func (j *migrationJob) view() jobView {
+	v := jobView{migrationJob: *j, Steps: j.rec.snapshot()}
+	for _, s := range v.Steps {
+		if s.State == stepRunning {
+			v.Current = s.Name
+		}
+	}
+	return v
+}
+
+// report 以 doMigrate 的报告为基础，用任务自身的状态与步骤（含迁移后的“回收”步骤）覆盖汇总字段。
+func (v jobView) report() migrationReport {
+	rep := v.rep.snapshot()
+	rep.ID, rep.Instance, rep.To = v.ID, v.Instance, v.To
+	rep.State, rep.Error, rep.Rollback = v.State, v.Error, v.Rollback
+	rep.Start, rep.End = v.Started, v.Finished
+	rep.TotalMS = v.Finished.Sub(v.Started).Milliseconds()
+	rep.RestoredPID = v.RestoredPID
+	rep.Steps = stepReports(v.Steps)
+	return rep
+}
+
+// tryStep 在 daemon 内调用沿用 step() 的 CLI 函数，把 panic 转回 error。
+func tryStep(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
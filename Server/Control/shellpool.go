@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 壳容器池（--pool-size）。
+//
+// 不开池时每个实例只有一个固定端口的壳 B，迁移完成后要先把原 A 重建为壳才能迁移下一次。
+// 开池后 Control 预先启动 --pool-size 个壳，各自占用 --pool-ports 范围内的一个 host UDP 端口
+// （podman 壳按 startB 同样的方式挂载 CRIU 与其依赖库），并记录 init PID：
+//   - 每次迁移从池中取一个就绪的壳作为目标（take），取走后后台补足；
+//   - 迁移成功后原 A 在后台重建为新的壳，连同其端口放回池中（recycle）；
+//   - 迁移失败时目标壳可能残留半恢复的进程，直接删除并释放端口（discard）。
+//
+// 池里的壳挂载的是基础 --img-dir：daemon 各实例的镜像子目录都在它下面，任何实例都能使用任何壳。
+
+type pooledShell struct {
+	Name    string    `json:"name"`
+	Port    int       `json:"port"`
+	PID     int       `json:"pid"`
+	Created time.Time `json:"created"`
+}
+
+// poolTakeTimeout 是池空时等待后台补充出一个壳的上限。
+const poolTakeTimeout = 30 * time.Second
+
+type shellPool struct {
+	base   *controlConfig
+	size   int
+	prefix string
+	lo, hi int
+
+	mu        sync.Mutex
+	ready     []pooledShell
+	freePorts []int
+	creating  int
+	seq       int
+	recycleQ  []pooledShell
+	// adopted 是 recycle 收进池的、--pool-ports 范围之外的端口（原 A 的端口），此后同样归池所有。
+	adopted map[int]bool
+	closed  bool
+	// changed 在 ready 变化时关闭并重建（take 据此等待）。
+	changed chan struct{}
+	// wake 唤醒 loop，从不关闭（kick 可能与 close 并发）；stop 关闭时 loop 退出。
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// parsePortRange 解析 "lo-hi"（含两端）。
+func parsePortRange(s string) ([]int, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("bad port range %q (want lo-hi)", s)
+	}
+	a, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	b, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || a <= 0 || b > 65535 || a > b {
+		return nil, fmt.Errorf("bad port range %q", s)
+	}
+	ports := make([]int, 0, b-a+1)
+	for p := a; p <= b; p++ {
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// newShellPool 创建壳池并在后台开始填充。inUse 中的端口（实例的源端口等）不分配给壳。
+func newShellPool(cfg *controlConfig, inUse ...int) (*shellPool, error) {
+	ports, err := parsePortRange(cfg.poolPorts)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi := ports[0], ports[len(ports)-1]
+	used := map[int]bool{}
+	for _, p := range inUse {
+		used[p] = true
+	}
+	free := ports[:0]
+	for _, p := range ports {
+		if !used[p] {
+			free = append(free, p)
+		}
+	}
+	if len(free) < cfg.poolSize {
+		return nil, fmt.Errorf("pool: %d free ports in %s for %d shells", len(free), cfg.poolPorts, cfg.poolSize)
+	}
+	p := &shellPool{
+		base: cfg, size: cfg.poolSize, prefix: "inj-shell", lo: lo, hi: hi,
+		freePorts: free,
+		adopted:   map[int]bool{},
+		changed:   make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.loop()
+	p.kick()
+	return p, nil
+}
+
+func (p *shellPool) kick() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop 是后台补充协程：先把回收的原 A 重建为壳，再新建壳直到就绪数达到 size。
+func (p *shellPool) loop() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+		for {
+			sh, recycled, ok := p.nextJob()
+			if !ok {
+				break
+			}
+			what := "新建"
+			if recycled {
+				what = "回收"
+			}
+			pid, err := p.base.rt.CreateShell(p.base.spec(sh.Name, sh.Port))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[控制端] 警告：壳池%s %s(port=%d) 失败：%v\n", what, sh.Name, sh.Port, err)
+				p.abandon(sh)
+				time.Sleep(time.Second)
+				continue
+			}
+			sh.PID, sh.Created = pid, time.Now()
+			p.base.logf("[控制端] 壳池%s：%s(port=%d pid=%d)\n", what, sh.Name, sh.Port, pid)
+			p.add(sh)
+		}
+	}
+}
+
+// nextJob 取下一个要创建的壳：优先回收队列，其次在就绪数不足时分配新名字与端口。
+func (p *shellPool) nextJob() (sh pooledShell, recycled, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return pooledShell{}, false, false
+	}
+	if len(p.recycleQ) > 0 {
+		sh = p.recycleQ[0]
+		p.recycleQ = p.recycleQ[1:]
+		p.creating++
+		return sh, true, true
+	}
+	if len(p.ready)+p.creating >= p.size || len(p.freePorts) == 0 {
+		return pooledShell{}, false, false
+	}
+	p.seq++
+	sh = pooledShell{Name: fmt.Sprintf("%s-%d", p.prefix, p.seq), Port: p.freePorts[0]}
+	p.freePorts = p.freePorts[1:]
+	p.creating++
+	return sh, false, true
+}
+
+func (p *shellPool) add(sh pooledShell) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.creating--
+	if p.closed {
+		_ = p.base.rt.Remove(sh.Name)
+		return
+	}
+	p.ready = append(p.ready, sh)
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// abandon 放弃创建失败的壳：删除残留并释放端口。
+func (p *shellPool) abandon(sh pooledShell) {
+	_ = p.base.rt.Remove(sh.Name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.creating--
+	p.freePorts = append(p.freePorts, sh.Port)
+}
+
+// take 取一个就绪的壳；池空时最多等待 timeout（期间后台在补充）。
+func (p *shellPool) take(timeout time.Duration) (pooledShell, error) {
+	deadline := time.After(timeout)
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return pooledShell{}, errors.New("pool closed")
+		}
+		if len(p.ready) > 0 {
+			sh := p.ready[0]
+			p.ready = p.ready[1:]
+			p.mu.Unlock()
+			p.kick()
+			return sh, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		p.kick()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return pooledShell{}, fmt.Errorf("no ready shell in pool within %s", timeout)
+		}
+	}
+}
+
+// recycle 把已停止的原 A（名字与端口）放回池中，由后台重建为壳。
+func (p *shellPool) recycle(name string, port int) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = p.base.rt.Remove(name)
+		return
+	}
+	p.recycleQ = append(p.recycleQ, pooledShell{Name: name, Port: port})
+	if port < p.lo || port > p.hi {
+		p.adopted[port] = true
+	}
+	p.mu.Unlock()
+	p.kick()
+}
+
+// discard 删除一个取出后不再可用的壳（例如迁移失败、里面可能残留进程），并释放其端口。
+func (p *shellPool) discard(sh pooledShell) {
+	_ = p.base.rt.Remove(sh.Name)
+	p.mu.Lock()
+	p.freePorts = append(p.freePorts, sh.Port)
+	p.mu.Unlock()
+	p.kick()
+}
+
+// owns 报告 port 是否归池所有：--pool-ports 范围内或经 recycle 收进来（实例不能使用这些端口）。
+func (p *shellPool) owns(port int) bool {
+	if port >= p.lo && port <= p.hi {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.adopted[port]
+}
+
+// snapshot 返回就绪的壳与正在创建/回收的数量。
+func (p *shellPool) snapshot() (ready []pooledShell, pending int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]pooledShell(nil), p.ready...), p.creating + len(p.recycleQ)
+}
+
+// close 停止补充并删除池中所有就绪的壳。nil 可用。
+func (p *shellPool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	ready := p.ready
+	p.ready = nil
+	for _, sh := range p.recycleQ {
+		ready = append(ready, sh)
+	}
+	p.recycleQ = nil
+	close(p.changed)
+	p.mu.Unlock()
+
+	close(p.stop)
+	<-p.done
+	for _, sh := range ready {
+		_ = p.base.rt.Remove(sh.Name)
+	}
+}
+
+// claimShell 从壳池取一个壳作为本次迁移的 B（设置 bName/dstPort/bInitPID），返回是否取了壳。
+// 未开池或实例已有自己的壳（例如 `control up` 启动的 B）时什么都不做。
+func claimShell(c *controlConfig, rec *stepRecorder) (bool, error) {
+	if c.pool == nil || c.bName != "" {
+		return false, nil
+	}
+	err := rec.run("准备：从壳池取 B", func() error {
+		sh, err := c.pool.take(poolTakeTimeout)
+		if err != nil {
+			return err
+		}
+		c.bName, c.dstPort, c.bInitPID = sh.Name, sh.Port, sh.PID
+		c.bPooled = true
+		c.logf("[控制端] 目标壳：%s(port=%d pid=%d)\n", sh.Name, sh.Port, sh.PID)
+		return nil
+	})
+	return err == nil, err
+}
+
+// releaseShell 在迁移失败后丢弃 claimShell 取出的壳。
+func releaseShell(c *controlConfig) {
+	c.pool.discard(pooledShell{Name: c.bName, Port: c.dstPort})
+	c.bName, c.dstPort, c.bInitPID = "", 0, 0
+	c.bPooled = false
+}
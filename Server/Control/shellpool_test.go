@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 壳池：fake 运行时的壳是 sleep 进程，不需要 server 或 podman。
+
+func TestShellPoolRecycleAndClose(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fake runtime needs /proc")
+	}
+	cfg := &controlConfig{poolPorts: "6100-6101", poolSize: 1, rt: &fakeRuntime{stateDir: t.TempDir()}, out: io.Discard}
+	p, err := newShellPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := p.take(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 原 A 的端口在 --pool-ports 之外，归还后同样归池所有。
+	p.recycle("inj-src-a", 7100)
+	for _, tc := range []struct {
+		port int
+		want bool
+	}{{6100, true}, {6101, true}, {7100, true}, {7101, false}} {
+		if got := p.owns(tc.port); got != tc.want {
+			t.Errorf("owns(%d) = %v, want %v", tc.port, got, tc.want)
+		}
+	}
+
+	// close 与 kick/discard/recycle 并发，之后再调用也不能 panic。
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.kick()
+			}
+		}()
+	}
+	p.close()
+	wg.Wait()
+	p.discard(sh)
+	p.recycle("inj-src-b", 7102)
+	if _, err := p.take(time.Second); err == nil {
+		t.Fatal("take after close succeeded")
+	}
+}
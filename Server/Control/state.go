@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Liangxia6/Wrapper/Server/sWrapper"
+)
+
+// 状态库（--state-dir，默认 <工作目录>/state）。
+//
+// Control 的每次调用都是独立进程（daemon 也可能崩溃重启），实例与迁移的状态不能只放在内存里：
+//   - instances：实例记录（与 daemon 的 instance 相同），up/migrate/down 与 daemon 更新，
+//     之后的 `control migrate` 据此找到当前的源/壳、端口与源进程 PID，而不是按 --a-name/--b-name 推导；
+//   - migrations：迁移日志。doMigrate 开始时写入 running，每推进一个阶段（见 rollback.go）更新
+//     phase、源/恢复进程 PID、最后一轮 pre-dump 目录与 post-copy 的 CRIU 进程，结束时写入结果。
+//     dump 之后的 stopped/lazy 处在服务中断期间，由后台按顺序写入（phaseAsync），不增加停机时间；
+//     崩溃时日志可能仍停在 armed，resume 据源进程是否存活与 dump 镜像判断实际阶段。
+//
+// 记录 running 却找不到其 Control 进程（owner PID + 启动时间）的迁移就是被崩溃中断的：
+// `control status` 标出它们，`control resume`（daemon 启动时自动执行）按记录的阶段回滚或补完，见 resumeMigration。
+//
+// 存储是单个 JSON 文件：每次更新都在 flock 下读-改-写，经临时文件 rename 原子替换，多个 Control 进程可以同时使用。
+
+const (
+	stateFile = "state.json"
+	stateLock = "state.lock"
+	// maxHistory 是保留的迁移记录数（超出时丢弃最早的已结束记录）。
+	maxHistory = 1000
+
+	// migInterrupted 是 Control 崩溃时仍在进行的迁移（status 中显示；resume 之前不会写入状态库）。
+	migInterrupted = "interrupted"
+	// migRecovered 是 resume 后服务回到了源端的迁移。
+	migRecovered = "recovered"
+)
+
+type stateData struct {
+	Instances  map[string]*instance `json:"instances"`
+	Migrations []*migrationRecord   `json:"migrations"`
+}
+
+// migrationRecord 是一次迁移的日志；字段足以在 Control 崩溃后重建回滚所需的配置。
+type migrationRecord struct {
+	ID       string `json:"id"`
+	Instance string `json:"instance"`
+	State    string `json:"state"`
+	Phase    string `json:"phase"`
+	Mode     string `json:"mode"`
+	// Owner/OwnerStart 是执行迁移的 Control 进程及其启动时间（/proc/<pid>/stat），用于判断它是否还活着。
+	Owner      int    `json:"owner"`
+	OwnerStart uint64 `json:"owner_start"`
+
+	Src         string `json:"src"`
+	Shell       string `json:"shell"`
+	SrcPort     int    `json:"src_port"`
+	DstPort     int    `json:"dst_port"`
+	Pooled      bool   `json:"pooled,omitempty"`
+	ImgDir      string `json:"img_dir"`
+	To          string `json:"to,omitempty"`
+	RemoteAgent string `json:"remote_agent,omitempty"`
+
+	SrcPID         int    `json:"src_pid,omitempty"`
+	PredumpLastDir string `json:"predump_last_dir,omitempty"`
+	RestoredPID    int    `json:"restored_pid,omitempty"`
+	// LazyPIDs 是 post-copy 中仍在运行的 CRIU 进程（sudo）PID。
+	LazyPIDs []int `json:"lazy_pids,omitempty"`
+
+	Error    string    `json:"error,omitempty"`
+	Rollback string    `json:"rollback,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	Resumed  time.Time `json:"resumed,omitempty"`
+}
+
+// interrupted 报告记录为 running 的迁移是否已失去执行它的 Control 进程。
+func (m *migrationRecord) interrupted() bool {
+	return m.State == jobRunning && procStart(m.Owner) != m.OwnerStart
+}
+
+type stateStore struct {
+	dir string
+}
+
+func openStateStore(dir string) (*stateStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &stateStore{dir: dir}, nil
+}
+
+// openStore 打开 cfg.stateDir 的状态库；只有 up/migrate/down/serve 与 status/history/resume 调用。
+// 失败时只告警：cfg.store 为 nil，迁移照常进行但不记录（读状态的子命令由 mustLoadState 报错）。
+func (cfg *controlConfig) openStore() {
+	st, err := openStateStore(cfg.stateDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[控制端] 警告：状态库不可用：%v\n", err)
+		return
+	}
+	cfg.store = st
+}
+
+// load 读取当前状态（写入经 rename 原子替换，读取不需要加锁）。
+func (s *stateStore) load() (*stateData, error) {
+	d := &stateData{Instances: map[string]*instance{}}
+	if s == nil {
+		return d, nil
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, d); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(s.dir, stateFile), err)
+	}
+	if d.Instances == nil {
+		d.Instances = map[string]*instance{}
+	}
+	return d, nil
+}
+
+// update 在文件锁下读-改-写；fn 返回错误时不写入。nil 可用：什么都不做。
+func (s *stateStore) update(fn func(d *stateData) error) error {
+	if s == nil {
+		return nil
+	}
+	lf, err := os.OpenFile(filepath.Join(s.dir, stateLock), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	if err := syscall.Flock(int(lf.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lf.Fd()), syscall.LOCK_UN)
+
+	d, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(d); err != nil {
+		return err
+	}
+	if n := len(d.Migrations) - maxHistory; n > 0 {
+		kept := d.Migrations[:0]
+		for _, m := range d.Migrations {
+			if n > 0 && m.State != jobRunning {
+				n--
+				continue
+			}
+			kept = append(kept, m)
+		}
+		d.Migrations = kept
+	}
+
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, stateFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, stateFile))
+}
+
+// save 是 update 的“尽力而为”版本：失败只打印警告，不影响迁移本身。
+func (s *stateStore) save(fn func(d *stateData) error) {
+	if err := s.update(fn); err != nil {
+		fmt.Fprintf(os.Stderr, "[控制端] 警告：写状态库失败：%v\n", err)
+	}
+}
+
+func (s *stateStore) putInstance(in instance) {
+	s.save(func(d *stateData) error {
+		d.Instances[in.Name] = &in
+		return nil
+	})
+}
+
+func (s *stateStore) deleteInstance(name string) {
+	s.save(func(d *stateData) error {
+		delete(d.Instances, name)
+		return nil
+	})
+}
+
+// instance 返回实例记录。nil 可用。
+func (s *stateStore) instance(name string) (instance, bool) {
+	if s == nil {
+		return instance{}, false
+	}
+	d, err := s.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[控制端] 警告：读状态库失败：%v\n", err)
+		return instance{}, false
+	}
+	in, ok := d.Instances[name]
+	if !ok {
+		return instance{}, false
+	}
+	return *in, true
+}
+
+// instanceKey 是配置所属实例在状态库中的名字（单个 A/B 记为 default，与 daemon 一致）。
+func (c *controlConfig) instanceKey() string {
+	if c.instanceName == "" {
+		return "default"
+	}
+	return c.instanceName
+}
+
+// instanceRecord 由配置生成实例记录（CLI 使用；daemon 直接保存它的 instance）。
+func instanceRecord(c *controlConfig, state string) instance {
+	pid := c.srcPID
+	if pid == 0 {
+		pid = c.aInitPID
+	}
+	return instance{
+		Name: c.instanceKey(), Slot: c.slot, Src: c.aName, Shell: c.bName, SrcPort: c.srcPort, DstPort: c.dstPort,
+		Host: "127.0.0.1", PID: pid, ImgDir: c.imgDir, State: state,
+	}
+}
+
+// applyInstance 用实例记录覆盖配置中的源/壳、端口、镜像目录与源进程 PID。
+func applyInstance(c *controlConfig, in instance) {
+	c.aName, c.bName = in.Src, in.Shell
+	c.srcPort, c.dstPort = in.SrcPort, in.DstPort
+	// 记录的 PID 已不在（例如容器被外部重启）时仍从运行时取。
+	c.srcPID = 0
+	if in.PID > 0 && sudoKill0(in.PID) == nil {
+		c.srcPID = in.PID
+	}
+	if in.ImgDir != "" {
+		c.imgDir = in.ImgDir
+	}
+}
+
+// useStoredInstance 让 CLI migrate 以状态库中的实例记录为准（存在时）。
+func useStoredInstance(c *controlConfig) {
+	in, ok := c.store.instance(c.instanceKey())
+	if !ok {
+		return
+	}
+	applyInstance(c, in)
+	if c.bName == "" {
+		dief("migrate: instance %s has no shell to migrate into (run `control up` again)", in.Name)
+	}
+}
+
+// recordMigrated 在 CLI 迁移结束后更新实例记录：成功时服务在原壳里（原源容器已停止，不再是壳），
+// 回滚成功时仍在源端，否则标为 failed。
+func recordMigrated(c *controlConfig, host string, err error) {
+	in, ok := c.store.instance(c.instanceKey())
+	if !ok {
+		in = instanceRecord(c, instRunning)
+	}
+	var re *rollbackError
+	switch {
+	case err == nil:
+		in.Src, in.SrcPort, in.PID = c.bName, c.dstPort, c.restoredPID
+		in.Shell, in.DstPort = "", 0
+		if host != "" {
+			in.Host = host
+		}
+		in.State = instRunning
+	case errors.As(err, &re) && re.Recovered:
+		in.PID = re.PID
+		in.State = instRunning
+	default:
+		in.State = instFailed
+	}
+	c.store.putInstance(in)
+}
+
+// migrateHost 返回迁移后 client 访问服务的主机（--to 的主机部分；未指定时为空，不更新）。
+func migrateHost(c *controlConfig) string {
+	if h, _, err := wrapper.SplitTarget(c.migrateTo); err == nil {
+		return h
+	}
+	return ""
+}
+
+// migrationJournal 把一次迁移的推进写进状态库。nil 可用（没有状态库时）。
+// 所有更新经 q 由 loop 按顺序写入；record 等到写完才返回，async 立即返回。
+type migrationJournal struct {
+	s  *stateStore
+	id string
+
+	q  chan func(m *migrationRecord)
+	wg sync.WaitGroup
+}
+
+func (s *stateStore) begin(id string, cfg *controlConfig) *migrationJournal {
+	if s == nil {
+		return nil
+	}
+	self := os.Getpid()
+	m := &migrationRecord{
+		ID: id, Instance: cfg.instanceKey(), State: jobRunning, Phase: phaseIdle.String(), Mode: cfg.mode,
+		Owner: self, OwnerStart: procStart(self),
+		Src: cfg.aName, Shell: cfg.bName, SrcPort: cfg.srcPort, DstPort: cfg.dstPort, Pooled: cfg.bPooled,
+		ImgDir: cfg.imgDir, To: cfg.migrateTo, RemoteAgent: cfg.remoteAgent,
+		SrcPID: cfg.srcPID, Started: time.Now(),
+	}
+	s.save(func(d *stateData) error {
+		d.Migrations = append(d.Migrations, m)
+		return nil
+	})
+	j := &migrationJournal{s: s, id: id, q: make(chan func(m *migrationRecord), 8)}
+	go j.loop()
+	return j
+}
+
+func (j *migrationJournal) loop() {
+	for fn := range j.q {
+		j.s.save(func(d *stateData) error {
+			for _, m := range d.Migrations {
+				if m.ID == j.id {
+					fn(m)
+					return nil
+				}
+			}
+			return fmt.Errorf("migration %s not found", j.id)
+		})
+		j.wg.Done()
+	}
+}
+
+func (j *migrationJournal) async(fn func(m *migrationRecord)) {
+	if j == nil {
+		return
+	}
+	j.wg.Add(1)
+	j.q <- fn
+}
+
+func (j *migrationJournal) record(fn func(m *migrationRecord)) {
+	if j == nil {
+		return
+	}
+	j.async(fn)
+	j.wg.Wait()
+}
+
+// phase 记录迁移进入阶段 p 以及此时回滚需要的 PID 与目录。
+func (j *migrationJournal) phase(p migPhase, cfg *controlConfig) {
+	j.record(phaseUpdate(p, cfg))
+}
+
+// phaseAsync 与 phase 相同但不等待写入（用在服务中断期间）。
+func (j *migrationJournal) phaseAsync(p migPhase, cfg *controlConfig) {
+	j.async(phaseUpdate(p, cfg))
+}
+
+// phaseUpdate 在调用时取下 cfg 中的值：异步写入时 doMigrate 已继续修改 cfg。
+func phaseUpdate(p migPhase, cfg *controlConfig) func(m *migrationRecord) {
+	srcPID, lastDir, restored := cfg.aInitPID, cfg.predumpLastDir, cfg.restoredPID
+	var lazyPIDs []int
+	if l, ok := cfg.lazy.(interface{ procPIDs() []int }); ok {
+		lazyPIDs = l.procPIDs()
+	}
+	return func(m *migrationRecord) {
+		m.Phase = p.String()
+		if srcPID > 0 {
+			m.SrcPID = srcPID
+		}
+		m.PredumpLastDir = lastDir
+		m.RestoredPID = restored
+		m.LazyPIDs = lazyPIDs
+	}
+}
+
+// finish 写入结果并结束 loop。
+func (j *migrationJournal) finish(cfg *controlConfig, err error) {
+	if j == nil {
+		return
+	}
+	restored := cfg.restoredPID
+	j.record(func(m *migrationRecord) {
+		m.State = jobDone
+		m.RestoredPID = restored
+		m.Finished = time.Now()
+		if err != nil {
+			m.State = jobFailed
+			m.Error = err.Error()
+			var re *rollbackError
+			if errors.As(err, &re) {
+				m.Rollback = re.outcome()
+			}
+		}
+	})
+	close(j.q)
+}
+
+// procStart 返回进程的启动时间（/proc/<pid>/stat 第 22 列，单位 clock tick）；进程不存在时为 0。
+// 与 PID 一起判断“还是不是当初那个进程”，避免 PID 复用。
+func procStart(pid int) uint64 {
+	if pid <= 0 {
+		return 0
+	}
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	// comm 可能含空格，从最后一个 ')' 之后开始数（第 3 列起）。
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return 0
+	}
+	f := strings.Fields(string(b[i+1:]))
+	if len(f) < 20 {
+		return 0
+	}
+	v, _ := strconv.ParseUint(f[19], 10, 64)
+	return v
+}
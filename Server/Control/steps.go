@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// stepRecorder 执行并记录迁移步骤。
+//
+// CLI 仍沿用 step()（失败即 panic，由命令入口统一 recover + 清理）；
+// daemon 的迁移任务需要在失败后继续服务，并对外暴露每一步的进度，
+// 因此 doMigrate 通过 stepRecorder 执行步骤、以 error 返回失败。
+//
+// nil *stepRecorder 可用：只打印、不记录。out 是步骤日志的去向（controlConfig.out；nil 为 stdout）。
+type stepRecorder struct {
+	out io.Writer
+
+	mu    sync.Mutex
+	steps []stepRecord
+}
+
+type stepRecord struct {
+	Name  string    `json:"name"`
+	State string    `json:"state"` // running / done / failed
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+const (
+	stepRunning = "running"
+	stepDone    = "done"
+	stepFailed  = "failed"
+)
+
+func (r *stepRecorder) run(name string, fn func() error) error {
+	out := io.Writer(os.Stdout)
+	if r != nil && r.out != nil {
+		out = r.out
+	}
+	fmt.Fprintf(out, "[控制端] 步骤：%s\n", name)
+	idx := r.begin(name)
+	err := fn()
+	r.finish(idx, err)
+	if err != nil {
+		return fmt.Errorf("步骤失败：%s：%w", name, err)
+	}
+	return nil
+}
+
+func (r *stepRecorder) begin(name string) int {
+	if r == nil {
+		return -1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, stepRecord{Name: name, State: stepRunning, Start: time.Now()})
+	return len(r.steps) - 1
+}
+
+func (r *stepRecorder) finish(idx int, err error) {
+	if r == nil || idx < 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &r.steps[idx]
+	s.End = time.Now()
+	s.State = stepDone
+	if err != nil {
+		s.State = stepFailed
+		s.Error = err.Error()
+	}
+}
+
+// snapshot 返回步骤记录的副本（供 HTTP API 序列化）。
+func (r *stepRecorder) snapshot() []stepRecord {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]stepRecord(nil), r.steps...)
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 跨主机迁移：源端 Control 把 CRIU 镜像目录经 TCP 推给目标主机上的 Control agent，
+// 由 agent 在本机 B 中完成 nsenter restore + rebind。
+//
+// 协议（单条 TCP 连接，一次迁移）：
+//   - 每条消息是一行 JSON（xferMsg）；op=file 时其后紧跟 size 字节的文件内容。
+//   - 源端：begin → (mkdir/file/symlink/page-server)* → restore。begin 携带双方共享的 --agent-token，
+//     agent 以 root 运行并写本机文件，令牌不符或第一条消息不是 begin 时直接断开。
+//   - agent：begin、page-server 与 restore 各回复一行 xferReply；其余消息不回复，出错时回复错误并断开。
+//   - page-server（--page-server，见 pageserver.go）：请求 agent 为目录 path 启动 criu page-server（parent 为 target），
+//     回复时已开始监听；restore 前 agent 等它退出。
+//
+// 流水线：pre-dump 第 i 轮完成后立即入队传输，与第 i+1 轮 pre-dump 并行；
+// final dump 之后只需再传顶层的小文件与最后一轮增量。
+//
+// 路径均相对各自的 --img-dir；两端 img-dir 可以不同（例如同机 loopback 测试）。
+// agent 只在 img-dir 之内写：路径经过的每一级都必须是真实目录（不跟随符号链接），文件以 O_NOFOLLOW 打开，
+// 符号链接（CRIU 的 parent 链接）的目标必须是解析后仍在 img-dir 之内的相对路径。
+
+type xferMsg struct {
+	Op     string `json:"op"`
+	ID     string `json:"id,omitempty"`
+	Token  string `json:"token,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Target string `json:"target,omitempty"`
+	Mode   uint32 `json:"mode,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Port   int    `json:"port,omitempty"`
+}
+
+type xferReply struct {
+	OK          bool   `json:"ok"`
+	Error       string `json:"error,omitempty"`
+	RestoredPID int    `json:"restored_pid,omitempty"`
+	TookMS      int64  `json:"took_ms,omitempty"`
+}
+
+// xferStat 记录一次目录传输的结果（用于日志/报告）。
+type xferStat struct {
+	Dir   string
+	Files int
+	Bytes int64
+	Took  time.Duration
+}
+
+// imgSender 是源端的传输器：一个后台 goroutine 顺序处理目录传输队列。
+type imgSender struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	imgDir string
+
+	queue chan string
+	wg    sync.WaitGroup
+
+	mu    sync.Mutex
+	err   error
+	stats []xferStat
+}
+
+func dialAgent(addr, imgDir, id, token string) (*imgSender, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &imgSender{
+		conn:   conn,
+		br:     bufio.NewReader(conn),
+		bw:     bufio.NewWriterSize(conn, 1<<20),
+		imgDir: imgDir,
+		queue:  make(chan string, 16),
+	}
+	if err := s.writeMsg(xferMsg{Op: "begin", ID: id, Token: token}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if _, err := s.readReply(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("agent begin: %w", err)
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *imgSender) loop() {
+	for rel := range s.queue {
+		if s.failed() != nil {
+			s.wg.Done()
+			continue
+		}
+		st, err := s.sendTree(rel)
+		s.mu.Lock()
+		if err != nil && s.err == nil {
+			s.err = fmt.Errorf("transfer %s: %w", rel, err)
+		}
+		if err == nil {
+			s.stats = append(s.stats, st)
+		}
+		s.mu.Unlock()
+		s.wg.Done()
+	}
+}
+
+func (s *imgSender) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// sendDirAsync 把 imgDir 下的子目录 rel 加入传输队列，立即返回。
+func (s *imgSender) sendDirAsync(rel string) {
+	s.wg.Add(1)
+	s.queue <- rel
+}
+
+// sendTop 等待队列清空后，同步传输 imgDir 顶层（不递归进已传过的子目录）。
+func (s *imgSender) sendTop(skip map[string]bool) (xferStat, error) {
+	s.wg.Wait()
+	if err := s.failed(); err != nil {
+		return xferStat{}, err
+	}
+	start := time.Now()
+	st := xferStat{Dir: "."}
+	entries, err := os.ReadDir(s.imgDir)
+	if err != nil {
+		return st, err
+	}
+	for _, e := range entries {
+		if skip[e.Name()] {
+			continue
+		}
+		if e.IsDir() {
+			sub, err := s.sendTree(e.Name())
+			if err != nil {
+				return st, err
+			}
+			st.Files += sub.Files
+			st.Bytes += sub.Bytes
+			continue
+		}
+		n, err := s.sendEntry(e.Name())
+		if err != nil {
+			return st, err
+		}
+		st.Files++
+		st.Bytes += n
+	}
+	if err := s.bw.Flush(); err != nil {
+		return st, err
+	}
+	st.Took = time.Since(start)
+	return st, nil
+}
+
+func (s *imgSender) sendTree(rel string) (xferStat, error) {
+	start := time.Now()
+	st := xferStat{Dir: rel}
+	root := filepath.Join(s.imgDir, rel)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		r, err := filepath.Rel(s.imgDir, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return s.writeMsg(xferMsg{Op: "mkdir", Path: r})
+		}
+		n, err := s.sendEntry(r)
+		if err != nil {
+			return err
+		}
+		st.Files++
+		st.Bytes += n
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+	if err := s.bw.Flush(); err != nil {
+		return st, err
+	}
+	st.Took = time.Since(start)
+	return st, nil
+}
+
+// sendEntry 传输单个文件或符号链接（CRIU 的 parent 链接必须原样保留）。
+func (s *imgSender) sendEntry(rel string) (int64, error) {
+	p := filepath.Join(s.imgDir, rel)
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return 0, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return 0, err
+		}
+		return 0, s.writeMsg(xferMsg{Op: "symlink", Path: rel, Target: target})
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := s.writeMsg(xferMsg{Op: "file", Path: rel, Mode: uint32(fi.Mode().Perm()), Size: fi.Size()}); err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(s.bw, f, fi.Size())
+	return n, err
+}
+
+// pageServer 请求 agent 为目录 rel 启动 page-server，返回时已可连接。
+// 先等传输队列清空：队列 goroutine 与这里共用同一个写缓冲。
+func (s *imgSender) pageServer(rel, parent string, port int) error {
+	s.wg.Wait()
+	if err := s.failed(); err != nil {
+		return err
+	}
+	if err := s.writeMsg(xferMsg{Op: "page-server", Path: rel, Target: parent, Port: port}); err != nil {
+		return err
+	}
+	if err := s.bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.readReply(); err != nil {
+		return fmt.Errorf("agent page-server: %w", err)
+	}
+	return nil
+}
+
+// restore 请求 agent 在目标主机的 B 中 restore + rebind，返回恢复出的 PID。
+func (s *imgSender) restore(id string) (xferReply, error) {
+	if err := s.writeMsg(xferMsg{Op: "restore", ID: id}); err != nil {
+		return xferReply{}, err
+	}
+	if err := s.bw.Flush(); err != nil {
+		return xferReply{}, err
+	}
+	return s.readReply()
+}
+
+func (s *imgSender) close() {
+	close(s.queue)
+	_ = s.conn.Close()
+}
+
+func (s *imgSender) writeMsg(m xferMsg) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.bw.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	if m.Op == "begin" {
+		return s.bw.Flush()
+	}
+	return nil
+}
+
+func (s *imgSender) readReply() (xferReply, error) {
+	line, err := s.br.ReadBytes('\n')
+	if err != nil {
+		return xferReply{}, err
+	}
+	var r xferReply
+	if err := json.Unmarshal(line, &r); err != nil {
+		return xferReply{}, fmt.Errorf("bad agent reply: %w", err)
+	}
+	if !r.OK {
+		return r, errors.New(r.Error)
+	}
+	return r, nil
+}
+
+// receiveImages 是 agent 侧的处理循环：校验 begin 的令牌，把镜像写入 imgDir，收到 page-server/restore 时回调 pageServer/doRestore。
+func receiveImages(conn net.Conn, imgDir, token string, reset func() error, pageServer func(dir, parent string, port int) error, doRestore func(id string) (int, error)) error {
+	br := bufio.NewReaderSize(conn, 1<<20)
+	bw := bufio.NewWriter(conn)
+	reply := func(r xferReply) error {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	fail := func(err error) error {
+		_ = reply(xferReply{Error: err.Error()})
+		return err
+	}
+
+	authed := false
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var m xferMsg
+		if err := json.Unmarshal(line, &m); err != nil {
+			return fail(fmt.Errorf("bad message: %w", err))
+		}
+		if !authed && m.Op != "begin" {
+			return fail(fmt.Errorf("op %q before begin", m.Op))
+		}
+
+		switch m.Op {
+		case "begin":
+			if token == "" || subtle.ConstantTimeCompare([]byte(m.Token), []byte(token)) != 1 {
+				return fail(errors.New("bad agent token"))
+			}
+			authed = true
+			if err := reset(); err != nil {
+				return fail(err)
+			}
+			if err := reply(xferReply{OK: true}); err != nil {
+				return err
+			}
+		case "mkdir", "file", "symlink":
+			if err := applyEntry(br, imgDir, m); err != nil {
+				return fail(err)
+			}
+		case "page-server":
+			dst, err := safeJoin(imgDir, m.Path)
+			if err != nil {
+				return fail(err)
+			}
+			if err := mkdirBeneath(imgDir, dst); err != nil {
+				return fail(err)
+			}
+			parent := ""
+			if m.Target != "" {
+				if parent, err = targetBeneath(imgDir, dst, m.Target); err != nil {
+					return fail(err)
+				}
+			}
+			if err := pageServer(dst, parent, m.Port); err != nil {
+				return fail(err)
+			}
+			if err := reply(xferReply{OK: true}); err != nil {
+				return err
+			}
+		case "restore":
+			start := time.Now()
+			pid, err := doRestore(m.ID)
+			if err != nil {
+				return fail(err)
+			}
+			if err := reply(xferReply{OK: true, RestoredPID: pid, TookMS: time.Since(start).Milliseconds()}); err != nil {
+				return err
+			}
+		default:
+			return fail(fmt.Errorf("unknown op: %q", m.Op))
+		}
+	}
+}
+
+// applyEntry 在 root 之下创建 m 描述的目录、符号链接或文件（文件内容从 r 读取 m.Size 字节）。
+func applyEntry(r io.Reader, root string, m xferMsg) error {
+	dst, err := safeJoin(root, m.Path)
+	if err != nil {
+		return err
+	}
+	switch m.Op {
+	case "mkdir":
+		return mkdirBeneath(root, dst)
+	case "symlink":
+		target, err := targetBeneath(root, filepath.Dir(dst), m.Target)
+		if err != nil {
+			return err
+		}
+		if err := mkdirBeneath(root, filepath.Dir(dst)); err != nil {
+			return err
+		}
+		_ = os.Remove(dst)
+		return os.Symlink(target, dst)
+	}
+	if err := mkdirBeneath(root, filepath.Dir(dst)); err != nil {
+		return err
+	}
+	mode := os.FileMode(m.Mode)
+	if mode == 0 {
+		mode = 0o644
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, m.Size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// safeJoin 拒绝绝对路径与 ".."，保证路径文本在 root 之内（路径中的符号链接由 mkdirBeneath 检查）。
+func safeJoin(root, rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("bad path: %q", rel)
+	}
+	clean := filepath.Clean(rel)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("bad path: %q", rel)
+	}
+	return filepath.Join(root, clean), nil
+}
+
+// mkdirBeneath 逐级创建 root 之下的目录 dir（dir 由 safeJoin 得到）。已存在的每一级都必须是真实目录：
+// Lstat 不跟随符号链接，经过符号链接的路径一律拒绝。
+func mkdirBeneath(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return os.MkdirAll(root, 0o755)
+	}
+	p := root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, name)
+		fi, err := os.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			err = os.Mkdir(p, 0o755)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, os.ErrExist) {
+				return err
+			}
+			fi, err = os.Lstat(p)
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("bad path: %q is not a directory", rel)
+		}
+	}
+	return nil
+}
+
+// targetBeneath 校验位于目录 base 的符号链接目标 target（或 page-server 的 parent 目录，相对 base），
+// 返回 Clean 后的目标：必须是相对路径，且从 base 解析后仍在 root 之内。
+// Clean 后 ".." 只会出现在开头，而 base 的每一级都是真实目录，因此文本解析与内核解析一致。
+func targetBeneath(root, base, target string) (string, error) {
+	if target == "" || filepath.IsAbs(target) {
+		return "", fmt.Errorf("bad link target: %q", target)
+	}
+	clean := filepath.Clean(target)
+	rel, err := filepath.Rel(root, filepath.Join(base, clean))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("bad link target: %q", target)
+	}
+	return clean, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testToken = "test-token"
+
+// startAgent 在 127.0.0.1 上运行一次 receiveImages（写入 root），返回地址与其结果。
+func startAgent(t *testing.T, root string, restore func(id string) (int, error)) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	if restore == nil {
+		restore = func(string) (int, error) { return 0, nil }
+	}
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		errc <- receiveImages(conn, root, testToken,
+			func() error { return clearDir(root) },
+			func(dir, parent string, port int) error { return nil },
+			restore)
+	}()
+	return ln.Addr().String(), errc
+}
+
+func writeTree(t *testing.T, root string, files map[string]string, links map[string]string) {
+	t.Helper()
+	for rel, data := range files {
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for rel, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, rel)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// compareTrees 逐项比较两个目录：类型、文件内容与权限、符号链接目标。
+func compareTrees(t *testing.T, want, got string) {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(want, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(want, p)
+		wi, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		gi, err := os.Lstat(filepath.Join(got, rel))
+		if err != nil {
+			t.Errorf("%s: %v", rel, err)
+			return nil
+		}
+		n++
+		switch {
+		case wi.Mode()&os.ModeSymlink != 0:
+			wt, _ := os.Readlink(p)
+			gt, err := os.Readlink(filepath.Join(got, rel))
+			if err != nil || gt != wt {
+				t.Errorf("%s: link %q, want %q (%v)", rel, gt, wt, err)
+			}
+		case wi.IsDir():
+			if !gi.IsDir() {
+				t.Errorf("%s: not a directory", rel)
+			}
+		default:
+			wb, _ := os.ReadFile(p)
+			gb, err := os.ReadFile(filepath.Join(got, rel))
+			if err != nil || !bytes.Equal(wb, gb) {
+				t.Errorf("%s: content differs (%v)", rel, err)
+			}
+			if gi.Mode().Perm() != wi.Mode().Perm() {
+				t.Errorf("%s: mode %v, want %v", rel, gi.Mode().Perm(), wi.Mode().Perm())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 2 {
+		t.Fatalf("compared only %d entries", n)
+	}
+}
+
+func TestTransferLoopback(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	big := strings.Repeat("0123456789abcdef", 1<<16)
+	writeTree(t, src,
+		map[string]string{
+			"pre-0/pages-1.img":   big,
+			"pre-0/pagemap-1.img": "pm0",
+			"pre-1/pages-1.img":   "delta",
+			"pre-1/sub/x.img":     "nested",
+			"core-1.img":          "core",
+			"inventory.img":       "",
+		},
+		map[string]string{
+			"pre-1/parent": "../pre-0",
+			"parent":       "pre-1",
+		})
+	// 旧内容应在 begin 时被清掉。
+	writeTree(t, dst, map[string]string{"stale.img": "old"}, nil)
+
+	addr, errc := startAgent(t, dst, func(id string) (int, error) {
+		if id != "m-1" {
+			return 0, errors.New("unexpected id " + id)
+		}
+		return 4242, nil
+	})
+	s, err := dialAgent(addr, src, "m-1", testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sendDirAsync("pre-0")
+	s.sendDirAsync("pre-1")
+	if _, err := s.sendTop(map[string]bool{"pre-0": true, "pre-1": true}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.restore("m-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RestoredPID != 4242 {
+		t.Fatalf("restored pid %d, want 4242", r.RestoredPID)
+	}
+	s.close()
+	if err := <-errc; err != nil {
+		t.Fatalf("agent: %v", err)
+	}
+	compareTrees(t, src, dst)
+	if _, err := os.Lstat(filepath.Join(dst, "stale.img")); !os.IsNotExist(err) {
+		t.Fatalf("stale file survived begin: %v", err)
+	}
+}
+
+func msgLine(m xferMsg) string {
+	b, _ := json.Marshal(m)
+	return string(b) + "\n"
+}
+
+// TestTransferRejects 用原始消息驱动 agent：每个用例都必须被拒绝，且 root 之外不能出现任何文件。
+func TestTransferRejects(t *testing.T) {
+	begin := msgLine(xferMsg{Op: "begin", ID: "m", Token: testToken})
+	file := func(path, data string) string {
+		return msgLine(xferMsg{Op: "file", Path: path, Size: int64(len(data))}) + data
+	}
+	cases := []struct {
+		name string
+		// msgs 返回发给 agent 的原始消息流；outside 是 img-dir 之外的目录。
+		msgs func(outside string) string
+	}{
+		{"no token", func(string) string { return msgLine(xferMsg{Op: "begin", ID: "m"}) }},
+		{"bad token", func(string) string { return msgLine(xferMsg{Op: "begin", ID: "m", Token: "nope"}) }},
+		{"op before begin", func(string) string { return file("a.img", "x") }},
+		{"dotdot path", func(string) string { return begin + file("../escape.img", "x") }},
+		{"absolute path", func(o string) string { return begin + file(filepath.Join(o, "escape.img"), "x") }},
+		{"absolute link target", func(o string) string {
+			return begin + msgLine(xferMsg{Op: "symlink", Path: "evil", Target: o})
+		}},
+		{"relative link escape", func(string) string {
+			return begin + msgLine(xferMsg{Op: "symlink", Path: "d/evil", Target: "../../outside"})
+		}},
+		{"link escape through dotdot", func(string) string {
+			return begin + msgLine(xferMsg{Op: "symlink", Path: "evil", Target: "a/../.."})
+		}},
+		{"file through inside link", func(string) string {
+			// 指向 root 之内的链接本身合法，但不能作为路径的中间一级。
+			return begin + msgLine(xferMsg{Op: "mkdir", Path: "real"}) +
+				msgLine(xferMsg{Op: "symlink", Path: "ok", Target: "real"}) + file("ok/x.img", "x")
+		}},
+		{"file onto link", func(string) string {
+			return begin + msgLine(xferMsg{Op: "mkdir", Path: "real"}) +
+				msgLine(xferMsg{Op: "symlink", Path: "ok", Target: "real/f"}) + file("ok", "x")
+		}},
+		{"page-server parent escape", func(string) string {
+			return begin + msgLine(xferMsg{Op: "page-server", Path: "pre-1", Target: "../../outside"})
+		}},
+		{"page-server dotdot path", func(string) string {
+			return begin + msgLine(xferMsg{Op: "page-server", Path: "../pre-1"})
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := t.TempDir()
+			root := filepath.Join(base, "img")
+			outside := filepath.Join(base, "outside")
+			for _, d := range []string{root, outside} {
+				if err := os.Mkdir(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			addr, errc := startAgent(t, root, nil)
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte(tc.msgs(outside))); err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.(*net.TCPConn).CloseWrite()
+			if err := <-errc; err == nil {
+				t.Fatal("agent accepted the session")
+			}
+			entries, err := os.ReadDir(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Fatalf("wrote outside img-dir: %v", entries)
+			}
+			if _, err := os.Lstat(filepath.Join(base, "escape.img")); !os.IsNotExist(err) {
+				t.Fatalf("wrote next to img-dir: %v", err)
+			}
+		})
+	}
+}
@@ -8,15 +8,19 @@ import (
 )
 
 // 控制流协议：JSON + \n 分帧。
-// - server -> client: migrate
+// - server -> client: migrate / abort（放弃 ID 对应的迁移）/ retarget（改投新目标）/
+//   commit（B restore + rebind 完成，client 可以 cutover）
 // - client -> server: ack
 
 type MessageType string
 
 const (
-	TypeHello   MessageType = "hello"
-	TypeMigrate MessageType = "migrate"
-	TypeAck     MessageType = "ack"
+	TypeHello    MessageType = "hello"
+	TypeMigrate  MessageType = "migrate"
+	TypeAbort    MessageType = "abort"
+	TypeRetarget MessageType = "retarget"
+	TypeCommit   MessageType = "commit"
+	TypeAck      MessageType = "ack"
 )
 
 type Message struct {
@@ -26,7 +30,7 @@ type Message struct {
 	// hello
 	ClientID string `json:"client_id,omitempty"`
 
-	// migrate
+	// migrate / retarget
 	NewAddr string `json:"new_addr,omitempty"`
 	NewPort int    `json:"new_port,omitempty"`
 
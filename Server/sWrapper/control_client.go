@@ -9,13 +9,19 @@ import (
 
 // ControlClient 封装服务端的控制流：
 // - 读取 client -> server 的 ack
-// - server -> client 发送 migrate 并等待 ack
+// - server -> client 发送 migrate/abort/retarget 并等待 ack
 //
 // 业务数据流（AI 应用的数据）不在这里处理。
 
 type ControlClient struct {
 	ctrl quic.Stream
 
+	// remote 是该连接的对端地址（仅用于日志/汇总）。
+	remote string
+
+	idMu     sync.Mutex
+	clientID string
+
 	ackMu  sync.Mutex
 	ackMap map[string]chan struct{}
 
@@ -39,6 +45,12 @@ func (c *ControlClient) Start() {
 			if err != nil || !ok {
 				return
 			}
+			if msg.Type == TypeHello {
+				c.idMu.Lock()
+				c.clientID = msg.ClientID
+				c.idMu.Unlock()
+				continue
+			}
 			if msg.Type != TypeAck {
 				continue
 			}
@@ -57,15 +69,46 @@ func (c *ControlClient) Start() {
 
 func (c *ControlClient) Done() <-chan struct{} { return c.done }
 
+// ClientID 返回 client 在 hello 中上报的标识；尚未收到 hello 时为空。
+func (c *ControlClient) ClientID() string {
+	c.idMu.Lock()
+	defer c.idMu.Unlock()
+	return c.clientID
+}
+
+// Remote 返回该控制流所属连接的对端地址。
+func (c *ControlClient) Remote() string { return c.remote }
+
 func (c *ControlClient) SendMigrateAndWait(id, newAddr string, newPort int, timeout time.Duration) (wait time.Duration, acked bool) {
+	return c.sendAndWait(Message{Type: TypeMigrate, ID: id, NewAddr: newAddr, NewPort: newPort}, timeout)
+}
+
+// SendAbortAndWait 通知 client 放弃迁移 id（解除 armed peer，必要时切回原对端）并等待 ACK。
+func (c *ControlClient) SendAbortAndWait(id string, timeout time.Duration) (wait time.Duration, acked bool) {
+	return c.sendAndWait(Message{Type: TypeAbort, ID: id}, timeout)
+}
+
+// SendRetargetAndWait 把进行中的迁移 id 改投 newAddr:newPort 并等待 ACK。
+func (c *ControlClient) SendRetargetAndWait(id, newAddr string, newPort int, timeout time.Duration) (wait time.Duration, acked bool) {
+	return c.sendAndWait(Message{Type: TypeRetarget, ID: id, NewAddr: newAddr, NewPort: newPort}, timeout)
+}
+
+// SendCommit 通知 client 迁移 id 已在本实例完成（restore + rebind），不等待 ACK：
+// 该消息要等 client cutover 到本实例之后才能送达，QUIC 会负责重传。
+func (c *ControlClient) SendCommit(id string) error {
+	return WriteLine(c.ctrl, Message{Type: TypeCommit, ID: id})
+}
+
+func (c *ControlClient) sendAndWait(msg Message, timeout time.Duration) (wait time.Duration, acked bool) {
 	start := time.Now()
+	id := msg.ID
 
 	c.ackMu.Lock()
 	ch := make(chan struct{}, 1)
 	c.ackMap[id] = ch
 	c.ackMu.Unlock()
 
-	_ = WriteLine(c.ctrl, Message{Type: TypeMigrate, ID: id, NewAddr: newAddr, NewPort: newPort})
+	_ = WriteLine(c.ctrl, msg)
 
 	select {
 	case <-ch:
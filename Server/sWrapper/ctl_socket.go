@@ -0,0 +1,327 @@
+package wrapper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 本地控制端点（容器内 Unix socket）。
+//
+// 背景：
+//   - 早期 Control 只能用 SIGTERM（migrate）/SIGUSR2（rebind）和容器内 server 协作，
+//     信号不带参数、没有回复，也无法报告错误。
+//   - 这里在容器内监听一个 Unix socket，Control 在宿主机上通过 /proc/<pid>/root/<path> 连接它。
+//
+// 协议：每个连接一问一答，请求/响应都是一行 JSON（与 QUIC 控制流同样的 newline-delimited JSON）。
+//
+// 命令：
+//   - prepare-migrate：向所有 client 广播 migrate(addr, port, id) 并等待 ACK，返回 MigrateResult。
+//     目标随每次迁移给出，不依赖容器启动时的 MIGRATE_ADDR/MIGRATE_PORT。
+//   - rebind：重建 UDP socket（可选新 laddr），CRIU restore 后使用；成功后经控制流向 client 发送 commit。
+//   - status：返回监听地址、socket 代数、client 列表等。
+//   - drain：停止接受新的 QUIC 连接（已有连接不受影响）。
+//   - retarget：把进行中的迁移 id 改投新目标（参数同 prepare-migrate）。
+//   - abort：迁移回滚时向所有 client 广播 abort(id)，让它们解除 armed peer（已切换的切回本实例）。
+//   - save-state：把应用状态（ServerOptions.SaveState）写入 path，供模拟检查点使用（见 state.go）。
+//
+// 注意：CRIU restore 后该 listener 可能不可用，因此 SIGUSR2 与 rebind 都会顺带重建它；
+// Control 在 restore 后若连不上 socket，会退回 SIGUSR2。
+
+const DefaultControlSocket = "/run/swrapper.sock"
+
+const (
+	CtlPrepareMigrate = "prepare-migrate"
+	CtlRebind         = "rebind"
+	CtlStatus         = "status"
+	CtlDrain          = "drain"
+	CtlSaveState      = "save-state"
+	CtlAbort          = "abort"
+	CtlRetarget       = "retarget"
+)
+
+type CtlRequest struct {
+	Cmd string `json:"cmd"`
+
+	// prepare-migrate/retarget：目标可用 Addr+Port，也可用 To（"host:port"）；To 优先。
+	// abort 只使用 ID 与 TimeoutMS。
+	ID        string `json:"id,omitempty"`
+	Addr      string `json:"addr,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	To        string `json:"to,omitempty"`
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+
+	// rebind
+	LAddr string `json:"laddr,omitempty"`
+
+	// save-state
+	Path string `json:"path,omitempty"`
+}
+
+type CtlResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+
+	Migrate *MigrateResult `json:"migrate,omitempty"`
+	Rebind  *RebindResult  `json:"rebind,omitempty"`
+	Status  *ServerStatus  `json:"status,omitempty"`
+	State   *StateResult   `json:"state,omitempty"`
+}
+
+type RebindResult struct {
+	LocalAddr string        `json:"local_addr"`
+	Gen       uint64        `json:"gen"`
+	Took      time.Duration `json:"took_ns"`
+}
+
+type ClientInfo struct {
+	ClientID string `json:"client_id,omitempty"`
+	Remote   string `json:"remote,omitempty"`
+}
+
+type ServerStatus struct {
+	PID       int          `json:"pid"`
+	LocalAddr string       `json:"local_addr"`
+	Gen       uint64       `json:"gen"`
+	Draining  bool         `json:"draining"`
+	Clients   []ClientInfo `json:"clients"`
+}
+
+// ctlServer 管理 Unix socket listener，并支持在 restore 后重建。
+type ctlServer struct {
+	path   string
+	handle func(CtlRequest) CtlResponse
+
+	mu sync.Mutex
+	ln net.Listener
+}
+
+func newCtlServer(path string, handle func(CtlRequest) CtlResponse) *ctlServer {
+	return &ctlServer{path: path, handle: handle}
+}
+
+// listen（重新）创建 listener：先删除残留的 socket 文件，再 swap 并关闭旧 listener。
+func (c *ctlServer) listen() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	_ = os.Remove(c.path)
+	ln, err := net.Listen("unix", c.path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	old := c.ln
+	c.ln = ln
+	c.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+
+	go c.serve(ln)
+	return nil
+}
+
+func (c *ctlServer) close() {
+	c.mu.Lock()
+	ln := c.ln
+	c.ln = nil
+	c.mu.Unlock()
+	if ln != nil {
+		_ = ln.Close()
+		_ = os.Remove(c.path)
+	}
+}
+
+func (c *ctlServer) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.serveConn(conn)
+	}
+}
+
+func (c *ctlServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	var req CtlRequest
+	var resp CtlResponse
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp = CtlResponse{Error: fmt.Sprintf("bad request: %v", err)}
+	} else {
+		resp = c.handle(req)
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	_, _ = conn.Write(append(b, '\n'))
+}
+
+// CtlCall 连接 socketPath 发送一条命令并等待响应。
+// 供容器外的 Control 使用（socketPath 一般是 /proc/<pid>/root/run/swrapper.sock）。
+// 命令本身失败时（resp.OK=false）也会返回 error，且 resp 保留服务端的详细信息。
+func CtlCall(socketPath string, req CtlRequest, timeout time.Duration) (CtlResponse, error) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return CtlResponse{}, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return CtlResponse{}, err
+	}
+	if _, err := conn.Write(append(b, '\n')); err != nil {
+		return CtlResponse{}, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return CtlResponse{}, err
+	}
+	var resp CtlResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return CtlResponse{}, fmt.Errorf("bad ctl response: %w", err)
+	}
+	if !resp.OK {
+		if resp.Error == "" {
+			resp.Error = "unknown error"
+		}
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// SplitTarget 把 "host:port" 形式的迁移目标拆成 migrate 消息里的 new_addr/new_port。
+func SplitTarget(target string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(strings.TrimSpace(target))
+	if err != nil {
+		return "", 0, fmt.Errorf("bad target %q: %w", target, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("bad target %q: invalid port", target)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("bad target %q: empty host", target)
+	}
+	return host, port, nil
+}
+
+// ctlHandler 把控制命令映射到 Serve 内部状态（UDP socket、client 注册表、drain 标志）。
+func ctlHandler(opts ServerOptions, pc *MigratableUDP, clients *clientRegistry, draining *atomic.Bool) func(CtlRequest) CtlResponse {
+	return func(req CtlRequest) CtlResponse {
+		switch req.Cmd {
+		case CtlPrepareMigrate, CtlRetarget:
+			if req.To != "" {
+				addr, port, err := SplitTarget(req.To)
+				if err != nil {
+					return CtlResponse{Error: fmt.Sprintf("%s: %v", req.Cmd, err)}
+				}
+				req.Addr, req.Port = addr, port
+			}
+			if req.Addr == "" || req.Port <= 0 {
+				return CtlResponse{Error: fmt.Sprintf("%s: addr/port required", req.Cmd)}
+			}
+			timeout := opts.AckTimeout
+			if req.TimeoutMS > 0 {
+				timeout = time.Duration(req.TimeoutMS) * time.Millisecond
+			}
+			if req.Cmd == CtlRetarget {
+				if req.ID == "" {
+					return CtlResponse{Error: "retarget: id required"}
+				}
+				res := clients.broadcastRetarget(req.ID, req.Addr, req.Port, timeout)
+				if !opts.Quiet {
+					fmt.Printf("[服务端] 迁移改投 id=%s new=%s:%d acked=%d failed=%d\n", res.ID, res.NewAddr, res.NewPort, res.Acked, res.Failed)
+				}
+				return CtlResponse{OK: true, Migrate: &res}
+			}
+			id := req.ID
+			if id == "" {
+				id = fmt.Sprintf("m-%d", time.Now().UnixNano())
+			}
+			res := clients.broadcastMigrate(id, req.Addr, req.Port, timeout)
+			if !opts.Quiet {
+				printMigrateResult(res)
+			}
+			return CtlResponse{OK: true, Migrate: &res}
+
+		case CtlAbort:
+			if req.ID == "" {
+				return CtlResponse{Error: "abort: id required"}
+			}
+			timeout := opts.AckTimeout
+			if req.TimeoutMS > 0 {
+				timeout = time.Duration(req.TimeoutMS) * time.Millisecond
+			}
+			res := clients.broadcastAbort(req.ID, timeout)
+			if !opts.Quiet {
+				fmt.Printf("[服务端] 迁移已撤销 id=%s acked=%d failed=%d\n", res.ID, res.Acked, res.Failed)
+			}
+			return CtlResponse{OK: true, Migrate: &res}
+
+		case CtlRebind:
+			var laddr *net.UDPAddr
+			if req.LAddr != "" {
+				a, err := net.ResolveUDPAddr("udp", req.LAddr)
+				if err != nil {
+					return CtlResponse{Error: fmt.Sprintf("rebind: resolve laddr: %v", err)}
+				}
+				laddr = a
+			}
+			start := time.Now()
+			if err := pc.RebindTo(laddr); err != nil {
+				return CtlResponse{Error: fmt.Sprintf("rebind: %v", err)}
+			}
+			clients.commitMigrate()
+			return CtlResponse{OK: true, Rebind: &RebindResult{LocalAddr: pc.LocalAddr().String(), Gen: pc.Generation(), Took: time.Since(start)}}
+
+		case CtlStatus:
+			st := &ServerStatus{PID: os.Getpid(), LocalAddr: pc.LocalAddr().String(), Gen: pc.Generation(), Draining: draining.Load()}
+			for _, c := range clients.snapshot() {
+				st.Clients = append(st.Clients, ClientInfo{ClientID: c.ClientID(), Remote: c.Remote()})
+			}
+			return CtlResponse{OK: true, Status: st}
+
+		case CtlDrain:
+			draining.Store(true)
+			return CtlResponse{OK: true}
+
+		case CtlSaveState:
+			if req.Path == "" {
+				return CtlResponse{Error: "save-state: path required"}
+			}
+			res, err := saveState(opts, req.Path)
+			if err != nil {
+				return CtlResponse{Error: fmt.Sprintf("save-state: %v", err)}
+			}
+			return CtlResponse{OK: true, State: &res}
+
+		default:
+			return CtlResponse{Error: fmt.Sprintf("unknown command: %q", req.Cmd)}
+		}
+	}
+}
@@ -6,11 +6,15 @@
 //   - 后续 stream 作为业务流，由 APP 处理（echo/未来业务等）。
 //
 // 迁移集成点：
-//   - 容器外的 Control 进程发送 SIGTERM，触发服务端向客户端广播 "migrate"，并等待 ACK。
-//   - CRIU restore 到容器 B 之后，Control 发送 SIGUSR2，触发 UDP rebind。
+//   - 容器内本地控制端点（Unix socket，见 ctl_socket.go）：容器外的 Control 通过
+//     prepare-migrate 携带目标地址触发 "migrate" 广播并拿到 ACK 汇总；restore 后通过 rebind 重建 UDP。
 //     这是必要的：被恢复的进程需要创建一个“新”的 UDP socket，以匹配新的网络命名空间/端口映射。
+//   - 兼容路径：SIGTERM 触发广播（目标取 ServerOptions.MigrateAddr/MigratePort），SIGUSR2 触发 rebind。
+//   - 应用状态钩子（state.go）：没有 CRIU 时，save-state + RESTORE_STATE_FILE 以“重启 + 恢复状态”模拟检查点。
 //
 // 关键类型：MigratableUDP
 //   - 提供类似 net.PacketConn 的行为，并支持 Rebind()，且不会让 QUIC listener 直接崩掉。
 //   - 这点很关键：quic-go 会并发从 UDP socket 读数据，因此 socket 的 swap 必须非常谨慎。
+//   - 同时实现 quic-go 的 OOBCapablePacketConn 与批量读（udp_oob.go），ECN/GSO/packet-info 在 rebind 后仍可用。
+//   - 客户端来源地址变化时按 DCID 重映射对端地址（peer_remap.go），quic-go 仍只看到握手时的地址。
 package wrapper
@@ -9,15 +9,20 @@ import (
 // InstallRebindOnUSR2 installs a SIGUSR2 handler that calls m.Rebind().
 // This is meant to be used inside the container after CRIU restore.
 func InstallRebindOnUSR2(m *MigratableUDP) (stop func()) {
+	return installSignalHook(syscall.SIGUSR2, func() { _ = m.Rebind() })
+}
+
+// installSignalHook runs fn (serially) every time sig is received.
+func installSignalHook(sig os.Signal, fn func()) (stop func()) {
 	ch := make(chan os.Signal, 2)
-	signal.Notify(ch, syscall.SIGUSR2)
+	signal.Notify(ch, sig)
 	stop = func() {
 		signal.Stop(ch)
 		close(ch)
 	}
 	go func() {
 		for range ch {
-			_ = m.Rebind()
+			fn()
 		}
 	}()
 	return stop
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/ipv4"
 )
 
 // MigratableUDP 是一个支持“restore 后 rebind”的 UDP socket 包装。
@@ -21,6 +23,10 @@ import (
 //   2) 原子地 swap m.conn（并增加 generation）。
 //   3) 再关闭旧 conn。
 //   4) ReadFrom/WriteTo 观察到 close 错误且 generation 已变化时，自动重试。
+//
+// 另外，ReadFrom 会在 QUIC 之外直接回复 client 的路径探测包（见 probe.go），不交给 quic-go；
+// client 换了来源地址时先做路径验证再改投回包（见 peer_remap.go）。
+// OOB 能力（ECN/GSO/packet-info、缓冲区设置）见 udp_oob.go，rebind 后同样生效。
 
 type MigratableUDP struct {
 	mu sync.Mutex
@@ -28,7 +34,12 @@ type MigratableUDP struct {
 	network string
 	laddr   *net.UDPAddr
 	conn    *net.UDPConn
+	batch   *ipv4.PacketConn
 	gen     uint64
+
+	setup socketSetup
+	// remap 跟随客户端来源地址变化（见 peer_remap.go）。
+	remap peerRemap
 }
 
 func ListenMigratableUDP(network string, laddr *net.UDPAddr) (*MigratableUDP, error) {
@@ -36,20 +47,51 @@ func ListenMigratableUDP(network string, laddr *net.UDPAddr) (*MigratableUDP, er
 	if err != nil {
 		return nil, err
 	}
-	return &MigratableUDP{network: network, laddr: laddr, conn: c, gen: 1}, nil
+	return &MigratableUDP{network: network, laddr: laddr, conn: c, batch: ipv4.NewPacketConn(c), gen: 1}, nil
+}
+
+// current 返回当前代的 socket（及其批量读视图）与 generation。
+func (m *MigratableUDP) current() (*net.UDPConn, *ipv4.PacketConn, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conn, m.batch, m.gen
+}
+
+// swapped 判断 err 是否因为 Rebind 换掉了 c（此时调用方应在新 socket 上重试）。
+func (m *MigratableUDP) swapped(c *net.UDPConn, g uint64, err error) bool {
+	if !isNetClosing(err) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conn != c || m.gen != g
 }
 
 func (m *MigratableUDP) Rebind() error {
+	return m.RebindTo(nil)
+}
+
+// RebindTo 与 Rebind 相同，但允许换一个本地地址（laddr 为空表示沿用当前 laddr）。
+// 成功后后续 Rebind 也会使用新的 laddr。
+func (m *MigratableUDP) RebindTo(laddr *net.UDPAddr) error {
 	// IMPORTANT: quic-go is concurrently calling ReadFrom on m.conn.
 	// If we close the conn that a goroutine is blocked on, it unblocks with
 	// "use of closed network connection" which may be treated as fatal by quic-go.
 	// So we (1) create the new conn first, (2) swap, (3) close the old conn,
 	// and (4) make ReadFrom/WriteTo retry when they observe a swap.
 
-	newConn, err := net.ListenUDP(m.network, m.laddr)
+	m.mu.Lock()
+	if laddr == nil {
+		laddr = m.laddr
+	}
+	m.mu.Unlock()
+
+	newConn, err := net.ListenUDP(m.network, laddr)
 	if err != nil {
 		return err
 	}
+	// quic-go 对旧 socket 做过的设置（ECN/packet-info/缓冲区）在新 socket 上重放。
+	m.setup.apply(newConn)
 
 	m.mu.Lock()
 	old := m.conn
@@ -59,6 +101,8 @@ func (m *MigratableUDP) Rebind() error {
 		return errors.New("udp conn is nil")
 	}
 	m.conn = newConn
+	m.batch = ipv4.NewPacketConn(newConn)
+	m.laddr = laddr
 	m.gen++
 	m.mu.Unlock()
 
@@ -80,54 +124,65 @@ func isNetClosing(err error) bool {
 
 func (m *MigratableUDP) ReadFrom(p []byte) (int, net.Addr, error) {
 	for {
-		m.mu.Lock()
-		c := m.conn
-		g := m.gen
-		m.mu.Unlock()
+		c, _, g := m.current()
 		if c == nil {
 			return 0, nil, errors.New("udp conn is nil")
 		}
 
-		n, addr, err := c.ReadFrom(p)
+		n, addr, err := c.ReadFromUDP(p)
 		if err == nil {
-			return n, addr, nil
+			if addr, ok := m.accept(c, p[:n], addr); ok {
+				return n, addr, nil
+			}
+			continue
 		}
 
-		if isNetClosing(err) {
-			m.mu.Lock()
-			same := m.conn == c && m.gen == g
-			m.mu.Unlock()
-			if !same {
-				// A Rebind swapped the conn while we were blocked. Retry with the new one.
-				continue
-			}
+		if m.swapped(c, g, err) {
+			// A Rebind swapped the conn while we were blocked. Retry with the new one.
+			continue
 		}
 
 		return 0, nil, err
 	}
 }
 
+// accept 对收到的数据报做 QUIC 之外的处理：回复 client 的探测、处理路径验证的回复、按 DCID 重映射来源。
+// 返回交给 quic-go 的来源地址；false 表示该数据报不交给 quic-go。
+func (m *MigratableUDP) accept(c *net.UDPConn, b []byte, from *net.UDPAddr) (*net.UDPAddr, bool) {
+	switch {
+	case isProbeRequest(b):
+		answerProbe(c, b, from)
+		return nil, false
+	case isProbeReply(b):
+		m.remap.validate(from, b[len(probeReplyMagic):])
+		return nil, false
+	}
+	addr, challenge := m.remap.inbound(b, from)
+	if challenge != nil {
+		_, _ = c.WriteToUDP(challenge, from)
+	}
+	return addr, true
+}
+
 func (m *MigratableUDP) WriteTo(p []byte, addr net.Addr) (int, error) {
 	for {
-		m.mu.Lock()
-		c := m.conn
-		g := m.gen
-		m.mu.Unlock()
+		c, _, g := m.current()
 		if c == nil {
 			return 0, errors.New("udp conn is nil")
 		}
 
-		n, err := c.WriteTo(p, addr)
+		var n int
+		var err error
+		if ua, ok := addr.(*net.UDPAddr); ok {
+			n, err = c.WriteToUDP(p, m.remap.outbound(ua))
+		} else {
+			n, err = c.WriteTo(p, addr)
+		}
 		if err == nil {
 			return n, nil
 		}
-		if isNetClosing(err) {
-			m.mu.Lock()
-			same := m.conn == c && m.gen == g
-			m.mu.Unlock()
-			if !same {
-				continue
-			}
+		if m.swapped(c, g, err) {
+			continue
 		}
 		return n, err
 	}
@@ -141,10 +196,18 @@ func (m *MigratableUDP) Close() error {
 	}
 	err := m.conn.Close()
 	m.conn = nil
+	m.batch = nil
 	m.gen++
 	return err
 }
 
+// Generation 返回当前 socket 的代数（每次 Rebind/Close 递增）。
+func (m *MigratableUDP) Generation() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gen
+}
+
 func (m *MigratableUDP) LocalAddr() net.Addr {
 	m.mu.Lock()
 	c := m.conn
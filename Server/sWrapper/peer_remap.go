@@ -0,0 +1,162 @@
+package wrapper
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// 客户端来源地址变化（客户端切换网卡后 RebindLocal，或 NAT rebinding）时的对端地址重映射。
+//
+// quic-go 在握手时固定连接的远端地址，之后只往原地址发送；client 换了本地地址/端口后，
+// 回包会一直发往已经失效的旧地址。MigratableUDP 按 QUIC 短包头里的 DCID 识别“同一连接换了来源”：
+//   - 读：新来源伪装成该连接的原地址交给 quic-go（与 client 侧 fakePeer 对称）；
+//   - 写：发往原地址的包改投该连接最新的来源地址。
+//
+// DCID 在线路上是明文，源地址也可以伪造，因此新来源要先通过路径验证才会接管回包：
+// 向新来源发送一个带随机 nonce 的探测请求（格式见 probe.go），只有从该地址回显了 nonce，
+// 才把连接改投过去。伪造源地址的包只会让这里向被冒充的地址发一个等长的探测包，不会劫持连接。
+// 验证之前，新来源的包照常交给 quic-go（解密失败的由 quic-go 丢弃），回包仍发往原来的地址。
+
+// maxRemapEntries 限制记录的连接 ID 数与待验证的路径数；超过时整体清空（只会让已迁移的连接重新学习一次）。
+const maxRemapEntries = 4096
+
+// pathChallengeInterval 是同一新来源两次路径验证之间的最小间隔（探测包或回复丢失时重发）。
+const pathChallengeInterval = 100 * time.Millisecond
+
+type peerRemap struct {
+	mu sync.Mutex
+	// cidLen 是服务端连接 ID（即短包头 DCID）的长度，取自 quic.Transport 的配置；为 0 时不做重映射。
+	cidLen int
+	byCID  map[string]*remapEntry
+	byOrig map[netip.AddrPort]*remapEntry
+	byCur  map[netip.AddrPort]*remapEntry
+	// paths 是正在验证的新来源，验证通过后把 entry 改投过去并删除。
+	paths map[netip.AddrPort]*pathCheck
+	moved int
+}
+
+type remapEntry struct {
+	orig *net.UDPAddr
+	cur  *net.UDPAddr
+}
+
+type pathCheck struct {
+	addr  *net.UDPAddr
+	entry *remapEntry
+	nonce [probeNonceLen]byte
+	sent  time.Time
+}
+
+// setConnIDLen 设置 DCID 长度（见 server.go，与 quic.Transport 使用的长度一致）。
+func (r *peerRemap) setConnIDLen(n int) {
+	r.mu.Lock()
+	r.cidLen = n
+	r.mu.Unlock()
+}
+
+// inbound 记录 b（若为 QUIC 短包头）的来源，返回交给 quic-go 的地址。
+// 来源是已知连接的新地址时，challenge 非空：调用方应把它发给 from（路径验证）。
+func (r *peerRemap) inbound(b []byte, from *net.UDPAddr) (addr *net.UDPAddr, challenge []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 短包头：首字节最高位为 0，fixed bit（0x40）为 1。
+	if from == nil || r.cidLen == 0 || len(b) < 1+r.cidLen || b[0]&0xc0 != 0x40 {
+		return from, nil
+	}
+	cid := b[1 : 1+r.cidLen]
+
+	e, ok := r.byCID[string(cid)]
+	if !ok {
+		if r.byCID == nil || len(r.byCID) >= maxRemapEntries {
+			r.reset()
+		}
+		// 连接会换用服务端新发的 CID：来自已知地址（原地址或迁移后的地址）的新 CID 归入同一条目。
+		key := from.AddrPort()
+		if e, ok = r.byCur[key]; !ok {
+			e = &remapEntry{orig: from, cur: from}
+			r.byOrig[key] = e
+			r.byCur[key] = e
+		}
+		r.byCID[string(cid)] = e
+		return e.orig, nil
+	}
+	if udpAddrEqual(e.cur, from) {
+		return e.orig, nil
+	}
+
+	key := from.AddrPort()
+	p := r.paths[key]
+	if p == nil {
+		if len(r.paths) >= maxRemapEntries {
+			r.paths = make(map[netip.AddrPort]*pathCheck)
+		}
+		p = &pathCheck{addr: from, nonce: newProbeNonce()}
+		r.paths[key] = p
+	}
+	p.entry = e
+	if time.Since(p.sent) >= pathChallengeInterval {
+		p.sent = time.Now()
+		challenge = newProbeRequest(p.nonce)
+	}
+	return e.orig, challenge
+}
+
+// validate 处理 from 对路径验证的回复：nonce 与发给 from 的一致时，把对应连接改投到 from。
+func (r *peerRemap) validate(from *net.UDPAddr, nonce []byte) bool {
+	if from == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := from.AddrPort()
+	p := r.paths[key]
+	if p == nil || subtle.ConstantTimeCompare(p.nonce[:], nonce) != 1 {
+		return false
+	}
+	delete(r.paths, key)
+	e := p.entry
+	// 验证期间条目可能已被清空重建，此时不再改投。
+	if r.byOrig[e.orig.AddrPort()] != e || udpAddrEqual(e.cur, from) {
+		return false
+	}
+	if udpAddrEqual(e.orig, e.cur) {
+		r.moved++
+	} else if udpAddrEqual(e.orig, from) {
+		r.moved--
+	}
+	delete(r.byCur, e.cur.AddrPort())
+	r.byCur[key] = e
+	e.cur = from
+	return true
+}
+
+func (r *peerRemap) reset() {
+	r.byCID = make(map[string]*remapEntry)
+	r.byOrig = make(map[netip.AddrPort]*remapEntry)
+	r.byCur = make(map[netip.AddrPort]*remapEntry)
+	r.paths = make(map[netip.AddrPort]*pathCheck)
+	r.moved = 0
+}
+
+// outbound 返回 quic-go 发往 addr 的包实际应发往的地址。
+func (r *peerRemap) outbound(addr *net.UDPAddr) *net.UDPAddr {
+	if addr == nil {
+		return addr
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.moved == 0 {
+		return addr
+	}
+	if e, ok := r.byOrig[addr.AddrPort()]; ok {
+		return e.cur
+	}
+	return addr
+}
+
+func udpAddrEqual(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.Port == b.Port && a.IP.Equal(b.IP)
+}
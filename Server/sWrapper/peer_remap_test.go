@@ -0,0 +1,74 @@
+package wrapper
+
+import (
+	"net"
+	"testing"
+)
+
+// 已知 DCID 从新地址发来时只发起路径验证，新地址回显 nonce 之后才改投回包。
+func TestPeerRemapValidatesNewPath(t *testing.T) {
+	var r peerRemap
+	r.setConnIDLen(serverConnIDLen)
+	pkt := []byte{0x40, 1, 2, 3, 4, 0xaa, 0xbb}
+	orig := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+	moved := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 2000}
+
+	if addr, ch := r.inbound(pkt, orig); !udpAddrEqual(addr, orig) || ch != nil {
+		t.Fatalf("first packet: addr %v challenge %x", addr, ch)
+	}
+	addr, ch := r.inbound(pkt, moved)
+	if !udpAddrEqual(addr, orig) || !isProbeRequest(ch) {
+		t.Fatalf("new source: addr %v challenge %x", addr, ch)
+	}
+	if got := r.outbound(orig); !udpAddrEqual(got, orig) {
+		t.Fatalf("moved to %v before validation", got)
+	}
+	if _, again := r.inbound(pkt, moved); again != nil {
+		t.Fatal("challenge resent within the interval")
+	}
+
+	nonce := ch[len(probeRequestMagic):]
+	wrong := append([]byte(nil), nonce...)
+	wrong[0] ^= 0xff
+	if r.validate(moved, wrong) {
+		t.Fatal("validated with a foreign nonce")
+	}
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 3000}
+	if r.validate(other, nonce) {
+		t.Fatal("validated from another address")
+	}
+	if got := r.outbound(orig); !udpAddrEqual(got, orig) {
+		t.Fatalf("moved to %v by an invalid reply", got)
+	}
+
+	if !r.validate(moved, nonce) {
+		t.Fatal("valid reply rejected")
+	}
+	if got := r.outbound(orig); !udpAddrEqual(got, moved) {
+		t.Fatalf("outbound %v after validation, want %v", got, moved)
+	}
+	if r.validate(moved, nonce) {
+		t.Fatal("nonce accepted twice")
+	}
+	if _, ch := r.inbound(pkt, moved); ch != nil {
+		t.Fatal("validated path challenged again")
+	}
+
+	// 回到原地址同样要先验证。
+	_, ch = r.inbound(pkt, orig)
+	if ch == nil || !r.validate(orig, ch[len(probeRequestMagic):]) {
+		t.Fatal("return to the original address was not validated")
+	}
+	if got := r.outbound(orig); !udpAddrEqual(got, orig) || r.moved != 0 {
+		t.Fatalf("outbound %v moved=%d after returning", got, r.moved)
+	}
+}
+
+// 没有配置 DCID 长度（未经 quic.Transport 使用）时不做重映射。
+func TestPeerRemapDisabled(t *testing.T) {
+	var r peerRemap
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+	if addr, ch := r.inbound([]byte{0x40, 1, 2, 3, 4}, from); addr != from || ch != nil {
+		t.Fatalf("addr %v challenge %x", addr, ch)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package wrapper
+
+import "syscall"
+
+// stripPacketInfo 去掉 oob 开头的 IP_PKTINFO / IPV6_PKTINFO 控制消息（quic-go 总是把它放在最前面），
+// 保留其后的 GSO/ECN 控制消息。oob 不以 packet-info 开头时返回 false。
+func stripPacketInfo(oob []byte) ([]byte, bool) {
+	if len(oob) < syscall.CmsgLen(0) {
+		return nil, false
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil || len(msgs) == 0 {
+		return nil, false
+	}
+	h := msgs[0].Header
+	if (h.Level == syscall.IPPROTO_IP && h.Type == syscall.IP_PKTINFO) ||
+		(h.Level == syscall.IPPROTO_IPV6 && h.Type == syscall.IPV6_PKTINFO) {
+		return oob[syscall.CmsgSpace(len(msgs[0].Data)):], true
+	}
+	return nil, false
+}
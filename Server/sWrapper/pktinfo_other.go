@@ -0,0 +1,8 @@
+//go:build !linux
+
+package wrapper
+
+// stripPacketInfo：非 Linux 平台上 quic-go 不在发送时携带 packet-info，无需处理。
+func stripPacketInfo(oob []byte) ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,55 @@
+package wrapper
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+)
+
+// 路径探测。
+//
+// client → 候选对端：client 收到 migrate 后会向候选对端（B）周期性发送探测包；B restore + rebind 之后，
+// MigratableUDP 在 QUIC 之外直接回复，client 据此判断 B 已可达并 cutover（即 commit）。
+//
+// server → client：连接从新地址发来时，MigratableUDP 向新地址发送探测包做路径验证（见 peer_remap.go），
+// client 回显后才改投回包。
+//
+// 格式：4 字节 magic + 8 字节 nonce（原样回送）。magic 首字节为 0：
+// QUIC v1 的包首字节 fixed bit（0x40）必为 1，因此不会与 QUIC 包混淆。
+var (
+	probeRequestMagic = []byte{0x00, 'w', 'p', '?'}
+	probeReplyMagic   = []byte{0x00, 'w', 'p', '!'}
+)
+
+const (
+	probeLen      = 12
+	probeNonceLen = probeLen - 4
+)
+
+func isProbeRequest(b []byte) bool {
+	return len(b) == probeLen && bytes.HasPrefix(b, probeRequestMagic)
+}
+
+func isProbeReply(b []byte) bool {
+	return len(b) == probeLen && bytes.HasPrefix(b, probeReplyMagic)
+}
+
+func newProbeNonce() (n [probeNonceLen]byte) {
+	_, _ = rand.Read(n[:])
+	return n
+}
+
+func newProbeRequest(nonce [probeNonceLen]byte) []byte {
+	b := make([]byte, probeLen)
+	copy(b, probeRequestMagic)
+	copy(b[len(probeRequestMagic):], nonce[:])
+	return b
+}
+
+// answerProbe 把探测请求改写为回复并回送给来源。
+func answerProbe(c *net.UDPConn, req []byte, from net.Addr) {
+	var reply [probeLen]byte
+	copy(reply[:], probeReplyMagic)
+	copy(reply[len(probeReplyMagic):], req[len(probeRequestMagic):])
+	_, _ = c.WriteTo(reply[:], from)
+}
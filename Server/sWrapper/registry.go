@@ -0,0 +1,144 @@
+package wrapper
+
+import (
+	"sync"
+	"time"
+)
+
+// clientRegistry 记录当前容器内所有活跃的控制流。
+//
+// 一个服务实例可能同时服务多条 QUIC 连接（例如车端 + 路侧单元），
+// 迁移时必须让它们全部收到 migrate，否则未通知的连接会被留在旧地址上。
+type clientRegistry struct {
+	mu      sync.Mutex
+	clients map[*ControlClient]struct{}
+
+	// lastMigrateID 是最近一次广播的 migrate ID（随 CRIU 镜像一起带到 B），rebind 后的 commit 使用它。
+	lastMigrateID string
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: map[*ControlClient]struct{}{}}
+}
+
+func (r *clientRegistry) add(c *ControlClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c] = struct{}{}
+}
+
+func (r *clientRegistry) remove(c *ControlClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c)
+}
+
+func (r *clientRegistry) snapshot() []*ControlClient {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]*ControlClient, 0, len(r.clients))
+	for c := range r.clients {
+		out = append(out, c)
+	}
+	return out
+}
+
+// ClientMigrateResult 是单个 client 对一次 migrate 的响应情况。
+type ClientMigrateResult struct {
+	ClientID string        `json:"client_id,omitempty"`
+	Remote   string        `json:"remote,omitempty"`
+	Acked    bool          `json:"acked"`
+	Wait     time.Duration `json:"wait_ns"`
+}
+
+// MigrateResult 汇总一次 migrate 广播的结果。
+type MigrateResult struct {
+	ID      string                `json:"id"`
+	NewAddr string                `json:"new_addr"`
+	NewPort int                   `json:"new_port"`
+	Clients []ClientMigrateResult `json:"clients"`
+	// Acked/Failed 分别是收到 ACK 与超时/断开的 client 数。
+	Acked  int           `json:"acked"`
+	Failed int           `json:"failed"`
+	Total  time.Duration `json:"total_ns"`
+}
+
+// broadcastMigrate 并发地向所有已注册的 client 发送 migrate 并等待 ACK。
+// 总耗时约等于最慢的那个 client（上限为 timeout）。
+func (r *clientRegistry) broadcastMigrate(id, newAddr string, newPort int, timeout time.Duration) MigrateResult {
+	r.mu.Lock()
+	r.lastMigrateID = id
+	r.mu.Unlock()
+	res := r.broadcast(func(c *ControlClient) (time.Duration, bool) {
+		return c.SendMigrateAndWait(id, newAddr, newPort, timeout)
+	})
+	res.ID, res.NewAddr, res.NewPort = id, newAddr, newPort
+	return res
+}
+
+// broadcastAbort 并发地通知所有 client 放弃迁移 id（回滚时使用），结果格式与 migrate 相同。
+// 被放弃的迁移不会再 commit：清掉 lastMigrateID，之后的 rebind（例如手动 rebind）不会为它发送 commit。
+func (r *clientRegistry) broadcastAbort(id string, timeout time.Duration) MigrateResult {
+	r.mu.Lock()
+	if r.lastMigrateID == id {
+		r.lastMigrateID = ""
+	}
+	r.mu.Unlock()
+	res := r.broadcast(func(c *ControlClient) (time.Duration, bool) {
+		return c.SendAbortAndWait(id, timeout)
+	})
+	res.ID = id
+	return res
+}
+
+// broadcastRetarget 并发地把进行中的迁移 id 改投新目标。
+func (r *clientRegistry) broadcastRetarget(id, newAddr string, newPort int, timeout time.Duration) MigrateResult {
+	res := r.broadcast(func(c *ControlClient) (time.Duration, bool) {
+		return c.SendRetargetAndWait(id, newAddr, newPort, timeout)
+	})
+	res.ID, res.NewAddr, res.NewPort = id, newAddr, newPort
+	return res
+}
+
+// commitMigrate 在 rebind 成功后向所有 client 发送 commit（不等待 ACK）。
+// 没有进行中的迁移（例如手动 rebind）时不发送；返回本次使用的 ID。
+func (r *clientRegistry) commitMigrate() string {
+	r.mu.Lock()
+	id := r.lastMigrateID
+	r.lastMigrateID = ""
+	r.mu.Unlock()
+	if id == "" {
+		return ""
+	}
+	for _, c := range r.snapshot() {
+		_ = c.SendCommit(id)
+	}
+	return id
+}
+
+func (r *clientRegistry) broadcast(send func(c *ControlClient) (time.Duration, bool)) MigrateResult {
+	start := time.Now()
+	clients := r.snapshot()
+	res := MigrateResult{Clients: make([]ClientMigrateResult, len(clients))}
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *ControlClient) {
+			defer wg.Done()
+			wait, ok := send(c)
+			res.Clients[i] = ClientMigrateResult{ClientID: c.ClientID(), Remote: c.Remote(), Acked: ok, Wait: wait}
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, cr := range res.Clients {
+		if cr.Acked {
+			res.Acked++
+		} else {
+			res.Failed++
+		}
+	}
+	res.Total = time.Since(start)
+	return res
+}
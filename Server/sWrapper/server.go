@@ -9,7 +9,7 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -25,23 +25,38 @@ type ServerOptions struct {
 	ListenAddr string
 
 	// migrate 指令里推送给 client 的新地址/端口。
+	// 仅用于 SIGTERM 触发的迁移；本地控制端点的 prepare-migrate 会携带自己的目标。
 	MigrateAddr string
 	MigratePort int
 
+	// ControlSocket 是容器内本地控制端点（Unix socket）的路径，为空表示不启用。
+	ControlSocket string
+
 	Quiet bool
 
 	KeepAlivePeriod time.Duration
 	AckTimeout      time.Duration
+
+	// 应用状态钩子（见 state.go）：控制端点 save-state 调用 SaveState；
+	// RestoreStateFile 非空时，Serve 在监听前用其内容调用 LoadState。
+	SaveState        func() ([]byte, error)
+	LoadState        func([]byte) error
+	RestoreStateFile string
 }
 
+// serverConnIDLen 是服务端连接 ID 的长度（与 quic-go 的默认值相同）。
+const serverConnIDLen = 4
+
 func DefaultServerOptions() ServerOptions {
 	return ServerOptions{
 		ListenAddr:       envOr("LISTEN_ADDR", ":4242"),
 		MigrateAddr:      envOr("MIGRATE_ADDR", "127.0.0.1"),
 		MigratePort:      envOrInt("MIGRATE_PORT", 5243),
+		ControlSocket:    envOr("CONTROL_SOCKET", DefaultControlSocket),
 		Quiet:            envOrBool("QUIET", true),
 		KeepAlivePeriod:  2 * time.Second,
 		AckTimeout:       800 * time.Millisecond,
+		RestoreStateFile: envOr("RESTORE_STATE_FILE", ""),
 	}
 }
 
@@ -49,8 +64,9 @@ func DefaultServerOptions() ServerOptions {
 // - 建立 QUIC listener
 // - 每个连接第一条 stream 作为控制流（migrate/ack）
 // - 后续 stream 交给 APP 提供的 handler
-// - SIGTERM 触发 migrate 广播并等待 ACK（PoC/Control 用）
-// - SIGUSR2 触发 UDP Rebind（CRIU restore 后用）
+// - SIGTERM 触发 migrate 广播（并发发给所有活跃 client）并等待 ACK（PoC/Control 用）
+// - SIGUSR2 触发 UDP Rebind（CRIU restore 后用），成功后经控制流发送 commit
+// - ControlSocket 非空时监听本地控制端点（prepare-migrate/rebind/status/drain），见 ctl_socket.go
 func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadWriteCloser)) error {
 	if handler == nil {
 		return fmt.Errorf("handler is nil")
@@ -65,6 +81,10 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 		opts.AckTimeout = 800 * time.Millisecond
 	}
 
+	if err := loadState(opts); err != nil {
+		return err
+	}
+
 	tlsConf, err := ServerTLSConfig()
 	if err != nil {
 		return fmt.Errorf("tls: %w", err)
@@ -80,7 +100,12 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 	}
 	defer pc.Close()
 
-	listener, err := quic.Listen(pc, tlsConf, &quic.Config{KeepAlivePeriod: opts.KeepAlivePeriod})
+	// 显式给出连接 ID 长度：MigratableUDP 按同一长度从短包头解析 DCID（见 peer_remap.go）。
+	tr := &quic.Transport{Conn: pc, ConnectionIDLength: serverConnIDLen}
+	defer tr.Close()
+	pc.remap.setConnIDLen(tr.ConnectionIDLength)
+
+	listener, err := tr.Listen(tlsConf, &quic.Config{KeepAlivePeriod: opts.KeepAlivePeriod})
 	if err != nil {
 		return fmt.Errorf("quic listen: %w", err)
 	}
@@ -90,28 +115,30 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 		fmt.Printf("[服务端] 监听 %s\n", opts.ListenAddr)
 	}
 
-	// 容器内协作点：restore 后由 Control 发 SIGUSR2 来触发 rebind。
-	stopUSR2 := InstallRebindOnUSR2(pc)
-	defer stopUSR2()
+	clients := newClientRegistry()
+	var draining atomic.Bool
 
-	var (
-		mu  sync.Mutex
-		cur *ControlClient
-	)
-
-	register := func(c *ControlClient) {
-		mu.Lock()
-		defer mu.Unlock()
-		cur = c
-	}
-	unregister := func(c *ControlClient) {
-		mu.Lock()
-		defer mu.Unlock()
-		if cur == c {
-			cur = nil
+	var ctl *ctlServer
+	if opts.ControlSocket != "" {
+		ctl = newCtlServer(opts.ControlSocket, ctlHandler(opts, pc, clients, &draining))
+		if err := ctl.listen(); err != nil {
+			return fmt.Errorf("control socket: %w", err)
 		}
+		defer ctl.close()
 	}
 
+	// 容器内协作点：restore 后由 Control 发 SIGUSR2 来触发 rebind。
+	// 控制端点在 restore 后可能失效，这里一并重建，便于后续命令继续走 socket。
+	stopUSR2 := installSignalHook(syscall.SIGUSR2, func() {
+		if err := pc.Rebind(); err == nil {
+			clients.commitMigrate()
+		}
+		if ctl != nil {
+			_ = ctl.listen()
+		}
+	})
+	defer stopUSR2()
+
 	// SIGTERM: 触发 migrate 广播（供 Control 在容器外编排时使用）。
 	term := make(chan os.Signal, 2)
 	signal.Notify(term, syscall.SIGTERM)
@@ -120,25 +147,9 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 	go func() {
 		for range term {
 			id := fmt.Sprintf("m-%d", time.Now().UnixNano())
-			mu.Lock()
-			c := cur
-			mu.Unlock()
-			if c == nil {
-				if !opts.Quiet {
-					fmt.Printf("[服务端] 触发迁移 id=%s (no active client)\n", id)
-				}
-				continue
-			}
+			res := clients.broadcastMigrate(id, opts.MigrateAddr, opts.MigratePort, opts.AckTimeout)
 			if !opts.Quiet {
-				fmt.Printf("[服务端] 触发迁移 id=%s new=%s:%d\n", id, opts.MigrateAddr, opts.MigratePort)
-			}
-			wait, ok := c.SendMigrateAndWait(id, opts.MigrateAddr, opts.MigratePort, opts.AckTimeout)
-			if !opts.Quiet {
-				if ok {
-					fmt.Printf("[服务端] 收到ACK id=%s wait=%dms\n", id, wait.Milliseconds())
-				} else {
-					fmt.Printf("[服务端] ACK超时 id=%s wait=%dms\n", id, wait.Milliseconds())
-				}
+				printMigrateResult(res)
 			}
 		}
 	}()
@@ -162,6 +173,11 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 			return fmt.Errorf("accept: %w", err)
 		}
 
+		if draining.Load() {
+			_ = conn.CloseWithError(0, "draining")
+			continue
+		}
+
 		go func(conn quic.Connection) {
 			// 约定：client 第一条双向 stream 为控制流。
 			ctrl, err := conn.AcceptStream(context.Background())
@@ -170,9 +186,10 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 			}
 
 			cc := NewControlClient(ctrl)
+			cc.remote = conn.RemoteAddr().String()
 			cc.Start()
-			register(cc)
-			defer unregister(cc)
+			clients.add(cc)
+			defer clients.remove(cc)
 
 			// 后续 stream：业务数据流（由 APP 处理）。
 			for {
@@ -186,6 +203,22 @@ func Serve(ctx context.Context, opts ServerOptions, handler func(stream io.ReadW
 	}
 }
 
+func printMigrateResult(res MigrateResult) {
+	if len(res.Clients) == 0 {
+		fmt.Printf("[服务端] 触发迁移 id=%s (no active client)\n", res.ID)
+		return
+	}
+	fmt.Printf("[服务端] 触发迁移 id=%s new=%s:%d clients=%d\n", res.ID, res.NewAddr, res.NewPort, len(res.Clients))
+	for _, cr := range res.Clients {
+		if cr.Acked {
+			fmt.Printf("[服务端] 收到ACK id=%s client=%s remote=%s wait=%dms\n", res.ID, cr.ClientID, cr.Remote, cr.Wait.Milliseconds())
+		} else {
+			fmt.Printf("[服务端] ACK超时 id=%s client=%s remote=%s wait=%dms\n", res.ID, cr.ClientID, cr.Remote, cr.Wait.Milliseconds())
+		}
+	}
+	fmt.Printf("[服务端] 迁移汇总 id=%s acked=%d failed=%d total=%dms\n", res.ID, res.Acked, res.Failed, res.Total.Milliseconds())
+}
+
 func envOr(k, def string) string {
 	v := strings.TrimSpace(os.Getenv(k))
 	if v == "" {
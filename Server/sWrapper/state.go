@@ -0,0 +1,68 @@
+package wrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 应用状态钩子（模拟检查点）。
+//
+// 没有 CRIU 的机器上，Control 的 sim checkpointer 不冻结进程，而是：
+//   - 经控制端点 save-state 让应用把业务状态序列化到镜像目录；
+//   - 在壳里重新启动 server，并通过 RESTORE_STATE_FILE 让它在监听前 LoadState。
+//
+// QUIC 连接状态不会被带走：client 会看到一个“新”的 server，只适合验证编排流程。
+
+// StateResult 是一次 save-state 的结果。
+type StateResult struct {
+	Path  string        `json:"path"`
+	Bytes int           `json:"bytes"`
+	Took  time.Duration `json:"took_ns"`
+}
+
+// saveState 调用 SaveState 并把结果写入 path（先写临时文件再 rename，避免读到半个文件）。
+// 应用未提供 SaveState 时写入空状态（无状态服务）。
+func saveState(opts ServerOptions, path string) (StateResult, error) {
+	start := time.Now()
+	var b []byte
+	if opts.SaveState != nil {
+		var err error
+		if b, err = opts.SaveState(); err != nil {
+			return StateResult{}, fmt.Errorf("save state: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return StateResult{}, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return StateResult{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return StateResult{}, err
+	}
+	return StateResult{Path: path, Bytes: len(b), Took: time.Since(start)}, nil
+}
+
+// loadState 在 Serve 监听前调用：读取 RestoreStateFile 交给 LoadState。
+func loadState(opts ServerOptions) error {
+	if opts.RestoreStateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(opts.RestoreStateFile)
+	if err != nil {
+		return fmt.Errorf("restore state: %w", err)
+	}
+	if opts.LoadState == nil {
+		return nil
+	}
+	if err := opts.LoadState(b); err != nil {
+		return fmt.Errorf("restore state: %w", err)
+	}
+	if !opts.Quiet {
+		fmt.Printf("[服务端] 已恢复应用状态 file=%s bytes=%d\n", opts.RestoreStateFile, len(b))
+	}
+	return nil
+}
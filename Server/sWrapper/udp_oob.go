@@ -0,0 +1,235 @@
+package wrapper
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+)
+
+// MigratableUDP 的 OOB 能力（quic-go 的 OOBCapablePacketConn + batchConn）。
+//
+// 只实现 net.PacketConn 时，quic-go 会退回非 OOB 路径：没有 ECN、GSO、packet-info，
+// 也无法调大 socket 缓冲区（启动时打印 buffer-size 警告）。这里补齐：
+//   - SyscallConn / SetReadBuffer / SetWriteBuffer：作用于当前代 socket，并被记录下来，
+//     Rebind 时在新 socket 上重放（socketSetup），保证能力不因 rebind 丢失。
+//   - ReadMsgUDP / WriteMsgUDP / ReadBatch：与 ReadFrom/WriteTo 相同的“generation 变化即重试”语义，
+//     并同样在 QUIC 之外拦截路径探测包。
+//
+// 注意：必须自己实现 ReadBatch。否则 quic-go 会用 ipv4.NewPacketConn 直接读底层 fd，
+// 绕过 generation 切换与探测拦截。
+
+var _ interface {
+	net.PacketConn
+	SyscallConn() (syscall.RawConn, error)
+	SetReadBuffer(int) error
+	ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+	WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+} = (*MigratableUDP)(nil)
+
+// socketSetup 记录 quic-go 对 socket 做过的设置，rebind 后在新 socket 上重放。
+type socketSetup struct {
+	mu       sync.Mutex
+	readBuf  int
+	writeBuf int
+	controls []func(fd uintptr)
+}
+
+// maxSocketControls 限制记录的 Control 回调数量；quic-go 只在建连时调用少数几次。
+const maxSocketControls = 32
+
+func (s *socketSetup) recordControl(f func(fd uintptr)) {
+	s.mu.Lock()
+	if len(s.controls) < maxSocketControls {
+		s.controls = append(s.controls, f)
+	}
+	s.mu.Unlock()
+}
+
+// apply 把记录的设置重放到 c 上（尽力而为：新 socket 不支持某个选项时忽略）。
+func (s *socketSetup) apply(c *net.UDPConn) {
+	s.mu.Lock()
+	readBuf, writeBuf := s.readBuf, s.writeBuf
+	controls := append([]func(fd uintptr){}, s.controls...)
+	s.mu.Unlock()
+
+	if readBuf > 0 {
+		_ = c.SetReadBuffer(readBuf)
+	}
+	if writeBuf > 0 {
+		_ = c.SetWriteBuffer(writeBuf)
+	}
+	if len(controls) == 0 {
+		return
+	}
+	if rc, err := c.SyscallConn(); err == nil {
+		_ = rc.Control(func(fd uintptr) {
+			for _, f := range controls {
+				f(fd)
+			}
+		})
+	}
+}
+
+// rebindableRawConn 是 SyscallConn 返回的 syscall.RawConn：每次调用都作用于当前代的 socket，
+// Control 还会被记录（先记录再执行，避免与并发的 Rebind 错过）。
+type rebindableRawConn struct {
+	m *MigratableUDP
+}
+
+func (r rebindableRawConn) raw() (syscall.RawConn, error) {
+	c, _, _ := r.m.current()
+	if c == nil {
+		return nil, errors.New("udp conn is nil")
+	}
+	return c.SyscallConn()
+}
+
+func (r rebindableRawConn) Control(f func(fd uintptr)) error {
+	r.m.setup.recordControl(f)
+	rc, err := r.raw()
+	if err != nil {
+		return err
+	}
+	return rc.Control(f)
+}
+
+func (r rebindableRawConn) Read(f func(fd uintptr) bool) error {
+	rc, err := r.raw()
+	if err != nil {
+		return err
+	}
+	return rc.Read(f)
+}
+
+func (r rebindableRawConn) Write(f func(fd uintptr) bool) error {
+	rc, err := r.raw()
+	if err != nil {
+		return err
+	}
+	return rc.Write(f)
+}
+
+func (m *MigratableUDP) SyscallConn() (syscall.RawConn, error) {
+	if c, _, _ := m.current(); c == nil {
+		return nil, errors.New("udp conn is nil")
+	}
+	return rebindableRawConn{m: m}, nil
+}
+
+func (m *MigratableUDP) SetReadBuffer(bytes int) error {
+	m.setup.mu.Lock()
+	m.setup.readBuf = bytes
+	m.setup.mu.Unlock()
+	c, _, _ := m.current()
+	if c == nil {
+		return errors.New("udp conn is nil")
+	}
+	return c.SetReadBuffer(bytes)
+}
+
+func (m *MigratableUDP) SetWriteBuffer(bytes int) error {
+	m.setup.mu.Lock()
+	m.setup.writeBuf = bytes
+	m.setup.mu.Unlock()
+	c, _, _ := m.current()
+	if c == nil {
+		return errors.New("udp conn is nil")
+	}
+	return c.SetWriteBuffer(bytes)
+}
+
+func (m *MigratableUDP) ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error) {
+	for {
+		c, _, g := m.current()
+		if c == nil {
+			return 0, 0, 0, nil, errors.New("udp conn is nil")
+		}
+		n, oobn, flags, addr, err = c.ReadMsgUDP(b, oob)
+		if err == nil {
+			if addr, ok := m.accept(c, b[:n], addr); ok {
+				return n, oobn, flags, addr, nil
+			}
+			continue
+		}
+		if m.swapped(c, g, err) {
+			continue
+		}
+		return 0, 0, 0, nil, err
+	}
+}
+
+func (m *MigratableUDP) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error) {
+	for {
+		c, _, g := m.current()
+		if c == nil {
+			return 0, 0, errors.New("udp conn is nil")
+		}
+		n, oobn, err = c.WriteMsgUDP(b, oob, m.remap.outbound(addr))
+		if err == nil {
+			return n, oobn, nil
+		}
+		if m.swapped(c, g, err) {
+			continue
+		}
+		// restore 到新的网络命名空间后，quic-go 仍按 A 上收到的 packet-info 指定源地址/网卡，
+		// 新 socket 上必然发送失败：去掉 packet-info 让内核自己选源地址。
+		if stripped, ok := stripPacketInfo(oob); ok {
+			oob = stripped
+			continue
+		}
+		return n, oobn, err
+	}
+}
+
+func (m *MigratableUDP) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	for {
+		c, bc, g := m.current()
+		if c == nil {
+			return 0, errors.New("udp conn is nil")
+		}
+		n, err := bc.ReadBatch(ms, flags)
+		if err == nil {
+			// 批内的探测包直接处理并剔除；整批都是探测包时继续读（quic-go 不接受 n==0 且无错误）。
+			if n = m.filterBatch(c, ms[:n]); n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if m.swapped(c, g, err) {
+			continue
+		}
+		return 0, err
+	}
+}
+
+func (m *MigratableUDP) filterBatch(c *net.UDPConn, ms []ipv4.Message) int {
+	k := 0
+	for i := range ms {
+		from, _ := ms[i].Addr.(*net.UDPAddr)
+		addr, ok := m.accept(c, ms[i].Buffers[0][:ms[i].N], from)
+		if !ok {
+			continue
+		}
+		if k != i {
+			moveMessage(&ms[k], &ms[i])
+		}
+		if addr != nil {
+			ms[k].Addr = addr
+		}
+		k++
+	}
+	return k
+}
+
+// moveMessage 把 src 的内容拷贝到 dst。quic-go 的 packet buffer 与 ms 下标一一对应，
+// 所以只能拷贝数据，不能交换 Buffers。
+func moveMessage(dst, src *ipv4.Message) {
+	dst.N = copy(dst.Buffers[0], src.Buffers[0][:src.N])
+	dst.NN = copy(dst.OOB, src.OOB[:src.NN])
+	dst.Flags = src.Flags
+	dst.Addr = src.Addr
+}
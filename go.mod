@@ -2,7 +2,10 @@ module github.com/Liangxia6/Wrapper
 
 go 1.21
 
-require github.com/quic-go/quic-go v0.42.0
+require (
+	github.com/quic-go/quic-go v0.42.0
+	golang.org/x/net v0.10.0
+)
 
 require (
 	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
@@ -12,7 +15,6 @@ require (
 	golang.org/x/crypto v0.4.0 // indirect
 	golang.org/x/exp v0.0.0-20221205204356-47842c84f3db // indirect
 	golang.org/x/mod v0.11.0 // indirect
-	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/tools v0.9.1 // indirect
 )
@@ -9,6 +9,7 @@ cd "$(dirname "$0")"
 : "${SRC_PORT:=5242}"
 : "${DST_PORT:=5243}"
 : "${CRIU_HOST_BIN:=}"
+: "${MIGRATE_TO:=}"
 
 if ! sudo -n true 2>/dev/null; then
   echo "sudo 需要可用（建议先执行一次: sudo -v）" >&2
@@ -20,21 +21,12 @@ if [[ ! -x ./control ]]; then
   exit 2
 fi
 
-MIG_ARGS=(migrate --img-dir "$IMG_DIR" --a-name "$A_NAME" --b-name "$B_NAME" --image "$IMAGE" --src-port "$SRC_PORT" --dst-port "$DST_PORT")
+MIG_ARGS=(migrate --img-dir "$IMG_DIR" --a-name "$A_NAME" --b-name "$B_NAME" --image "$IMAGE" --src-port "$SRC_PORT" --dst-port "$DST_PORT" --client-out client.out)
 if [[ -n "$CRIU_HOST_BIN" ]]; then
   MIG_ARGS+=(--criu-host-bin "$CRIU_HOST_BIN")
 fi
-sudo ./control "${MIG_ARGS[@]}"
-
-LOG=client.out
-if [[ -f "$LOG" ]]; then
-  deadline=$((SECONDS+30))
-  while (( SECONDS < deadline )); do
-    if grep -q "服务中断" "$LOG"; then
-      grep "服务中断" "$LOG" | tail -n 1
-      exit 0
-    fi
-    sleep 0.2
-  done
-  echo "no downtime line yet; check: tail -f $LOG" >&2
+if [[ -n "$MIGRATE_TO" ]]; then
+  MIG_ARGS+=(--to "$MIGRATE_TO")
 fi
+# control 会等待 client.out 中本次迁移的“服务中断”汇总，打印并写进迁移报告（reports/<id>.json）。
+sudo ./control "${MIG_ARGS[@]}"